@@ -9,12 +9,15 @@ import (
 )
 
 func main() {
-	fmt.Println("=== Manual QA Test for Raja Mantri Chor Sipahi Game ===\n")
+	fmt.Print("=== Manual QA Test for Raja Mantri Chor Sipahi Game ===\n\n")
 
 	// Test 1: Create a room with 4 hardcoded players
 	fmt.Println("Test 1: Creating room with 4 players...")
 	rm := store.NewRoomManager()
-	room := rm.CreateRoom("QA-TEST")
+	room, err := rm.CreateRoom("QA-TEST")
+	if err != nil {
+		log.Fatalf("Error creating room: %v", err)
+	}
 
 	players := []struct {
 		ID   string
@@ -28,10 +31,7 @@ func main() {
 
 	for _, p := range players {
 		player := store.Player{
-			ID:    p.ID,
-			Name:  p.Name,
-			Role:  "", // No role initially
-			Score: 0,
+			Identity: store.Identity{ID: p.ID, Name: p.Name},
 		}
 		room.AddPlayer(player)
 		fmt.Printf("  ✓ Added player: %s (ID: %s)\n", p.Name, p.ID)
@@ -42,7 +42,9 @@ func main() {
 
 	// Test 2: Assign roles randomly
 	fmt.Println("Test 2: Assigning roles randomly...")
-	game.AssignRoles(room)
+	if err := game.AssignRoles(room); err != nil {
+		log.Fatalf("Error assigning roles: %v", err)
+	}
 
 	// Get updated players and display roles
 	updatedPlayers := room.GetPlayers()
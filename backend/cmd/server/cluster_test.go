@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/broker"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/handlers"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/roomcode"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// newClusterInstance starts one server instance of a cluster sharing
+// server, set up as main sets it up with REDIS_URL
+func newClusterInstance(t *testing.T, server *miniredis.Miniredis) *testServer {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	hub, err := handlers.NewHubWithBroker(broker.NewRedis(client, "test"), handlers.DefaultRoomInboxSize)
+	if err != nil {
+		t.Fatalf("Failed to create hub: %v", err)
+	}
+	app := handlers.NewServer(store.NewRoomManager(), hub)
+
+	r := newRouter(app)
+	r.HandleFunc("/ws/{roomId}", app.HandleWebSocket).Methods("GET")
+	r.Use(app.RouteToOwner)
+	ts := httptest.NewServer(r)
+
+	directory := broker.NewRedisDirectory(client, "test", broker.DefaultClaimTTL)
+	stop, err := app.JoinCluster(directory, ts.URL, handlers.DefaultClaimRefresh)
+	if err != nil {
+		t.Fatalf("Failed to join the cluster: %v", err)
+	}
+
+	t.Cleanup(func() {
+		stop()
+		ts.Close()
+		hub.Close()
+		client.Close()
+	})
+	return &testServer{Server: ts, app: app}
+}
+
+// postAs sends a JSON request with a session token and returns the status
+func postAs(t *testing.T, url string, body string, token string) int {
+	t.Helper()
+
+	req, _ := http.NewRequest("POST", url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request to %s failed: %v", url, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// TestRoomAcrossInstances tests that players of one room reaching different
+// instances all play the same room and see the same events
+func TestRoomAcrossInstances(t *testing.T) {
+	server := miniredis.RunT(t)
+	first := newClusterInstance(t, server)
+	second := newClusterInstance(t, server)
+
+	resp, err := http.Post(first.URL+"/room/create", "application/json", strings.NewReader(`{"playerName":"Host","players":3}`))
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+	var host testSession
+	json.NewDecoder(resp.Body).Decode(&host)
+	resp.Body.Close()
+
+	// Bob's requests reach the second instance, which doesn't hold the room
+	bob, err := joinTestRoom(second.URL, host.RoomID, "Bob")
+	if err != nil || bob.Token == "" {
+		t.Fatalf("Failed to join through the second instance: %v %+v", err, bob)
+	}
+	if _, err := joinTestRoom(first.URL, host.RoomID, "Carol"); err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+	if second.app.Hub().GetClientCount(host.RoomID) != 0 || first.app.Hub().GetClientCount(host.RoomID) != 0 {
+		t.Fatal("Expected nobody to be connected yet")
+	}
+
+	dialer := websocket.Dialer{}
+	hostConn, _, err := dialer.Dial(wsURLFor(first.URL, host), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect the host: %v", err)
+	}
+	defer hostConn.Close()
+	bobConn, _, err := dialer.Dial(wsURLFor(second.URL, bob), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect Bob through the second instance: %v", err)
+	}
+	defer bobConn.Close()
+
+	snapshot := readMessageOfType(t, bobConn, "SNAPSHOT")
+	room, _ := snapshot["payload"].(map[string]interface{})["room"].(map[string]interface{})
+	if players, _ := room["players"].([]interface{}); len(players) != 3 {
+		t.Errorf("Expected Bob's snapshot to show all three players, got %d", len(players))
+	}
+
+	// The host starts the game through the second instance
+	if status := postAs(t, second.URL+"/game/start", `{"roomId":"`+host.RoomID+`"}`, host.Token); status != http.StatusOK {
+		t.Fatalf("Expected the game to start through the second instance, got %d", status)
+	}
+	for name, conn := range map[string]*websocket.Conn{"host": hostConn, "Bob": bobConn} {
+		readMessageOfType(t, conn, "GAME_START")
+		if role := readMessageOfType(t, conn, "YOUR_ROLE"); role["payload"] == nil {
+			t.Errorf("Expected %s to be told their role", name)
+		}
+	}
+
+	// Closing the room frees it on every instance
+	if status := postAs(t, second.URL+"/room/close", `{"roomId":"`+host.RoomID+`"}`, host.Token); status != http.StatusOK {
+		t.Fatalf("Expected the host to close the room through the second instance, got %d", status)
+	}
+	readMessageOfType(t, bobConn, "ROOM_CLOSED")
+	for _, instance := range []*testServer{first, second} {
+		resp, err := http.Get(instance.URL + "/room/" + host.RoomID)
+		if err != nil {
+			t.Fatalf("Failed to get room: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected the closed room to be gone, got %d", resp.StatusCode)
+		}
+	}
+}
+
+// TestRoomCodesAcrossInstances tests that instances never give two live
+// rooms the same code
+func TestRoomCodesAcrossInstances(t *testing.T) {
+	server := miniredis.RunT(t)
+	first := newClusterInstance(t, server)
+	second := newClusterInstance(t, server)
+
+	codes, err := roomcode.NewWords([]string{"OTTER", "PANDA"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.app.SetRoomCodes(codes)
+	second.app.SetRoomCodes(codes)
+
+	alice, err := createTestRoom(first.URL, "Alice")
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+	bob, err := createTestRoom(second.URL, "Bob")
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+	if alice.RoomID == bob.RoomID {
+		t.Fatalf("Expected distinct codes, got %s twice", alice.RoomID)
+	}
+
+	for _, instance := range []*testServer{first, second} {
+		resp, err := http.Post(instance.URL+"/room/create", "application/json", strings.NewReader(`{"playerName":"Carol"}`))
+		if err != nil {
+			t.Fatalf("Failed to create room: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("Expected 503 with every code taken across the instances, got %d", resp.StatusCode)
+		}
+		if live := instance.app.GetRoomStats().Live; live != 1 {
+			t.Errorf("Expected each instance to hold one room, got %d", live)
+		}
+	}
+}
@@ -3,19 +3,42 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 
 	"github.com/bit2swaz/codechef-recruit/backend/internal/handlers"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/roomcode"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
 	"github.com/gorilla/mux"
 )
 
+// newTestApp returns a Server with its own hub, keeping rooms in memory
+func newTestApp() *handlers.Server {
+	return handlers.NewServer(store.NewRoomManager(), handlers.NewHub())
+}
+
 func setupRouter() *mux.Router {
+	return newRouter(newTestApp())
+}
+
+// newRouter registers app's HTTP handlers
+func newRouter(app *handlers.Server) *mux.Router {
 	r := mux.NewRouter()
-	r.HandleFunc("/room/create", handlers.CreateRoom).Methods("POST")
-	r.HandleFunc("/room/join", handlers.JoinRoom).Methods("POST")
-	r.HandleFunc("/room/{roomId}", handlers.GetRoom).Methods("GET")
+	r.HandleFunc("/room/create", app.CreateRoom).Methods("POST")
+	r.HandleFunc("/room/join", app.JoinRoom).Methods("POST")
+	r.HandleFunc("/room/leave", app.LeaveRoom).Methods("POST")
+	r.HandleFunc("/room/kick", app.KickPlayer).Methods("POST")
+	r.HandleFunc("/room/transfer", app.TransferHost).Methods("POST")
+	r.HandleFunc("/room/lock", app.LockRoom).Methods("POST")
+	r.HandleFunc("/room/close", app.CloseRoom).Methods("POST")
+	r.HandleFunc("/room/{roomId}", app.GetRoom).Methods("GET")
+	r.HandleFunc("/game/start", app.StartGame).Methods("POST")
+	r.HandleFunc("/game/guess", app.SubmitGuess).Methods("POST")
+	r.HandleFunc("/game/next", app.NextRound).Methods("POST")
+	r.HandleFunc("/events/{roomId}", app.HandleEvents).Methods("GET")
 	return r
 }
 
@@ -287,6 +310,41 @@ func TestJoinRoomFull(t *testing.T) {
 	t.Logf("Correctly rejected 5th player with error: %s", response["error"])
 }
 
+// TestJoinRoomConcurrent races more joins than there are seats, and checks
+// exactly the free seats are filled
+func TestJoinRoomConcurrent(t *testing.T) {
+	router := setupRouter()
+	roomID := createRoomWith(t, router, map[string]interface{}{"playerName": "Host"})["roomId"]
+
+	const joins = 16
+	codes := make(chan int, joins)
+	var wg sync.WaitGroup
+	for i := range joins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr := postJSON(router, "/room/join", map[string]string{"roomId": roomID, "playerName": fmt.Sprintf("Player%d", i)}, "")
+			codes <- rr.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	joined := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			joined++
+		case http.StatusBadRequest:
+		default:
+			t.Errorf("Unexpected status %d", code)
+		}
+	}
+	if joined != 3 {
+		t.Errorf("Expected 3 joins to fill the 4-seat room, got %d", joined)
+	}
+}
+
 // TestGetRoom tests GET /room/{roomId} endpoint
 func TestGetRoom(t *testing.T) {
 	router := setupRouter()
@@ -360,3 +418,423 @@ func TestGetRoom(t *testing.T) {
 
 	t.Logf("Successfully retrieved room %s with %d players", roomID, len(players))
 }
+
+// postJSON sends a JSON POST through the router, with a bearer token if one is given
+func postJSON(router *mux.Router, path string, body interface{}, token string) *httptest.ResponseRecorder {
+	jsonBody, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// createRoomWith creates a room from the given request body and returns the
+// create response
+func createRoomWith(t *testing.T, router *mux.Router, body map[string]interface{}) map[string]string {
+	t.Helper()
+
+	rr := postJSON(router, "/room/create", body, "")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Failed to create room: %d %s", rr.Code, rr.Body.String())
+	}
+
+	var response map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	return response
+}
+
+// TestCreateRoomWithRounds tests that the match length is stored and reported
+func TestCreateRoomWithRounds(t *testing.T) {
+	router := setupRouter()
+
+	roomID := createRoomWith(t, router, map[string]interface{}{"playerName": "Alice", "rounds": 5})["roomId"]
+
+	getReq, _ := http.NewRequest("GET", "/room/"+roomID, nil)
+	getRR := httptest.NewRecorder()
+	router.ServeHTTP(getRR, getReq)
+
+	var details struct {
+		TotalRounds  int `json:"totalRounds"`
+		RoundsPlayed int `json:"roundsPlayed"`
+	}
+	json.Unmarshal(getRR.Body.Bytes(), &details)
+
+	if details.TotalRounds != 5 {
+		t.Errorf("Expected totalRounds 5, got %d", details.TotalRounds)
+	}
+	if details.RoundsPlayed != 0 {
+		t.Errorf("Expected roundsPlayed 0, got %d", details.RoundsPlayed)
+	}
+}
+
+// TestCreateRoomInvalidRounds tests that out-of-range match lengths are rejected
+func TestCreateRoomInvalidRounds(t *testing.T) {
+	router := setupRouter()
+
+	for _, rounds := range []int{-1, 21} {
+		rr := postJSON(router, "/room/create", map[string]interface{}{"playerName": "Alice", "rounds": rounds}, "")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("rounds=%d: got status %v want %v", rounds, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// TestCreateRoomCodes tests that live rooms never share a code, and that
+// creating a room fails cleanly once every code is taken
+func TestCreateRoomCodes(t *testing.T) {
+	app := newTestApp()
+	router := newRouter(app)
+
+	codes, err := roomcode.NewWords([]string{"OTTER", "PANDA"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetRoomCodes(codes)
+
+	first := createRoomWith(t, router, map[string]interface{}{"playerName": "Alice"})
+	second := createRoomWith(t, router, map[string]interface{}{"playerName": "Bob"})
+	if first["roomId"] == second["roomId"] {
+		t.Fatalf("Expected distinct codes, got %s twice", first["roomId"])
+	}
+
+	rr := postJSON(router, "/room/create", map[string]interface{}{"playerName": "Carol"}, "")
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with every code taken, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Closing a room frees its code
+	if rr := postJSON(router, "/room/close", map[string]string{"roomId": first["roomId"]}, first["token"]); rr.Code != http.StatusOK {
+		t.Fatalf("Failed to close room: %d %s", rr.Code, rr.Body.String())
+	}
+	if third := createRoomWith(t, router, map[string]interface{}{"playerName": "Carol"}); third["roomId"] != first["roomId"] {
+		t.Errorf("Expected the freed code %s, got %s", first["roomId"], third["roomId"])
+	}
+}
+
+// TestNextRoundBeforeStart tests POST /game/next on a room that hasn't played a round
+func TestNextRoundBeforeStart(t *testing.T) {
+	router := setupRouter()
+
+	created := createRoomWith(t, router, map[string]interface{}{"playerName": "Alice"})
+
+	rr := postJSON(router, "/game/next", map[string]string{"roomId": created["roomId"]}, created["token"])
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+}
+
+// TestCreateRoomScoringPolicy tests selecting and validating a scoring policy
+func TestCreateRoomScoringPolicy(t *testing.T) {
+	router := setupRouter()
+
+	testCases := []struct {
+		Scoring        string
+		ExpectedStatus int
+	}{
+		{"", http.StatusCreated},
+		{"sipahi-bonus", http.StatusCreated},
+		{"no-such-policy", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		rr := postJSON(router, "/room/create", map[string]string{"playerName": "Alice", "scoring": tc.Scoring}, "")
+		if rr.Code != tc.ExpectedStatus {
+			t.Errorf("scoring=%q: got status %v want %v", tc.Scoring, rr.Code, tc.ExpectedStatus)
+		}
+	}
+}
+
+// TestJoinRoomCapFollowsRoleTable tests that the join cap follows the room's player count
+func TestJoinRoomCapFollowsRoleTable(t *testing.T) {
+	router := setupRouter()
+
+	created := createRoomWith(t, router, map[string]interface{}{"playerName": "Player1", "players": 6})
+	roomID := created["roomId"]
+
+	for i := 2; i <= 6; i++ {
+		rr := postJSON(router, "/room/join", map[string]string{"roomId": roomID, "playerName": "Player" + string(rune(i+48))}, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Failed to add player %d: %d", i, rr.Code)
+		}
+	}
+
+	rr := postJSON(router, "/room/join", map[string]string{"roomId": roomID, "playerName": "Player7"}, "")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 7th player to be rejected with %v, got %v", http.StatusBadRequest, rr.Code)
+	}
+
+	startRR := postJSON(router, "/game/start", map[string]string{"roomId": roomID}, created["token"])
+	if startRR.Code != http.StatusOK {
+		t.Errorf("Expected 6-player game to start, got %v: %s", startRR.Code, startRR.Body.String())
+	}
+}
+
+// TestCreateRoomInvalidPlayerCount tests that unsupported player counts are rejected
+func TestCreateRoomInvalidPlayerCount(t *testing.T) {
+	router := setupRouter()
+
+	for _, players := range []int{2, 9, -1} {
+		rr := postJSON(router, "/room/create", map[string]interface{}{"playerName": "Alice", "players": players}, "")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("players=%d: got status %v want %v", players, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// TestStartGameNotEnoughPlayers tests that starting a partially filled room fails
+func TestStartGameNotEnoughPlayers(t *testing.T) {
+	router := setupRouter()
+
+	created := createRoomWith(t, router, map[string]interface{}{"playerName": "Alice", "players": 3})
+
+	rr := postJSON(router, "/game/start", map[string]string{"roomId": created["roomId"]}, created["token"])
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+// TestStartGameTwiceConflict tests that restarting a game mid-guess returns 409
+func TestStartGameTwiceConflict(t *testing.T) {
+	router := setupRouter()
+
+	created := createRoomWith(t, router, map[string]interface{}{"playerName": "Player1", "players": 3})
+	roomID := created["roomId"]
+
+	postJSON(router, "/room/join", map[string]string{"roomId": roomID, "playerName": "Player2"}, "")
+	postJSON(router, "/room/join", map[string]string{"roomId": roomID, "playerName": "Player3"}, "")
+
+	if rr := postJSON(router, "/game/start", map[string]string{"roomId": roomID}, created["token"]); rr.Code != http.StatusOK {
+		t.Fatalf("Expected first start to succeed, got %v", rr.Code)
+	}
+	if rr := postJSON(router, "/game/start", map[string]string{"roomId": roomID}, created["token"]); rr.Code != http.StatusConflict {
+		t.Errorf("Expected second start to return %v, got %v", http.StatusConflict, rr.Code)
+	}
+}
+
+// TestCreateAndJoinIssueSessions tests that create and join return a player ID and token
+func TestCreateAndJoinIssueSessions(t *testing.T) {
+	router := setupRouter()
+
+	created := createRoomWith(t, router, map[string]interface{}{"playerName": "Alice"})
+	if created["playerId"] == "" || created["token"] == "" {
+		t.Fatalf("Expected playerId and token in create response, got %v", created)
+	}
+
+	rr := postJSON(router, "/room/join", map[string]string{"roomId": created["roomId"], "playerName": "Bob"}, "")
+	var joined map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &joined)
+
+	if joined["playerId"] == "" || joined["token"] == "" {
+		t.Fatalf("Expected playerId and token in join response, got %v", joined)
+	}
+	if joined["playerId"] == created["playerId"] || joined["token"] == created["token"] {
+		t.Error("Expected each player to get their own ID and token")
+	}
+
+	session, err := handlers.VerifySessionToken(joined["token"])
+	if err != nil {
+		t.Fatalf("Failed to verify join token: %v", err)
+	}
+	if session.RoomID != created["roomId"] || session.PlayerID != joined["playerId"] {
+		t.Errorf("Token carries wrong identity: %+v", session)
+	}
+}
+
+// TestGameActionsRequireSession tests the 401/403 responses of protected endpoints
+func TestGameActionsRequireSession(t *testing.T) {
+	router := setupRouter()
+
+	created := createRoomWith(t, router, map[string]interface{}{"playerName": "Alice"})
+	other := createRoomWith(t, router, map[string]interface{}{"playerName": "Mallory"})
+	roomID := created["roomId"]
+
+	tamperedToken := created["token"][:len(created["token"])-2] + "xx"
+
+	testCases := []struct {
+		Name           string
+		Path           string
+		Body           map[string]string
+		Token          string
+		ExpectedStatus int
+	}{
+		{"Start without token", "/game/start", map[string]string{"roomId": roomID}, "", http.StatusUnauthorized},
+		{"Start with tampered token", "/game/start", map[string]string{"roomId": roomID}, tamperedToken, http.StatusUnauthorized},
+		{"Start with other room's token", "/game/start", map[string]string{"roomId": roomID}, other["token"], http.StatusForbidden},
+		{"Next round without token", "/game/next", map[string]string{"roomId": roomID}, "", http.StatusUnauthorized},
+		{"Guess without token", "/game/guess", map[string]string{
+			"roomId": roomID, "mantriPlayerId": created["playerId"], "guessedChorPlayerId": "someone",
+		}, "", http.StatusUnauthorized},
+		{"Guess as someone else", "/game/guess", map[string]string{
+			"roomId": roomID, "mantriPlayerId": "not-me", "guessedChorPlayerId": "someone",
+		}, created["token"], http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			rr := postJSON(router, tc.Path, tc.Body, tc.Token)
+			if rr.Code != tc.ExpectedStatus {
+				t.Errorf("got status %v want %v: %s", rr.Code, tc.ExpectedStatus, rr.Body.String())
+			}
+		})
+	}
+}
+
+// TestRoomAdminActions tests that kick, transfer, lock and close are host-only
+func TestRoomAdminActions(t *testing.T) {
+	router := setupRouter()
+
+	host := createRoomWith(t, router, map[string]interface{}{"playerName": "Alice"})
+	roomID := host["roomId"]
+
+	join := func(name string) map[string]string {
+		rr := postJSON(router, "/room/join", map[string]string{"roomId": roomID, "playerName": name}, "")
+		var joined map[string]string
+		json.Unmarshal(rr.Body.Bytes(), &joined)
+		return joined
+	}
+	bob := join("Bob")
+	carol := join("Carol")
+
+	testCases := []struct {
+		Name           string
+		Path           string
+		Body           map[string]interface{}
+		Token          string
+		ExpectedStatus int
+	}{
+		{"Start as non-host", "/game/start", map[string]interface{}{"roomId": roomID}, bob["token"], http.StatusForbidden},
+		{"Kick as non-host", "/room/kick", map[string]interface{}{"roomId": roomID, "playerId": carol["playerId"]}, bob["token"], http.StatusForbidden},
+		{"Lock as non-host", "/room/lock", map[string]interface{}{"roomId": roomID}, bob["token"], http.StatusForbidden},
+		{"Close as non-host", "/room/close", map[string]interface{}{"roomId": roomID}, bob["token"], http.StatusForbidden},
+		{"Host kicks themselves", "/room/kick", map[string]interface{}{"roomId": roomID, "playerId": host["playerId"]}, host["token"], http.StatusBadRequest},
+		{"Kick unknown player", "/room/kick", map[string]interface{}{"roomId": roomID, "playerId": "ghost"}, host["token"], http.StatusNotFound},
+		{"Kick player", "/room/kick", map[string]interface{}{"roomId": roomID, "playerId": carol["playerId"]}, host["token"], http.StatusOK},
+		{"Kicked player loses session", "/room/lock", map[string]interface{}{"roomId": roomID}, carol["token"], http.StatusForbidden},
+		{"Lock room", "/room/lock", map[string]interface{}{"roomId": roomID}, host["token"], http.StatusOK},
+		{"Transfer host", "/room/transfer", map[string]interface{}{"roomId": roomID, "playerId": bob["playerId"]}, host["token"], http.StatusOK},
+		{"Old host can't unlock", "/room/lock", map[string]interface{}{"roomId": roomID, "locked": false}, host["token"], http.StatusForbidden},
+		{"New host unlocks", "/room/lock", map[string]interface{}{"roomId": roomID, "locked": false}, bob["token"], http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			rr := postJSON(router, tc.Path, tc.Body, tc.Token)
+			if rr.Code != tc.ExpectedStatus {
+				t.Errorf("got status %v want %v: %s", rr.Code, tc.ExpectedStatus, rr.Body.String())
+			}
+		})
+	}
+
+	req, _ := http.NewRequest("GET", "/room/"+roomID, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var details handlers.RoomDetailsResponse
+	json.Unmarshal(rr.Body.Bytes(), &details)
+	if details.HostID != bob["playerId"] || details.Locked || len(details.Players) != 2 {
+		t.Errorf("Unexpected room details after admin actions: %+v", details)
+	}
+	for _, p := range details.Players {
+		want := "player"
+		if p.ID == bob["playerId"] {
+			want = "host"
+		}
+		if p.Permission != want {
+			t.Errorf("Player %s: expected permission %q, got %q", p.Name, want, p.Permission)
+		}
+	}
+
+	if rr := postJSON(router, "/room/close", map[string]interface{}{"roomId": roomID}, bob["token"]); rr.Code != http.StatusOK {
+		t.Fatalf("Close room: got status %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := postJSON(router, "/room/join", map[string]string{"roomId": roomID, "playerName": "Dave"}, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected join after close to return 404, got %v", rr.Code)
+	}
+}
+
+// TestJoinLockedRoom tests that a locked room refuses new players
+func TestJoinLockedRoom(t *testing.T) {
+	router := setupRouter()
+
+	host := createRoomWith(t, router, map[string]interface{}{"playerName": "Alice"})
+	if rr := postJSON(router, "/room/lock", map[string]interface{}{"roomId": host["roomId"], "locked": true}, host["token"]); rr.Code != http.StatusOK {
+		t.Fatalf("Lock room: got status %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr := postJSON(router, "/room/join", map[string]string{"roomId": host["roomId"], "playerName": "Bob"}, "")
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 joining a locked room, got %v: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// TestLeaveRoom tests POST /room/leave: host hand-over, the mid-match abort
+// policy and deleting the room when the last player goes
+func TestLeaveRoom(t *testing.T) {
+	router := setupRouter()
+
+	host := createRoomWith(t, router, map[string]interface{}{"playerName": "Alice", "players": 3})
+	roomID := host["roomId"]
+
+	var others []map[string]string
+	for _, name := range []string{"Bob", "Carol"} {
+		rr := postJSON(router, "/room/join", map[string]string{"roomId": roomID, "playerName": name}, "")
+		var joined map[string]string
+		json.Unmarshal(rr.Body.Bytes(), &joined)
+		others = append(others, joined)
+	}
+
+	getDetails := func() (int, handlers.RoomDetailsResponse) {
+		req, _ := http.NewRequest("GET", "/room/"+roomID, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var details handlers.RoomDetailsResponse
+		json.Unmarshal(rr.Body.Bytes(), &details)
+		return rr.Code, details
+	}
+
+	if rr := postJSON(router, "/game/start", map[string]string{"roomId": roomID}, host["token"]); rr.Code != http.StatusOK {
+		t.Fatalf("Start game: got status %v: %s", rr.Code, rr.Body.String())
+	}
+
+	// The host leaves mid-round: the round is abandoned and Bob takes over
+	if rr := postJSON(router, "/room/leave", map[string]string{"roomId": roomID}, host["token"]); rr.Code != http.StatusOK {
+		t.Fatalf("Leave: got status %v: %s", rr.Code, rr.Body.String())
+	}
+	_, details := getDetails()
+	if details.Status != "WAITING" {
+		t.Errorf("Expected room to go back to WAITING, got %s", details.Status)
+	}
+	if details.HostID != others[0]["playerId"] || len(details.Players) != 2 {
+		t.Errorf("Unexpected room after host left: %+v", details)
+	}
+
+	// The old host's session no longer works
+	if rr := postJSON(router, "/room/leave", map[string]string{"roomId": roomID}, host["token"]); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 leaving twice, got %v", rr.Code)
+	}
+
+	// A replacement can take the seat and the new host restarts the match
+	rr := postJSON(router, "/room/join", map[string]string{"roomId": roomID, "playerName": "Dave"}, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Join replacement: got status %v: %s", rr.Code, rr.Body.String())
+	}
+	var dave map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &dave)
+	if rr := postJSON(router, "/game/start", map[string]string{"roomId": roomID}, others[0]["token"]); rr.Code != http.StatusOK {
+		t.Fatalf("Restart game: got status %v: %s", rr.Code, rr.Body.String())
+	}
+
+	// Once everyone has gone the room is deleted
+	for _, player := range append(others, dave) {
+		if rr := postJSON(router, "/room/leave", map[string]string{"roomId": roomID}, player["token"]); rr.Code != http.StatusOK {
+			t.Fatalf("Leave: got status %v: %s", rr.Code, rr.Body.String())
+		}
+	}
+	if code, _ := getDetails(); code != http.StatusNotFound {
+		t.Errorf("Expected room to be deleted after everyone left, got status %v", code)
+	}
+}
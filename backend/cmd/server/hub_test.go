@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/broker"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/handlers"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+	"github.com/redis/go-redis/v9"
+)
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestHubSlowConsumerEviction tests that a client that stops reading is
+// evicted without holding up the rest of the room
+func TestHubSlowConsumerEviction(t *testing.T) {
+	hub := handlers.NewHub()
+
+	fast := &handlers.Client{RoomID: "SLOW", PlayerID: "fast", Send: make(chan []byte, 64)}
+	slow := &handlers.Client{RoomID: "SLOW", PlayerID: "slow", Send: make(chan []byte, 1)}
+	hub.Register(fast)
+	hub.Register(slow)
+
+	for i := 0; i < 10; i++ {
+		hub.BroadcastToRoom("SLOW", []byte(fmt.Sprintf("message %d", i)))
+	}
+
+	waitFor(t, "fast client to get every message", func() bool { return len(fast.Send) == 10 })
+	if stats := hub.Stats(); stats.Evicted != 1 {
+		t.Errorf("Expected 1 eviction, got %d", stats.Evicted)
+	}
+	if count := hub.GetClientCount("SLOW"); count != 1 {
+		t.Errorf("Expected 1 client left receiving, got %d", count)
+	}
+
+	// The evicted client still unregisters itself, and the room's actor
+	// retires once both are gone
+	hub.Unregister(slow)
+	hub.Unregister(fast)
+	waitFor(t, "room actor to retire", func() bool { return len(hub.GetAllRoomIDs()) == 0 })
+}
+
+// stalledRepository is a repository whose saves wait until release is
+// closed
+type stalledRepository struct {
+	*store.MemoryRepository
+	release chan struct{}
+}
+
+func (r *stalledRepository) SaveRoom(id string, state store.RoomState) error {
+	<-r.release
+	return r.MemoryRepository.SaveRoom(id, state)
+}
+
+// TestHubPresenceDoesNotWaitOnStore tests that a room's actor hands
+// presence changes off instead of waiting for them to be saved
+func TestHubPresenceDoesNotWaitOnStore(t *testing.T) {
+	repo := &stalledRepository{MemoryRepository: store.NewMemoryRepository(), release: make(chan struct{})}
+	repo.CreateRoom("STALL", store.RoomState{
+		Players: []store.Player{{Identity: store.Identity{ID: "p1", Name: "One"}, Seat: store.Seat{Permission: store.PermissionHost}}},
+		Status:  store.PhaseWaiting,
+	})
+	rooms, err := store.NewRepositoryStore(repo)
+	if err != nil {
+		t.Fatalf("NewRepositoryStore failed: %v", err)
+	}
+	hub := handlers.NewHub()
+	handlers.NewServer(rooms, hub)
+
+	client := &handlers.Client{RoomID: "STALL", PlayerID: "p1", Send: make(chan []byte, 64)}
+	registered := make(chan struct{})
+	go func() {
+		hub.Register(client)
+		hub.BroadcastToRoom("STALL", []byte("after"))
+		close(registered)
+	}()
+
+	select {
+	case <-registered:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Register to return while the presence change waits to be saved")
+	}
+	waitFor(t, "the broadcast to be delivered", func() bool { return len(client.Send) == 1 })
+
+	close(repo.release)
+	waitFor(t, "the presence change to be saved", func() bool {
+		return rooms.GetRoom("STALL").GetPlayers()[0].Presence.Status == store.PresenceOnline
+	})
+	hub.Unregister(client)
+}
+
+// TestHubSendToPlayer tests that private messages reach every connection of
+// one player and nobody else
+func TestHubSendToPlayer(t *testing.T) {
+	hub := handlers.NewHub()
+
+	phone := &handlers.Client{RoomID: "PRIV", PlayerID: "alice", Send: make(chan []byte, 4)}
+	laptop := &handlers.Client{RoomID: "PRIV", PlayerID: "alice", Send: make(chan []byte, 4)}
+	other := &handlers.Client{RoomID: "PRIV", PlayerID: "bob", Send: make(chan []byte, 4)}
+	for _, c := range []*handlers.Client{phone, laptop, other} {
+		hub.Register(c)
+	}
+
+	hub.SendToPlayer("PRIV", "alice", []byte("secret"))
+	hub.BroadcastToRoom("PRIV", []byte("public"))
+
+	waitFor(t, "public message", func() bool { return len(other.Send) == 1 })
+	if len(phone.Send) != 2 || len(laptop.Send) != 2 {
+		t.Errorf("Expected both of alice's connections to get 2 messages, got %d and %d", len(phone.Send), len(laptop.Send))
+	}
+	if msg := <-other.Send; string(msg) != "public" {
+		t.Errorf("Expected bob to only get the public message, got %q", msg)
+	}
+}
+
+// TestHubPresence tests that a player's presence follows their first and
+// last connection, not each one, and that they are only away once all of
+// their connections are
+func TestHubPresence(t *testing.T) {
+	hub := handlers.NewHub()
+	var changes []string
+	hub.OnPresenceChange(func(roomID string, playerID string, presence handlers.Presence) {
+		changes = append(changes, playerID+" "+string(presence.Status))
+	})
+
+	phone := &handlers.Client{RoomID: "PRES", PlayerID: "alice", Send: make(chan []byte, 4)}
+	laptop := &handlers.Client{RoomID: "PRES", PlayerID: "alice", Send: make(chan []byte, 4)}
+	other := &handlers.Client{RoomID: "PRES", PlayerID: "bob", Send: make(chan []byte, 4)}
+
+	online := store.PresenceOnline
+	steps := []struct {
+		name string
+		do   func() handlers.Presence
+		want handlers.Presence
+	}{
+		{"alice's phone connects", func() handlers.Presence { return hub.Register(phone) }, handlers.Presence{Status: online, Connections: 1, Players: 1}},
+		{"alice's laptop connects", func() handlers.Presence { return hub.Register(laptop) }, handlers.Presence{Status: online, Connections: 2, Players: 1}},
+		{"bob connects", func() handlers.Presence { return hub.Register(other) }, handlers.Presence{Status: online, Connections: 1, Players: 2}},
+		{"alice's phone goes away", func() handlers.Presence { return hub.SetAway(phone, true) }, handlers.Presence{Status: online, Connections: 2, Players: 2}},
+		{"alice's laptop goes away", func() handlers.Presence { return hub.SetAway(laptop, true) }, handlers.Presence{Status: store.PresenceAway, Connections: 2, Players: 2}},
+		{"alice's phone disconnects", func() handlers.Presence { return hub.Unregister(phone) }, handlers.Presence{Status: store.PresenceAway, Connections: 1, Players: 2}},
+		{"alice's laptop comes back", func() handlers.Presence { return hub.SetAway(laptop, false) }, handlers.Presence{Status: online, Connections: 1, Players: 2}},
+		{"alice's laptop disconnects", func() handlers.Presence { return hub.Unregister(laptop) }, handlers.Presence{Status: store.PresenceDisconnected, Connections: 0, Players: 1}},
+	}
+	for _, step := range steps {
+		if got := step.do(); got != step.want {
+			t.Errorf("%s: expected %+v, got %+v", step.name, step.want, got)
+		}
+	}
+
+	want := "[alice online bob online alice away alice online alice disconnected]"
+	if fmt.Sprint(changes) != want {
+		t.Errorf("Expected changes %s, got %v", want, changes)
+	}
+}
+
+// TestHubBroadcastNeverBlocks tests that broadcasting to a busy or empty
+// room returns straight away
+func TestHubBroadcastNeverBlocks(t *testing.T) {
+	hub := handlers.NewHubWithInbox(1)
+	hub.Register(&handlers.Client{RoomID: "BUSY", PlayerID: "p1", Send: make(chan []byte, 1)})
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10000; i++ {
+			hub.BroadcastToRoom("BUSY", []byte("x"))
+			hub.BroadcastToRoom("EMPTY", []byte("x"))
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("BroadcastToRoom blocked")
+	}
+}
+
+// newRedisHub starts a hub that shares events through server, standing in
+// for one server instance
+func newRedisHub(t *testing.T, server *miniredis.Miniredis) *handlers.Hub {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	hub, err := handlers.NewHubWithBroker(broker.NewRedis(client, "test"), handlers.DefaultRoomInboxSize)
+	if err != nil {
+		t.Fatalf("Failed to create hub: %v", err)
+	}
+
+	t.Cleanup(func() {
+		hub.Close()
+		client.Close()
+	})
+	return hub
+}
+
+// TestHubsShareBroker tests that hubs sharing a Redis broker deliver each
+// other's broadcasts, private messages and disconnects to the clients
+// registered with them. TestRoomAcrossInstances covers whole instances.
+func TestHubsShareBroker(t *testing.T) {
+	server := miniredis.RunT(t)
+	first := newRedisHub(t, server)
+	second := newRedisHub(t, server)
+
+	alice := &handlers.Client{RoomID: "MULTI", PlayerID: "alice", Send: make(chan []byte, 8)}
+	bob := &handlers.Client{RoomID: "MULTI", PlayerID: "bob", Send: make(chan []byte, 8)}
+	first.Register(alice)
+	second.Register(bob)
+
+	first.BroadcastToRoom("MULTI", []byte("public"))
+	second.SendToPlayer("MULTI", "alice", []byte("secret"))
+
+	waitFor(t, "alice to get both messages", func() bool { return len(alice.Send) == 2 })
+	waitFor(t, "bob to get the broadcast", func() bool { return len(bob.Send) == 1 })
+	// Events published by different instances have no order between them
+	got := map[string]bool{string(<-alice.Send): true, string(<-alice.Send): true}
+	if !got["public"] || !got["secret"] {
+		t.Errorf("Expected alice to get the broadcast and the private message, got %v", got)
+	}
+	if msg := <-bob.Send; string(msg) != "public" {
+		t.Errorf("Expected bob to only get the broadcast, got %q", msg)
+	}
+
+	// A kick handled by the second instance closes alice's connection on the first
+	second.DisconnectPlayer("MULTI", "alice", handlers.CloseKicked, "kicked from room")
+	waitFor(t, "alice to be disconnected", func() bool { return first.GetClientCount("MULTI") == 0 })
+	if count := second.GetClientCount("MULTI"); count != 1 {
+		t.Errorf("Expected bob to stay connected, got %d clients", count)
+	}
+}
+
+// BenchmarkHubFanOut measures broadcast throughput with many rooms of four
+// clients each, all broadcasting concurrently. Each op is one broadcast; the
+// timer covers delivery to every client.
+func BenchmarkHubFanOut(b *testing.B) {
+	for _, rooms := range []int{100, 1000, 5000} {
+		b.Run(fmt.Sprintf("rooms=%d", rooms), func(b *testing.B) {
+			const clientsPerRoom = 4
+			hub := handlers.NewHub()
+
+			var delivered atomic.Int64
+			stop := make(chan struct{})
+			defer close(stop)
+
+			roomIDs := make([]string, rooms)
+			for r := range roomIDs {
+				roomIDs[r] = fmt.Sprintf("R%d", r)
+				for p := 0; p < clientsPerRoom; p++ {
+					client := &handlers.Client{
+						RoomID:   roomIDs[r],
+						PlayerID: fmt.Sprintf("p%d", p),
+						Send:     make(chan []byte, 256),
+					}
+					hub.Register(client)
+					go func() {
+						for {
+							select {
+							case <-client.Send:
+								delivered.Add(1)
+							case <-stop:
+								return
+							}
+						}
+					}()
+				}
+			}
+
+			message := []byte(`{"type":"BENCH","payload":{}}`)
+			b.ResetTimer()
+
+			var next atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					hub.BroadcastToRoom(roomIDs[next.Add(1)%int64(rooms)], message)
+				}
+			})
+
+			// Wait for delivery, allowing for anything the hub shed
+			want := int64(b.N * clientsPerRoom)
+			for last, idle := int64(-1), 0; delivered.Load() < want && idle < 100; {
+				if n := delivered.Load(); n == last {
+					idle++
+				} else {
+					last, idle = n, 0
+				}
+				time.Sleep(time.Millisecond)
+			}
+			b.StopTimer()
+
+			stats := hub.Stats()
+			b.ReportMetric(float64(delivered.Load())/b.Elapsed().Seconds(), "deliveries/s")
+			b.ReportMetric(float64(stats.Dropped), "dropped")
+			b.ReportMetric(float64(stats.Evicted), "evicted")
+		})
+	}
+}
@@ -3,23 +3,112 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
+	"github.com/bit2swaz/codechef-recruit/backend/internal/broker"
 	"github.com/bit2swaz/codechef-recruit/backend/internal/handlers"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/roomcode"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store/sqlite"
 	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
-	handlers.InitHub()
+	// With REDIS_URL set, this is one of several instances behind a load
+	// balancer. The hub delivers room events through Redis, and each room's
+	// owner is recorded there, so any instance can send a room's requests on
+	// to the one holding it. INSTANCE_URL is the address the other instances
+	// reach this one at, e.g. http://10.0.0.5:8080
+	hub := handlers.NewHub()
+	var redisClient *redis.Client
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		opts, err := redis.ParseURL(redisURL)
+		if err != nil {
+			log.Fatalf("Invalid REDIS_URL: %v", err)
+		}
+		redisClient = redis.NewClient(opts)
+		hub, err = handlers.NewHubWithBroker(broker.NewRedis(redisClient, "codechef"), handlers.DefaultRoomInboxSize)
+		if err != nil {
+			log.Fatalf("Error subscribing to Redis: %v", err)
+		}
+		log.Printf("Sharing room events through Redis at %s", opts.Addr)
+	}
+
+	rooms := openRoomStore()
+	app := handlers.NewServer(rooms, hub)
+	if restored := rooms.Count(); restored > 0 {
+		log.Printf("Restored %d rooms", restored)
+	}
+
+	if redisClient != nil {
+		addr := os.Getenv("INSTANCE_URL")
+		if addr == "" {
+			log.Fatal("INSTANCE_URL is required with REDIS_URL")
+		}
+		directory := broker.NewRedisDirectory(redisClient, "codechef", broker.DefaultClaimTTL)
+		if _, err := app.JoinCluster(directory, addr, handlers.DefaultClaimRefresh); err != nil {
+			log.Fatalf("Error joining the cluster: %v", err)
+		}
+		log.Printf("Serving rooms as %s", addr)
+	}
+
+	// DISCONNECT_GRACE is how long a dropped player's seat is held, e.g. 45s
+	if grace, ok := durationEnv("DISCONNECT_GRACE"); ok {
+		app.SetDisconnectGrace(grace)
+	}
+
+	if codes := roomCodes(); codes != nil {
+		app.SetRoomCodes(codes)
+		log.Printf("Room codes drawn from %d possible codes", codes.Size())
+	}
+
+	// Rooms are reaped once they go ROOM_IDLE_TTL without activity or
+	// ROOM_FINISHED_TTL after their match ends; 0 turns either off, and a
+	// ROOM_SWEEP_INTERVAL of 0 turns the reaper off altogether
+	reaper := handlers.DefaultReaperConfig()
+	for name, setting := range map[string]*time.Duration{
+		"ROOM_IDLE_TTL":       &reaper.IdleTTL,
+		"ROOM_FINISHED_TTL":   &reaper.FinishedTTL,
+		"ROOM_EXPIRY_WARNING": &reaper.Warning,
+		"ROOM_SWEEP_INTERVAL": &reaper.SweepInterval,
+	} {
+		if d, ok := durationEnv(name); ok {
+			*setting = d
+		}
+	}
+	app.StartReaper(reaper)
+
+	// METRICS_ADDR serves the room and hub counters on a listener of their
+	// own, e.g. localhost:9090, which should not be reachable by players
+	if metricsAddr := os.Getenv("METRICS_ADDR"); metricsAddr != "" {
+		metrics := http.NewServeMux()
+		metrics.HandleFunc("GET /metrics", app.ServeMetrics)
+		go func() {
+			log.Printf("Metrics on http://%s/metrics", metricsAddr)
+			log.Fatal(http.ListenAndServe(metricsAddr, metrics))
+		}()
+	}
 
 	r := mux.NewRouter()
+	r.Use(app.RouteToOwner)
 
-	r.HandleFunc("/room/create", handlers.CreateRoom).Methods("POST")
-	r.HandleFunc("/room/join", handlers.JoinRoom).Methods("POST")
-	r.HandleFunc("/room/{roomId}", handlers.GetRoom).Methods("GET")
-	r.HandleFunc("/game/start", handlers.StartGame).Methods("POST")
-	r.HandleFunc("/game/guess", handlers.SubmitGuess).Methods("POST")
+	r.HandleFunc("/room/create", app.CreateRoom).Methods("POST")
+	r.HandleFunc("/room/join", app.JoinRoom).Methods("POST")
+	r.HandleFunc("/room/leave", app.LeaveRoom).Methods("POST")
+	r.HandleFunc("/room/kick", app.KickPlayer).Methods("POST")
+	r.HandleFunc("/room/transfer", app.TransferHost).Methods("POST")
+	r.HandleFunc("/room/lock", app.LockRoom).Methods("POST")
+	r.HandleFunc("/room/close", app.CloseRoom).Methods("POST")
+	r.HandleFunc("/room/{roomId}", app.GetRoom).Methods("GET")
+	r.HandleFunc("/game/start", app.StartGame).Methods("POST")
+	r.HandleFunc("/game/guess", app.SubmitGuess).Methods("POST")
+	r.HandleFunc("/game/next", app.NextRound).Methods("POST")
 
-	r.HandleFunc("/ws/{roomId}", handlers.HandleWebSocket).Methods("GET")
+	r.HandleFunc("/ws/{roomId}", app.HandleWebSocket).Methods("GET")
+	r.HandleFunc("/events/{roomId}", app.HandleEvents).Methods("GET")
 
 	port := ":8080"
 	log.Printf("Server starting on port %s", port)
@@ -28,3 +117,98 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// durationEnv parses the duration in an environment variable, if it is set
+func durationEnv(name string) (time.Duration, bool) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, false
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", name, err)
+	}
+	return d, true
+}
+
+// roomCodes returns the codes new rooms are given, or nil for the default
+// ones. ROOM_CODE_WORDS gives rooms codes of that many words, e.g.
+// OTTER-MAPLE-KITE; otherwise ROOM_CODE_LENGTH and ROOM_CODE_ALPHABET
+// change the length and characters of the default codes.
+func roomCodes() roomcode.Space {
+	words, length, alphabet := os.Getenv("ROOM_CODE_WORDS"), os.Getenv("ROOM_CODE_LENGTH"), os.Getenv("ROOM_CODE_ALPHABET")
+	if words == "" && length == "" && alphabet == "" {
+		return nil
+	}
+	if words != "" && (length != "" || alphabet != "") {
+		log.Fatal("Set ROOM_CODE_WORDS or ROOM_CODE_LENGTH and ROOM_CODE_ALPHABET, not both")
+	}
+
+	if words != "" {
+		count, err := strconv.Atoi(words)
+		if err != nil {
+			log.Fatalf("Invalid ROOM_CODE_WORDS: %v", err)
+		}
+		codes, err := roomcode.NewWords(roomcode.DefaultWords, count)
+		if err != nil {
+			log.Fatalf("Invalid ROOM_CODE_WORDS: %v", err)
+		}
+		return codes
+	}
+
+	n := roomcode.DefaultLength
+	if length != "" {
+		var err error
+		if n, err = strconv.Atoi(length); err != nil {
+			log.Fatalf("Invalid ROOM_CODE_LENGTH: %v", err)
+		}
+	}
+	if alphabet == "" {
+		alphabet = roomcode.DefaultAlphabet
+	}
+	codes, err := roomcode.NewCharset(alphabet, n)
+	if err != nil {
+		log.Fatalf("Invalid room code settings: %v", err)
+	}
+	return codes
+}
+
+// openRoomStore opens the store rooms are kept in. DATA_DIR keeps them in
+// a write-ahead log and snapshots in that directory; SQLITE_PATH keeps them
+// in a SQLite database. Otherwise they are only kept in memory.
+func openRoomStore() store.RoomStore {
+	dataDir, sqlitePath := os.Getenv("DATA_DIR"), os.Getenv("SQLITE_PATH")
+	var repo store.Repository
+	switch {
+	case dataDir != "" && sqlitePath != "":
+		log.Fatal("Set DATA_DIR or SQLITE_PATH, not both")
+
+	case dataDir != "":
+		journal, recovery, err := store.OpenJournal(dataDir, store.JournalOptions{})
+		if err != nil {
+			log.Fatalf("Error opening room journal in %s: %v", dataDir, err)
+		}
+		log.Printf("Keeping rooms in %s (%d log records replayed)", dataDir, recovery.Replayed)
+		if recovery.Discarded > 0 {
+			log.Printf("Discarded %d bytes of torn or corrupt room log", recovery.Discarded)
+		}
+		repo = journal
+
+	case sqlitePath != "":
+		sqliteRepo, err := sqlite.Open(sqlitePath)
+		if err != nil {
+			log.Fatalf("Error opening SQLite database %s: %v", sqlitePath, err)
+		}
+		log.Printf("Keeping rooms in SQLite database %s", sqlitePath)
+		repo = sqliteRepo
+
+	default:
+		return store.NewRoomManager()
+	}
+
+	rooms, err := store.NewRepositoryStore(repo)
+	if err != nil {
+		log.Fatalf("Error loading rooms: %v", err)
+	}
+	return rooms
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/handlers"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+	"github.com/gorilla/websocket"
+)
+
+// TestRoomReaper tests that an idle room's clients are warned before it
+// expires, then told it closed and disconnected once it has
+func TestRoomReaper(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	host, err := createTestRoom(server.URL, "Host")
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+	created := time.Now()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURLFor(server.URL, host), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	readMessageOfType(t, conn, "SNAPSHOT")
+
+	config := handlers.ReaperConfig{
+		ExpiryPolicy: store.ExpiryPolicy{IdleTTL: time.Hour},
+		Warning:      10 * time.Minute,
+	}
+	before := server.app.GetRoomStats()
+
+	server.app.SweepRooms(config, created.Add(55*time.Minute))
+	expiring := readMessageOfType(t, conn, "ROOM_EXPIRING")["payload"].(map[string]interface{})
+	if expiring["reason"] != "idle" {
+		t.Errorf("Expected an idle expiry warning, got %v", expiring)
+	}
+	if expiresAt := int64(expiring["expiresAt"].(float64)); expiresAt < created.Add(time.Hour).UnixMilli() {
+		t.Errorf("Expected the room to expire an hour after it was created, got %d", expiresAt)
+	}
+
+	server.app.SweepRooms(config, created.Add(2*time.Hour))
+	closed := readMessageOfType(t, conn, "ROOM_CLOSED")["payload"].(map[string]interface{})
+	if closed["reason"] != "room expired (idle)" {
+		t.Errorf("Expected ROOM_CLOSED for an idle room, got %v", closed)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, handlers.CloseRoomClosed) {
+				t.Errorf("Expected close code %d, got %v", handlers.CloseRoomClosed, err)
+			}
+			break
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/room/" + host.RoomID)
+	if err != nil {
+		t.Fatalf("Failed to get room: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the reaped room to be gone, got %d", resp.StatusCode)
+	}
+
+	// The counters are served at /metrics
+	recorder := httptest.NewRecorder()
+	server.app.ServeMetrics(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	var metrics handlers.Metrics
+	if err := json.NewDecoder(recorder.Body).Decode(&metrics); err != nil {
+		t.Fatalf("Failed to decode metrics: %v", err)
+	}
+	after := metrics.Rooms
+	if after.ReapedIdle <= before.ReapedIdle || after.Warned <= before.Warned || after.Sweeps != before.Sweeps+2 {
+		t.Errorf("Expected the sweeps, warning and reap to be counted, got %+v then %+v", before, after)
+	}
+	if after.Live != 0 {
+		t.Errorf("Expected no rooms left, got %d", after.Live)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/handlers"
+)
+
+// sseEvent is one event read off an SSE stream
+type sseEvent struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// sseStream reads events from an open SSE response
+type sseStream struct {
+	t      *testing.T
+	resp   *http.Response
+	events chan sseEvent
+}
+
+// openSSE connects to a room's event stream. lastEventID is sent as the
+// Last-Event-ID header if it isn't empty.
+func openSSE(t *testing.T, serverURL string, session testSession, lastEventID string) *sseStream {
+	t.Helper()
+
+	req, _ := http.NewRequest("GET", serverURL+"/events/"+session.RoomID, nil)
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	stream := &sseStream{t: t, resp: resp, events: make(chan sseEvent, 64)}
+	go func() {
+		defer close(stream.events)
+		scanner := bufio.NewScanner(resp.Body)
+		var event sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if event.Data != "" {
+					stream.events <- event
+				}
+				event = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				event.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.Data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return stream
+}
+
+func (s *sseStream) Close() {
+	s.resp.Body.Close()
+}
+
+// next returns the next event whose envelope type is messageType, or the
+// next named event if messageType is "close"
+func (s *sseStream) next(messageType string) (sseEvent, handlers.Envelope) {
+	s.t.Helper()
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case event, ok := <-s.events:
+			if !ok {
+				s.t.Fatalf("Stream ended waiting for %s", messageType)
+			}
+			if event.Event == "close" {
+				if messageType == "close" {
+					return event, handlers.Envelope{}
+				}
+				s.t.Fatalf("Stream closed waiting for %s: %s", messageType, event.Data)
+			}
+
+			var envelope handlers.Envelope
+			var header struct {
+				Type string `json:"type"`
+				Seq  uint64 `json:"seq"`
+			}
+			json.Unmarshal([]byte(event.Data), &header)
+			envelope.Type, envelope.Seq = header.Type, header.Seq
+			if envelope.Type == messageType {
+				return event, envelope
+			}
+		case <-timeout:
+			s.t.Fatalf("Timed out waiting for %s", messageType)
+		}
+	}
+}
+
+// TestSSEStream tests that the event stream carries the same numbered
+// events as the WebSocket, resumes from Last-Event-ID and tells the client
+// why the server ended it
+func TestSSEStream(t *testing.T) {
+	server := httptest.NewServer(setupRouter())
+	defer server.Close()
+
+	host, err := createTestRoom(server.URL, "Host")
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+
+	stream := openSSE(t, server.URL, host, "")
+	stream.next("CONNECTED")
+	if event, snapshot := stream.next("SNAPSHOT"); event.ID != "1" || snapshot.Seq != 1 {
+		t.Errorf("Expected the snapshot to carry id 1, got %+v", event)
+	}
+
+	guest, err := joinTestRoom(server.URL, host.RoomID, "Guest")
+	if err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+	joined, _ := stream.next("PLAYER_JOINED")
+	if !strings.Contains(joined.Data, guest.PlayerID) || joined.ID == "" {
+		t.Errorf("Expected a numbered PLAYER_JOINED for the guest, got %+v", joined)
+	}
+	stream.Close()
+
+	// A third player joins while the host's stream is down
+	third, err := joinTestRoom(server.URL, host.RoomID, "Third")
+	if err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+	resumed := openSSE(t, server.URL, host, joined.ID)
+	defer resumed.Close()
+	if missed, _ := resumed.next("PLAYER_JOINED"); !strings.Contains(missed.Data, third.PlayerID) {
+		t.Errorf("Expected the missed PLAYER_JOINED to be replayed, got %+v", missed)
+	}
+
+	guestStream := openSSE(t, server.URL, guest, "")
+	defer guestStream.Close()
+	guestStream.next("SNAPSHOT")
+
+	req, _ := http.NewRequest("POST", server.URL+"/room/kick",
+		strings.NewReader(`{"roomId":"`+host.RoomID+`","playerId":"`+guest.PlayerID+`"}`))
+	req.Header.Set("Authorization", "Bearer "+host.Token)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to kick guest: %v %v", err, resp)
+	}
+
+	guestStream.next("PLAYER_KICKED")
+	closing, _ := guestStream.next("close")
+	if !strings.Contains(closing.Data, `"code":4001`) {
+		t.Errorf("Expected the stream to close with code 4001, got %s", closing.Data)
+	}
+}
+
+func TestSSERejectsBadRequests(t *testing.T) {
+	server := httptest.NewServer(setupRouter())
+	defer server.Close()
+
+	host, err := createTestRoom(server.URL, "Host")
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+
+	testCases := []struct {
+		Name           string
+		Path           string
+		Token          string
+		LastEventID    string
+		ExpectedStatus int
+	}{
+		{"Missing token", "/events/" + host.RoomID, "", "", http.StatusUnauthorized},
+		{"Unknown room", "/events/ZZZZ", host.Token, "", http.StatusNotFound},
+		{"Malformed Last-Event-ID", "/events/" + host.RoomID, host.Token, "latest", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", server.URL+tc.Path, nil)
+			if tc.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.Token)
+			}
+			if tc.LastEventID != "" {
+				req.Header.Set("Last-Event-ID", tc.LastEventID)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.ExpectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.ExpectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
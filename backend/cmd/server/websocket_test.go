@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -13,39 +14,74 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// testServer is a test HTTP server and the Server behind it
+type testServer struct {
+	*httptest.Server
+	app *handlers.Server
+}
+
 // setupTestServer creates a test HTTP server with WebSocket support
-func setupTestServer() *httptest.Server {
-	// Initialize WebSocket hub
-	handlers.InitHub()
+func setupTestServer() *testServer {
+	app := newTestApp()
 
 	// Create router with WebSocket endpoint
 	r := mux.NewRouter()
-	r.HandleFunc("/ws/{roomId}", handlers.HandleWebSocket).Methods("GET")
-	r.HandleFunc("/room/create", handlers.CreateRoom).Methods("POST")
-	r.HandleFunc("/room/join", handlers.JoinRoom).Methods("POST")
-	r.HandleFunc("/room/{roomId}", handlers.GetRoom).Methods("GET")
+	r.HandleFunc("/ws/{roomId}", app.HandleWebSocket).Methods("GET")
+	r.HandleFunc("/room/create", app.CreateRoom).Methods("POST")
+	r.HandleFunc("/room/join", app.JoinRoom).Methods("POST")
+	r.HandleFunc("/room/leave", app.LeaveRoom).Methods("POST")
+	r.HandleFunc("/room/{roomId}", app.GetRoom).Methods("GET")
+	r.HandleFunc("/game/start", app.StartGame).Methods("POST")
 
 	// Create test server
-	return httptest.NewServer(r)
+	return &testServer{Server: httptest.NewServer(r), app: app}
+}
+
+// testSession is the identity returned by the create and join endpoints
+type testSession struct {
+	RoomID   string `json:"roomId"`
+	PlayerID string `json:"playerId"`
+	Token    string `json:"token"`
 }
 
-// createTestRoom creates a room via HTTP API and returns the room ID
-func createTestRoom(serverURL string, playerName string) (string, error) {
+// createTestRoom creates a room via HTTP API and returns the creator's session
+func createTestRoom(serverURL string, playerName string) (testSession, error) {
 	payload := `{"playerName":"` + playerName + `"}`
 	resp, err := http.Post(serverURL+"/room/create", "application/json", strings.NewReader(payload))
 	if err != nil {
-		return "", err
+		return testSession{}, err
 	}
 	defer resp.Body.Close()
 
-	var createResp struct {
-		RoomID string `json:"roomId"`
-	}
+	var createResp testSession
 	if err := json.NewDecoder(resp.Body).Decode(&createResp); err != nil {
-		return "", err
+		return testSession{}, err
 	}
 
-	return createResp.RoomID, nil
+	return createResp, nil
+}
+
+// joinTestRoom joins a room via HTTP API and returns the new player's session
+func joinTestRoom(serverURL string, roomID string, playerName string) (testSession, error) {
+	payload := `{"roomId":"` + roomID + `","playerName":"` + playerName + `"}`
+	resp, err := http.Post(serverURL+"/room/join", "application/json", strings.NewReader(payload))
+	if err != nil {
+		return testSession{}, err
+	}
+	defer resp.Body.Close()
+
+	var joinResp testSession
+	if err := json.NewDecoder(resp.Body).Decode(&joinResp); err != nil {
+		return testSession{}, err
+	}
+
+	return joinResp, nil
+}
+
+// wsURLFor builds the WebSocket URL for a session
+func wsURLFor(serverURL string, session testSession) string {
+	return "ws" + strings.TrimPrefix(serverURL, "http") + "/ws/" + session.RoomID +
+		"?playerId=" + url.QueryEscape(session.PlayerID) + "&token=" + url.QueryEscape(session.Token)
 }
 
 // TestWebSocketConnection tests basic WebSocket connection establishment
@@ -54,13 +90,13 @@ func TestWebSocketConnection(t *testing.T) {
 	defer server.Close()
 
 	// Create a room first
-	roomID, err := createTestRoom(server.URL, "TestPlayer")
+	session, err := createTestRoom(server.URL, "TestPlayer")
 	if err != nil {
 		t.Fatalf("Failed to create room: %v", err)
 	}
 
 	// Convert http://... to ws://...
-	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomID + "?playerId=test-player-1"
+	wsURL := wsURLFor(server.URL, session)
 
 	// Create WebSocket dialer
 	dialer := websocket.Dialer{}
@@ -86,12 +122,12 @@ func TestWebSocketSendMessage(t *testing.T) {
 	defer server.Close()
 
 	// Create a room first
-	roomID, err := createTestRoom(server.URL, "TestPlayer")
+	session, err := createTestRoom(server.URL, "TestPlayer")
 	if err != nil {
 		t.Fatalf("Failed to create room: %v", err)
 	}
 
-	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomID + "?playerId=test-player-2"
+	wsURL := wsURLFor(server.URL, session)
 
 	dialer := websocket.Dialer{}
 	conn, _, err := dialer.Dial(wsURL, nil)
@@ -103,7 +139,7 @@ func TestWebSocketSendMessage(t *testing.T) {
 	// Send a test message
 	testMessage := map[string]interface{}{
 		"type":     "test",
-		"playerId": "test-player-2",
+		"playerId": session.PlayerID,
 		"data":     map[string]string{"message": "Hello from test"},
 	}
 
@@ -129,15 +165,18 @@ func TestWebSocketMultipleClients(t *testing.T) {
 	defer server.Close()
 
 	// Create a room first
-	roomID, err := createTestRoom(server.URL, "TestPlayer")
+	session, err := createTestRoom(server.URL, "TestPlayer")
 	if err != nil {
 		t.Fatalf("Failed to create room: %v", err)
 	}
 
-	baseURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	second, err := joinTestRoom(server.URL, session.RoomID, "SecondPlayer")
+	if err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
 
 	// Connect first client
-	client1URL := baseURL + "/ws/" + roomID + "?playerId=client-1"
+	client1URL := wsURLFor(server.URL, session)
 	dialer := websocket.Dialer{}
 	conn1, _, err := dialer.Dial(client1URL, nil)
 	if err != nil {
@@ -146,7 +185,7 @@ func TestWebSocketMultipleClients(t *testing.T) {
 	defer conn1.Close()
 
 	// Connect second client
-	client2URL := baseURL + "/ws/" + roomID + "?playerId=client-2"
+	client2URL := wsURLFor(server.URL, second)
 	conn2, _, err := dialer.Dial(client2URL, nil)
 	if err != nil {
 		t.Fatalf("Failed to connect client 2: %v", err)
@@ -164,16 +203,15 @@ func TestWebSocketBroadcast(t *testing.T) {
 	server := setupTestServer()
 	defer server.Close()
 
-	baseURL := "ws" + strings.TrimPrefix(server.URL, "http")
-
 	// Create a room via HTTP API
-	roomID, err := createTestRoom(server.URL, "FirstPlayer")
+	session, err := createTestRoom(server.URL, "FirstPlayer")
 	if err != nil {
 		t.Fatalf("Failed to create room: %v", err)
 	}
+	roomID := session.RoomID
 
 	// Connect WebSocket client to this room
-	receiverURL := baseURL + "/ws/" + roomID + "?playerId=broadcast-receiver"
+	receiverURL := wsURLFor(server.URL, session)
 	dialer := websocket.Dialer{}
 	connReceiver, _, err := dialer.Dial(receiverURL, nil)
 	if err != nil {
@@ -240,12 +278,12 @@ func TestWebSocketCleanup(t *testing.T) {
 	defer server.Close()
 
 	// Create a room first
-	roomID, err := createTestRoom(server.URL, "TestPlayer")
+	session, err := createTestRoom(server.URL, "TestPlayer")
 	if err != nil {
 		t.Fatalf("Failed to create room: %v", err)
 	}
 
-	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomID + "?playerId=cleanup-client"
+	wsURL := wsURLFor(server.URL, session)
 
 	dialer := websocket.Dialer{}
 	conn, _, err := dialer.Dial(wsURL, nil)
@@ -276,12 +314,11 @@ func TestWebSocketConcurrentConnections(t *testing.T) {
 	defer server.Close()
 
 	// Create a room first
-	roomID, err := createTestRoom(server.URL, "TestPlayer")
+	session, err := createTestRoom(server.URL, "TestPlayer")
 	if err != nil {
 		t.Fatalf("Failed to create room: %v", err)
 	}
 
-	baseURL := "ws" + strings.TrimPrefix(server.URL, "http")
 	numClients := 10
 
 	connections := make([]*websocket.Conn, numClients)
@@ -289,7 +326,7 @@ func TestWebSocketConcurrentConnections(t *testing.T) {
 
 	// Connect multiple clients
 	for i := 0; i < numClients; i++ {
-		wsURL := baseURL + "/ws/" + roomID + "?playerId=concurrent-client-" + string(rune('A'+i))
+		wsURL := wsURLFor(server.URL, session)
 		conn, _, err := dialer.Dial(wsURL, nil)
 		if err != nil {
 			t.Fatalf("Failed to connect client %d: %v", i, err)
@@ -317,12 +354,12 @@ func TestWebSocketPingPong(t *testing.T) {
 	defer server.Close()
 
 	// Create a room first
-	roomID, err := createTestRoom(server.URL, "TestPlayer")
+	session, err := createTestRoom(server.URL, "TestPlayer")
 	if err != nil {
 		t.Fatalf("Failed to create room: %v", err)
 	}
 
-	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomID + "?playerId=ping-client"
+	wsURL := wsURLFor(server.URL, session)
 
 	dialer := websocket.Dialer{}
 	conn, _, err := dialer.Dial(wsURL, nil)
@@ -347,3 +384,834 @@ func TestWebSocketPingPong(t *testing.T) {
 	// If we get here without connection closing, ping/pong is working
 	t.Log("✓ Ping/Pong mechanism active (connection stayed alive)")
 }
+
+// TestWebSocketRequiresSession tests that connections without a valid token are rejected
+func TestWebSocketRequiresSession(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	session, err := createTestRoom(server.URL, "TestPlayer")
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+	other, err := createTestRoom(server.URL, "OtherPlayer")
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+	departed, err := joinTestRoom(server.URL, session.RoomID, "Leaver")
+	if err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+	req, _ := http.NewRequest("POST", server.URL+"/room/leave", strings.NewReader(`{"roomId":"`+session.RoomID+`"}`))
+	req.Header.Set("Authorization", "Bearer "+departed.Token)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to leave room: %v %v", err, resp)
+	}
+
+	testCases := []struct {
+		Name           string
+		Session        testSession
+		ExpectedStatus int
+	}{
+		{"Missing token", testSession{RoomID: session.RoomID, PlayerID: session.PlayerID}, http.StatusUnauthorized},
+		{"Forged player ID", testSession{RoomID: session.RoomID, PlayerID: "someone-else", Token: session.Token}, http.StatusForbidden},
+		{"Token for another room", testSession{RoomID: session.RoomID, PlayerID: other.PlayerID, Token: other.Token}, http.StatusForbidden},
+		{"Player who left", departed, http.StatusForbidden},
+	}
+
+	dialer := websocket.Dialer{}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			conn, resp, err := dialer.Dial(wsURLFor(server.URL, tc.Session), nil)
+			if err == nil {
+				conn.Close()
+				t.Fatal("Expected connection to be rejected")
+			}
+			if resp == nil || resp.StatusCode != tc.ExpectedStatus {
+				t.Errorf("Expected status %d, got %v", tc.ExpectedStatus, resp)
+			}
+		})
+	}
+}
+
+// TestWebSocketKickCommand tests the kick_player command and its permission check
+func TestWebSocketKickCommand(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	host, err := createTestRoom(server.URL, "Host")
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+	guest, err := joinTestRoom(server.URL, host.RoomID, "Guest")
+	if err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+
+	dialer := websocket.Dialer{}
+	hostConn, _, err := dialer.Dial(wsURLFor(server.URL, host), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect host: %v", err)
+	}
+	defer hostConn.Close()
+	guestConn, _, err := dialer.Dial(wsURLFor(server.URL, guest), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect guest: %v", err)
+	}
+	defer guestConn.Close()
+
+	// readUntil reads messages until one has the wanted type
+	readUntil := func(conn *websocket.Conn, messageType string) map[string]interface{} {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			var msg map[string]interface{}
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("Failed waiting for %s: %v", messageType, err)
+			}
+			if msg["type"] == messageType {
+				return msg
+			}
+		}
+	}
+
+	// The guest isn't the host, so their kick is refused
+	guestConn.WriteJSON(map[string]interface{}{
+		"type": "kick_player",
+		"data": map[string]interface{}{"playerId": host.PlayerID},
+	})
+	reply := readUntil(guestConn, "error")
+	if data, _ := reply["data"].(map[string]interface{}); data["command"] != "kick_player" {
+		t.Errorf("Expected error reply for kick_player, got %v", reply)
+	}
+
+	hostConn.WriteJSON(map[string]interface{}{
+		"type": "kick_player",
+		"data": map[string]interface{}{"playerId": guest.PlayerID},
+	})
+	kicked := readUntil(guestConn, "PLAYER_KICKED")
+	if payload, _ := kicked["payload"].(map[string]interface{}); payload["playerId"] != guest.PlayerID {
+		t.Errorf("Expected PLAYER_KICKED for guest, got %v", kicked)
+	}
+
+	guestConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := guestConn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, handlers.CloseKicked) {
+				t.Errorf("Expected close code %d, got %v", handlers.CloseKicked, err)
+			}
+			break
+		}
+	}
+}
+
+// TestWebSocketLeaveCommand tests leave_room: the others get PLAYER_LEFT
+// with the new roster and the leaver's connection is closed
+func TestWebSocketLeaveCommand(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	host, err := createTestRoom(server.URL, "Host")
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+	guest, err := joinTestRoom(server.URL, host.RoomID, "Guest")
+	if err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+
+	dialer := websocket.Dialer{}
+	hostConn, _, err := dialer.Dial(wsURLFor(server.URL, host), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect host: %v", err)
+	}
+	defer hostConn.Close()
+	guestConn, _, err := dialer.Dial(wsURLFor(server.URL, guest), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect guest: %v", err)
+	}
+	defer guestConn.Close()
+
+	guestConn.WriteJSON(map[string]interface{}{"type": "leave_room"})
+
+	hostConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg map[string]interface{}
+		if err := hostConn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Failed waiting for PLAYER_LEFT: %v", err)
+		}
+		if msg["type"] != "PLAYER_LEFT" {
+			continue
+		}
+		payload, _ := msg["payload"].(map[string]interface{})
+		players, _ := payload["players"].([]interface{})
+		if payload["playerId"] != guest.PlayerID || len(players) != 1 {
+			t.Errorf("Unexpected PLAYER_LEFT payload: %v", payload)
+		}
+		break
+	}
+
+	guestConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := guestConn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, handlers.CloseLeft) {
+				t.Errorf("Expected close code %d, got %v", handlers.CloseLeft, err)
+			}
+			break
+		}
+	}
+}
+
+// TestWebSocketReconnectSnapshot tests that a client connecting mid-game is
+// sent a SNAPSHOT with the phase, roster and its own private role
+func TestWebSocketReconnectSnapshot(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	payload := `{"playerName":"Host","players":3}`
+	resp, err := http.Post(server.URL+"/room/create", "application/json", strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+	var host testSession
+	json.NewDecoder(resp.Body).Decode(&host)
+	resp.Body.Close()
+
+	sessions := []testSession{host}
+	for _, name := range []string{"Bob", "Carol"} {
+		joined, err := joinTestRoom(server.URL, host.RoomID, name)
+		if err != nil {
+			t.Fatalf("Failed to join room: %v", err)
+		}
+		sessions = append(sessions, joined)
+	}
+
+	req, _ := http.NewRequest("POST", server.URL+"/game/start", strings.NewReader(`{"roomId":"`+host.RoomID+`"}`))
+	req.Header.Set("Authorization", "Bearer "+host.Token)
+	startResp, err := http.DefaultClient.Do(req)
+	if err != nil || startResp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to start game: %v %v", err, startResp)
+	}
+	startResp.Body.Close()
+
+	// Every player (re)connects after the roles were dealt and must still
+	// learn their own role
+	dialer := websocket.Dialer{}
+	roles := make(map[string]bool)
+	for _, session := range sessions {
+		conn, _, err := dialer.Dial(wsURLFor(server.URL, session), nil)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+
+		var snapshot struct {
+			Type    string `json:"type"`
+			Payload struct {
+				Room handlers.RoomDetailsResponse `json:"room"`
+				You  handlers.PlayerView          `json:"you"`
+			} `json:"payload"`
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for snapshot.Type != "SNAPSHOT" {
+			if err := conn.ReadJSON(&snapshot); err != nil {
+				t.Fatalf("Failed waiting for SNAPSHOT: %v", err)
+			}
+		}
+		conn.Close()
+
+		if snapshot.Payload.Room.Status != "GUESSING" || len(snapshot.Payload.Room.Players) != 3 {
+			t.Errorf("Unexpected room in snapshot: %+v", snapshot.Payload.Room)
+		}
+		if snapshot.Payload.You.ID != session.PlayerID {
+			t.Errorf("Snapshot is for %q, expected %q", snapshot.Payload.You.ID, session.PlayerID)
+		}
+		roles[snapshot.Payload.You.Role] = true
+	}
+
+	for _, role := range []string{"Raja", "Mantri", "Chor"} {
+		if !roles[role] {
+			t.Errorf("Expected some player's snapshot to carry role %s, got %v", role, roles)
+		}
+	}
+}
+
+// readMessageOfType reads from conn until a message of the given type arrives
+func readMessageOfType(t *testing.T, conn *websocket.Conn, messageType string) map[string]interface{} {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg map[string]interface{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Failed waiting for %s: %v", messageType, err)
+		}
+		if msg["type"] == messageType {
+			return msg
+		}
+	}
+}
+
+// TestWebSocketCommandValidation tests that malformed, unknown, server-only
+// and invalid commands get a structured error reply
+func TestWebSocketCommandValidation(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	host, err := createTestRoom(server.URL, "Host")
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+	guest, err := joinTestRoom(server.URL, host.RoomID, "Guest")
+	if err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+
+	dialer := websocket.Dialer{}
+	conn, _, err := dialer.Dial(wsURLFor(server.URL, guest), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	testCases := []struct {
+		Name         string
+		Message      string
+		ExpectedCode string
+	}{
+		{"Not JSON", `hello`, "malformed"},
+		{"Missing type", `{"data":{}}`, "malformed"},
+		{"Unknown command", `{"type":"dance"}`, "unknown_command"},
+		{"Forged guess result", `{"type":"GUESS_RESULT","data":{"correct":true}}`, "server_only"},
+		{"Forged role", `{"type":"YOUR_ROLE","data":{"role":"Raja"}}`, "server_only"},
+		{"Chat without message", `{"type":"chat","data":{}}`, "invalid_payload"},
+		{"Chat with unknown field", `{"type":"chat","data":{"message":"hi","playerId":"someone"}}`, "invalid_payload"},
+		{"Chat with wrong type", `{"type":"chat","data":{"message":42}}`, "invalid_payload"},
+		{"Ready without value", `{"type":"ready"}`, "invalid_payload"},
+		{"Guess without target", `{"type":"guess","data":{}}`, "invalid_payload"},
+		{"Start as non-host", `{"type":"start_game"}`, "forbidden"},
+		{"Next round before start", `{"type":"next_round"}`, "forbidden"},
+		{"Guess before start", `{"type":"guess","data":{"guessedChorPlayerId":"x"}}`, "bad_request"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(tc.Message)); err != nil {
+				t.Fatalf("Failed to send: %v", err)
+			}
+			reply := readMessageOfType(t, conn, "error")
+			data, _ := reply["data"].(map[string]interface{})
+			if data["code"] != tc.ExpectedCode {
+				t.Errorf("Expected code %q, got %v", tc.ExpectedCode, data)
+			}
+		})
+	}
+}
+
+// TestWebSocketPlayRound plays a round entirely over WebSocket commands
+func TestWebSocketPlayRound(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/room/create", "application/json", strings.NewReader(`{"playerName":"Host","players":3}`))
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+	var host testSession
+	json.NewDecoder(resp.Body).Decode(&host)
+	resp.Body.Close()
+
+	sessions := []testSession{host}
+	for _, name := range []string{"Bob", "Carol"} {
+		joined, err := joinTestRoom(server.URL, host.RoomID, name)
+		if err != nil {
+			t.Fatalf("Failed to join room: %v", err)
+		}
+		sessions = append(sessions, joined)
+	}
+
+	dialer := websocket.Dialer{}
+	conns := make(map[string]*websocket.Conn)
+	for _, session := range sessions {
+		conn, _, err := dialer.Dial(wsURLFor(server.URL, session), nil)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+		conns[session.PlayerID] = conn
+	}
+	hostConn := conns[host.PlayerID]
+
+	// Chat is relayed with the sender taken from the connection
+	hostConn.WriteJSON(map[string]interface{}{"type": "chat", "data": map[string]string{"message": "ready?"}})
+	chat := readMessageOfType(t, conns[sessions[1].PlayerID], "CHAT")
+	if payload, _ := chat["payload"].(map[string]interface{}); payload["playerId"] != host.PlayerID || payload["message"] != "ready?" {
+		t.Errorf("Unexpected CHAT: %v", chat)
+	}
+
+	hostConn.WriteJSON(map[string]interface{}{"type": "start_game"})
+
+	var mantriID, chorID string
+	for id, conn := range conns {
+		role := readMessageOfType(t, conn, "YOUR_ROLE")
+		payload, _ := role["payload"].(map[string]interface{})
+		switch payload["role"] {
+		case "Mantri":
+			mantriID = id
+		case "Chor":
+			chorID = id
+		}
+	}
+	if mantriID == "" || chorID == "" {
+		t.Fatal("Expected a Mantri and a Chor to be dealt")
+	}
+
+	conns[mantriID].WriteJSON(map[string]interface{}{
+		"type": "guess",
+		"data": map[string]string{"guessedChorPlayerId": chorID},
+	})
+	result := readMessageOfType(t, hostConn, "GUESS_RESULT")
+	if payload, _ := result["payload"].(map[string]interface{}); payload["correct"] != true {
+		t.Errorf("Expected a correct guess, got %v", result)
+	}
+}
+
+// TestWebSocketResume tests that a client reconnecting with ?since= gets
+// exactly the events it missed, its own private ones included, before live
+// events resume
+func TestWebSocketResume(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	payload := `{"playerName":"Host","players":3}`
+	resp, err := http.Post(server.URL+"/room/create", "application/json", strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+	var host testSession
+	json.NewDecoder(resp.Body).Decode(&host)
+	resp.Body.Close()
+
+	bob, err := joinTestRoom(server.URL, host.RoomID, "Bob")
+	if err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+	if _, err := joinTestRoom(server.URL, host.RoomID, "Carol"); err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+
+	// Bob last saw event 3, the third PLAYER_JOINED, then the game started
+	// while he was away
+	req, _ := http.NewRequest("POST", server.URL+"/game/start", strings.NewReader(`{"roomId":"`+host.RoomID+`"}`))
+	req.Header.Set("Authorization", "Bearer "+host.Token)
+	startResp, err := http.DefaultClient.Do(req)
+	if err != nil || startResp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to start game: %v %v", err, startResp)
+	}
+	startResp.Body.Close()
+
+	dialer := websocket.Dialer{}
+	hostConn, _, err := dialer.Dial(wsURLFor(server.URL, host), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect host: %v", err)
+	}
+	defer hostConn.Close()
+
+	bobConn, _, err := dialer.Dial(wsURLFor(server.URL, bob)+"&since=3", nil)
+	if err != nil {
+		t.Fatalf("Failed to reconnect: %v", err)
+	}
+	defer bobConn.Close()
+
+	hostConn.WriteJSON(map[string]interface{}{"type": "chat", "data": map[string]string{"message": "welcome back"}})
+
+	var types []string
+	var lastSeq float64 = 3
+	bobConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(types) == 0 || types[len(types)-1] != "CHAT" {
+		var msg map[string]interface{}
+		if err := bobConn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Failed reading events, got %v: %v", types, err)
+		}
+		seq, numbered := msg["seq"].(float64)
+		if !numbered {
+			continue
+		}
+		if seq <= lastSeq {
+			t.Errorf("Event %v arrived after %v", msg["type"], lastSeq)
+		}
+		lastSeq = seq
+		types = append(types, msg["type"].(string))
+		if msg["type"] == "YOUR_ROLE" && msg["payload"].(map[string]interface{})["name"] != "Bob" {
+			t.Errorf("Bob was sent someone else's role: %v", msg)
+		}
+	}
+
+	// The host coming online is replayed, and Bob's own return follows live
+	want := []string{"PHASE_CHANGED", "GAME_START", "YOUR_ROLE", "PRESENCE_CHANGED", "PRESENCE_CHANGED", "CHAT"}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, types)
+	}
+
+	// A seq the room doesn't have any more, or never had, falls back to a snapshot
+	staleConn, _, err := dialer.Dial(wsURLFor(server.URL, bob)+"&since=999", nil)
+	if err != nil {
+		t.Fatalf("Failed to reconnect: %v", err)
+	}
+	defer staleConn.Close()
+	snapshot := readMessageOfType(t, staleConn, "SNAPSHOT")
+	if snapshot["seq"] != lastSeq {
+		t.Errorf("Expected snapshot at seq %v, got %v", lastSeq, snapshot["seq"])
+	}
+
+	if _, resp, err := dialer.Dial(wsURLFor(server.URL, bob)+"&since=latest", nil); err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed since, got %v", err)
+	}
+}
+
+// TestWebSocketProtocolNegotiation tests that a client offering the v1
+// subprotocol gets Envelopes while a legacy client in the same room keeps
+// getting the old format for the same events
+func TestWebSocketProtocolNegotiation(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	host, err := createTestRoom(server.URL, "Host")
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+
+	v1Dialer := websocket.Dialer{Subprotocols: []string{"rmcs.v2", handlers.SubprotocolV1}}
+	v1Conn, resp, err := v1Dialer.Dial(wsURLFor(server.URL, host), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer v1Conn.Close()
+	if v1Conn.Subprotocol() != handlers.SubprotocolV1 || resp.Header.Get("Deprecation") != "" {
+		t.Errorf("Expected %s to be negotiated without a deprecation notice, got %q", handlers.SubprotocolV1, v1Conn.Subprotocol())
+	}
+
+	legacyConn, resp, err := websocket.DefaultDialer.Dial(wsURLFor(server.URL, host), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer legacyConn.Close()
+	if resp.Header.Get("Deprecation") != "true" {
+		t.Error("Expected the legacy connection to be told it is deprecated")
+	}
+	if sunset := resp.Header.Get("Sunset"); sunset != handlers.LegacySunset.Format(http.TimeFormat) {
+		t.Errorf("Expected the legacy connection to be told when it is removed, got %q", sunset)
+	}
+
+	type envelope struct {
+		Version   int             `json:"v"`
+		ID        string          `json:"id"`
+		Type      string          `json:"type"`
+		Seq       uint64          `json:"seq"`
+		RoomID    string          `json:"roomId"`
+		Timestamp int64           `json:"timestamp"`
+		Payload   json.RawMessage `json:"payload"`
+	}
+	readEnvelope := func(messageType string) envelope {
+		t.Helper()
+		v1Conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			var env envelope
+			if err := v1Conn.ReadJSON(&env); err != nil {
+				t.Fatalf("Failed waiting for %s: %v", messageType, err)
+			}
+			if env.Version != handlers.ProtocolVersion || env.ID == "" || env.RoomID != host.RoomID || env.Timestamp == 0 {
+				t.Errorf("Incomplete envelope: %+v", env)
+			}
+			if env.Type == messageType {
+				return env
+			}
+		}
+	}
+
+	if env := readEnvelope("CONNECTED"); env.Seq != 0 {
+		t.Errorf("Expected CONNECTED to be unnumbered, got seq %d", env.Seq)
+	}
+	var snapshot handlers.SnapshotPayload
+	json.Unmarshal(readEnvelope("SNAPSHOT").Payload, &snapshot)
+	if snapshot.You == nil || snapshot.You.ID != host.PlayerID {
+		t.Errorf("Expected the snapshot to carry the host's seat, got %+v", snapshot)
+	}
+
+	guest, err := joinTestRoom(server.URL, host.RoomID, "Guest")
+	if err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+	joined := readEnvelope("PLAYER_JOINED")
+	var payload handlers.PlayerJoinedPayload
+	json.Unmarshal(joined.Payload, &payload)
+	if payload.PlayerID != guest.PlayerID || joined.Seq == 0 {
+		t.Errorf("Unexpected PLAYER_JOINED envelope: %+v", joined)
+	}
+
+	legacyJoined := readMessageOfType(t, legacyConn, "PLAYER_JOINED")
+	if legacyJoined["payload"].(map[string]interface{})["playerId"] != guest.PlayerID || legacyJoined["seq"] != float64(joined.Seq) {
+		t.Errorf("Expected the legacy client to get the same event, got %v", legacyJoined)
+	}
+	if _, ok := legacyJoined["v"]; ok {
+		t.Errorf("Expected no envelope fields in the legacy format, got %v", legacyJoined)
+	}
+
+	// Errors follow the negotiated format too
+	v1Conn.WriteJSON(map[string]string{"type": "dance"})
+	var refusal handlers.ErrorPayload
+	json.Unmarshal(readEnvelope("ERROR").Payload, &refusal)
+	if refusal.Code != handlers.CodeUnknownCommand {
+		t.Errorf("Expected %s, got %+v", handlers.CodeUnknownCommand, refusal)
+	}
+
+	legacyConn.WriteJSON(map[string]string{"type": "dance"})
+	if msg := readMessageOfType(t, legacyConn, "error"); msg["data"].(map[string]interface{})["code"] != handlers.CodeUnknownCommand {
+		t.Errorf("Expected a legacy error reply, got %v", msg)
+	}
+}
+
+// TestWebSocketMultiDevice tests that a player connected from several tabs
+// gets private messages on all of them, and that the room only hears about
+// their first and last connection
+func TestWebSocketMultiDevice(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	host, err := createTestRoom(server.URL, "Host")
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+	guest, err := joinTestRoom(server.URL, host.RoomID, "Guest")
+	if err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+
+	hostConn, _, err := websocket.DefaultDialer.Dial(wsURLFor(server.URL, host), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer hostConn.Close()
+	readMessageOfType(t, hostConn, "player_joined") // the host's own connection
+
+	// expectChat reads up to the host's next chat line, failing on any
+	// connection event before it
+	expectChat := func(message string) {
+		t.Helper()
+		hostConn.WriteJSON(map[string]interface{}{"type": "chat", "data": map[string]string{"message": message}})
+		hostConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			var msg map[string]interface{}
+			if err := hostConn.ReadJSON(&msg); err != nil {
+				t.Fatalf("Failed waiting for chat %q: %v", message, err)
+			}
+			if msg["type"] == "player_joined" || msg["type"] == "player_left" {
+				t.Errorf("Expected no connection event before chat %q, got %v", message, msg)
+			}
+			if msg["type"] == "CHAT" && msg["payload"].(map[string]interface{})["message"] == message {
+				return
+			}
+		}
+	}
+
+	phone, _, err := websocket.DefaultDialer.Dial(wsURLFor(server.URL, guest), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer phone.Close()
+	connected := readMessageOfType(t, hostConn, "player_joined")
+	if data := connected["data"].(map[string]interface{}); data["playerId"] != guest.PlayerID || data["playerCount"] != float64(2) {
+		t.Errorf("Expected the guest's first connection to be announced with 2 players, got %v", connected)
+	}
+
+	laptop, _, err := websocket.DefaultDialer.Dial(wsURLFor(server.URL, guest), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer laptop.Close()
+	readMessageOfType(t, laptop, "SNAPSHOT")
+	expectChat("second tab")
+
+	// Private messages reach both of the guest's connections
+	server.app.SendToPlayer(host.RoomID, guest.PlayerID, handlers.YourRolePayload{Role: "Chor", Name: "Guest"})
+	readMessageOfType(t, phone, "YOUR_ROLE")
+	readMessageOfType(t, laptop, "YOUR_ROLE")
+
+	phone.Close()
+	waitFor(t, "the phone to unregister", func() bool { return server.app.Hub().GetClientCount(host.RoomID) == 2 })
+	expectChat("phone closed")
+
+	laptop.Close()
+	disconnected := readMessageOfType(t, hostConn, "player_left")
+	if data := disconnected["data"].(map[string]interface{}); data["playerId"] != guest.PlayerID || data["playerCount"] != float64(1) {
+		t.Errorf("Expected the guest's last connection to be announced with 1 player left, got %v", disconnected)
+	}
+}
+
+// TestWebSocketPresence tests that presence changes are announced and show
+// up in the room details
+func TestWebSocketPresence(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	host, err := createTestRoom(server.URL, "Host")
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+	guest, err := joinTestRoom(server.URL, host.RoomID, "Guest")
+	if err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+
+	presenceOf := func(playerID string) handlers.PlayerInfoPublic {
+		t.Helper()
+		resp, err := http.Get(server.URL + "/room/" + host.RoomID)
+		if err != nil {
+			t.Fatalf("Failed to get room: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var details handlers.RoomDetailsResponse
+		json.NewDecoder(resp.Body).Decode(&details)
+		for _, p := range details.Players {
+			if p.ID == playerID {
+				return p
+			}
+		}
+		t.Fatalf("Player %s not in room details: %+v", playerID, details)
+		return handlers.PlayerInfoPublic{}
+	}
+
+	if p := presenceOf(guest.PlayerID); p.Presence != "disconnected" || p.LastSeen != 0 {
+		t.Errorf("Expected the guest to start disconnected and never seen, got %+v", p)
+	}
+
+	hostConn, _, err := websocket.DefaultDialer.Dial(wsURLFor(server.URL, host), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer hostConn.Close()
+
+	// expectPresence reads the next presence change and checks it
+	expectPresence := func(playerID string, presence string) {
+		t.Helper()
+		msg := readMessageOfType(t, hostConn, "PRESENCE_CHANGED")
+		payload := msg["payload"].(map[string]interface{})
+		if payload["playerId"] != playerID || payload["presence"] != presence || payload["lastSeen"].(float64) == 0 {
+			t.Errorf("Expected %s to be %s, got %v", playerID, presence, payload)
+		}
+	}
+	expectPresence(host.PlayerID, "online")
+
+	guestConn, _, err := websocket.DefaultDialer.Dial(wsURLFor(server.URL, guest), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer guestConn.Close()
+	expectPresence(guest.PlayerID, "online")
+
+	guestConn.WriteJSON(map[string]interface{}{"type": "presence", "data": map[string]string{"status": "away"}})
+	expectPresence(guest.PlayerID, "away")
+	if p := presenceOf(guest.PlayerID); p.Presence != "away" || p.LastSeen == 0 {
+		t.Errorf("Expected the room details to show the guest away, got %+v", p)
+	}
+
+	guestConn.WriteJSON(map[string]interface{}{"type": "presence", "data": map[string]string{"status": "gone"}})
+	if msg := readMessageOfType(t, guestConn, "error"); msg["data"].(map[string]interface{})["code"] != handlers.CodeInvalidPayload {
+		t.Errorf("Expected an invalid status to be refused, got %v", msg)
+	}
+
+	// The guest's seat is held while they may still come back
+	guestConn.Close()
+	expectPresence(guest.PlayerID, "reconnecting")
+	if p := presenceOf(guest.PlayerID); p.Presence != "reconnecting" || p.LastSeen == 0 {
+		t.Errorf("Expected the room details to show when the guest was last seen, got %+v", p)
+	}
+}
+
+// TestWebSocketDisconnectGrace tests that a Mantri who drops out is held a
+// seat, and that the round is forfeited once they fail to come back in time
+func TestWebSocketDisconnectGrace(t *testing.T) {
+	server := setupTestServer()
+	defer server.Close()
+
+	payload := `{"playerName":"Host","players":3}`
+	resp, err := http.Post(server.URL+"/room/create", "application/json", strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+	var host testSession
+	json.NewDecoder(resp.Body).Decode(&host)
+	resp.Body.Close()
+
+	sessions := []testSession{host}
+	for _, name := range []string{"Bob", "Carol"} {
+		joined, err := joinTestRoom(server.URL, host.RoomID, name)
+		if err != nil {
+			t.Fatalf("Failed to join room: %v", err)
+		}
+		sessions = append(sessions, joined)
+	}
+
+	req, _ := http.NewRequest("POST", server.URL+"/game/start", strings.NewReader(`{"roomId":"`+host.RoomID+`"}`))
+	req.Header.Set("Authorization", "Bearer "+host.Token)
+	startResp, err := http.DefaultClient.Do(req)
+	if err != nil || startResp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to start game: %v %v", err, startResp)
+	}
+	startResp.Body.Close()
+
+	// Find the Mantri from each player's snapshot, and someone to watch
+	var mantri testSession
+	var mantriConn, observer *websocket.Conn
+	for _, session := range sessions {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURLFor(server.URL, session), nil)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+
+		you := readMessageOfType(t, conn, "SNAPSHOT")["payload"].(map[string]interface{})["you"].(map[string]interface{})
+		if you["role"] == "Mantri" {
+			mantri, mantriConn = session, conn
+		} else {
+			observer = conn
+		}
+	}
+
+	// expectPresence reads up to the Mantri's next change to presence
+	expectPresence := func(presence string) {
+		t.Helper()
+		for {
+			msg := readMessageOfType(t, observer, "PRESENCE_CHANGED")
+			payload := msg["payload"].(map[string]interface{})
+			if payload["playerId"] == mantri.PlayerID && payload["presence"] == presence {
+				return
+			}
+		}
+	}
+
+	// Coming back within the grace period keeps the round going
+	server.app.SetDisconnectGrace(time.Minute)
+	mantriConn.Close()
+	expectPresence("reconnecting")
+	mantriConn, _, err = websocket.DefaultDialer.Dial(wsURLFor(server.URL, mantri), nil)
+	if err != nil {
+		t.Fatalf("Failed to reconnect: %v", err)
+	}
+	expectPresence("online")
+
+	// Staying away past it forfeits the round
+	server.app.SetDisconnectGrace(50 * time.Millisecond)
+	mantriConn.Close()
+	expectPresence("reconnecting")
+	expectPresence("disconnected")
+
+	resolved := readMessageOfType(t, observer, "DISCONNECT_RESOLVED")["payload"].(map[string]interface{})
+	if resolved["playerId"] != mantri.PlayerID || resolved["policy"] != "forfeit" || resolved["roundAborted"] != false {
+		t.Errorf("Unexpected DISCONNECT_RESOLVED: %v", resolved)
+	}
+	result := readMessageOfType(t, observer, "GUESS_RESULT")["payload"].(map[string]interface{})
+	if result["correct"] != false {
+		t.Errorf("Expected the forfeited round to be scored as a wrong guess, got %v", result)
+	}
+	readMessageOfType(t, observer, "GAME_END")
+}
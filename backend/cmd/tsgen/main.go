@@ -0,0 +1,258 @@
+// Command tsgen writes the TypeScript types of the HTTP and WebSocket
+// protocol for the frontend. Run it from backend/ after changing any of
+// the types it lists:
+//
+//	go run ./cmd/tsgen
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/game"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/handlers"
+)
+
+// defaultOut is where the frontend keeps the generated module, relative to
+// backend/
+const defaultOut = "../frontend/types/protocol.ts"
+
+// httpTypes are the request and response bodies of the HTTP API
+var httpTypes = []interface{}{
+	handlers.CreateRoomRequest{},
+	handlers.CreateRoomResponse{},
+	handlers.JoinRoomRequest{},
+	handlers.JoinRoomResponse{},
+	handlers.LeaveRoomRequest{},
+	handlers.RoomDetailsResponse{},
+	handlers.StartGameRequest{},
+	handlers.SubmitGuessRequest{},
+	game.GuessResult{},
+	handlers.NextRoundRequest{},
+	handlers.KickPlayerRequest{},
+	handlers.TransferHostRequest{},
+	handlers.LockRoomRequest{},
+	handlers.CloseRoomRequest{},
+	handlers.ErrorResponse{},
+}
+
+func main() {
+	out := flag.String("out", defaultOut, "File to write the TypeScript module to")
+	flag.Parse()
+
+	var buf bytes.Buffer
+	if err := generate(&buf); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, buf.Bytes(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Wrote %s", *out)
+}
+
+// generator collects the interfaces to emit, in the order they are first
+// referenced
+type generator struct {
+	seen  map[reflect.Type]bool
+	queue []reflect.Type
+}
+
+func generate(w io.Writer) error {
+	g := &generator{seen: make(map[reflect.Type]bool)}
+
+	fmt.Fprintln(w, "// Code generated by backend/cmd/tsgen. DO NOT EDIT.")
+	fmt.Fprintln(w, "// Regenerate with `go run ./cmd/tsgen` from backend/.")
+
+	section(w, "HTTP API")
+	for _, v := range httpTypes {
+		g.ref(reflect.TypeOf(v))
+	}
+	g.flush(w)
+
+	section(w, "WebSocket commands (client to server)")
+	commands := handlers.CommandPayloads()
+	commandTypes := make([]string, 0, len(commands))
+	for commandType := range commands {
+		commandTypes = append(commandTypes, commandType)
+	}
+	sort.Strings(commandTypes)
+	for _, commandType := range commandTypes {
+		if payload := commands[commandType]; payload != nil {
+			g.ref(reflect.TypeOf(payload))
+		}
+	}
+	g.flush(w)
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "export type ClientCommand =")
+	for _, commandType := range commandTypes {
+		if payload := commands[commandType]; payload != nil {
+			fmt.Fprintf(w, "  | { type: %q; data: %s }\n", commandType, g.ref(reflect.TypeOf(payload)))
+		} else {
+			fmt.Fprintf(w, "  | { type: %q; data?: Record<string, never> }\n", commandType)
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `export type ClientCommandType = ClientCommand["type"]`)
+
+	section(w, "WebSocket events (server to client)")
+	fmt.Fprintf(w, "export const PROTOCOL_VERSION = %d\n", handlers.ProtocolVersion)
+	fmt.Fprintf(w, "export const SUBPROTOCOL_V1 = %q\n", handlers.SubprotocolV1)
+	fmt.Fprintln(w)
+	if err := writeEnvelope(w); err != nil {
+		return err
+	}
+
+	payloads := handlers.EventPayloads()
+	for _, payload := range payloads {
+		g.ref(reflect.TypeOf(payload))
+	}
+	g.flush(w)
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "export type ServerEvent =")
+	for _, payload := range payloads {
+		fmt.Fprintf(w, "  | Envelope<%q, %s>\n", payload.EventType(), reflect.TypeOf(payload).Name())
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `export type ServerEventType = ServerEvent["type"]`)
+	return nil
+}
+
+func section(w io.Writer, title string) {
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "// %s\n", title)
+}
+
+// writeEnvelope emits handlers.Envelope as a generic interface over its
+// type and payload, so ServerEvent can discriminate on type
+func writeEnvelope(w io.Writer) error {
+	t := reflect.TypeOf(handlers.Envelope{})
+
+	fmt.Fprintln(w, "export interface Envelope<T extends string = string, P = unknown> {")
+	for _, f := range jsonFields(t) {
+		switch f.name {
+		case "type":
+			fmt.Fprintln(w, "  type: T")
+		case "payload":
+			fmt.Fprintln(w, "  payload: P")
+		default:
+			if f.typ.Kind() == reflect.Struct || f.typ.Kind() == reflect.Interface {
+				return fmt.Errorf("envelope field %s needs handling in tsgen", f.name)
+			}
+			fmt.Fprintf(w, "  %s: %s\n", f.key(), (&generator{}).tsType(f.typ))
+		}
+	}
+	fmt.Fprintln(w, "}")
+	return nil
+}
+
+// ref returns the TypeScript name for a named struct, queuing it to be
+// emitted
+func (g *generator) ref(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if !g.seen[t] {
+		g.seen[t] = true
+		g.queue = append(g.queue, t)
+	}
+	return t.Name()
+}
+
+// flush emits every queued interface, including the ones they reference
+func (g *generator) flush(w io.Writer) {
+	for len(g.queue) > 0 {
+		t := g.queue[0]
+		g.queue = g.queue[1:]
+
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "export interface %s {\n", t.Name())
+		for _, f := range jsonFields(t) {
+			fmt.Fprintf(w, "  %s: %s\n", f.key(), g.tsType(f.typ))
+		}
+		fmt.Fprintln(w, "}")
+	}
+}
+
+func (g *generator) tsType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return g.tsType(t.Elem()) + "[]"
+	case reflect.Map:
+		return "Record<string, " + g.tsType(t.Elem()) + ">"
+	case reflect.Pointer:
+		return g.tsType(t.Elem()) + " | null"
+	case reflect.Struct:
+		return g.ref(t)
+	}
+	return "unknown"
+}
+
+type field struct {
+	name     string
+	typ      reflect.Type
+	optional bool
+}
+
+// key is the property name, marked optional when encoding/json may leave
+// it out
+func (f field) key() string {
+	if f.optional {
+		return f.name + "?"
+	}
+	return f.name
+}
+
+// jsonFields lists the fields encoding/json writes for t, in order, with
+// embedded structs flattened
+func jsonFields(t reflect.Type) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, jsonFields(sf.Type)...)
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+
+		f := field{name: name, typ: sf.Type}
+		for _, opt := range strings.Split(opts, ",") {
+			if opt == "omitempty" {
+				f.optional = true
+				// An omitted pointer is never sent as null
+				if sf.Type.Kind() == reflect.Pointer {
+					f.typ = sf.Type.Elem()
+				}
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
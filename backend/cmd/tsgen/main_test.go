@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/handlers"
+)
+
+// TestGeneratedTypesUpToDate fails when the committed TypeScript module no
+// longer matches the Go types it is generated from
+func TestGeneratedTypesUpToDate(t *testing.T) {
+	var buf bytes.Buffer
+	if err := generate(&buf); err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+
+	// Tests run in cmd/tsgen, two levels below backend/
+	committed, err := os.ReadFile(filepath.Join("..", "..", defaultOut))
+	if err != nil {
+		t.Fatalf("Failed to read the committed module: %v", err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), committed) {
+		t.Errorf("%s is stale; run `go run ./cmd/tsgen` from backend/ and commit the result", defaultOut)
+	}
+}
+
+// TestEveryEventInUnion tests that each event type gets a member of the
+// ServerEvent union
+func TestEveryEventInUnion(t *testing.T) {
+	var buf bytes.Buffer
+	if err := generate(&buf); err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+	out := buf.String()
+
+	for _, payload := range handlers.EventPayloads() {
+		member := `Envelope<"` + payload.EventType() + `", `
+		if !strings.Contains(out, member) {
+			t.Errorf("Expected ServerEvent to include %s", payload.EventType())
+		}
+	}
+	for commandType := range handlers.CommandPayloads() {
+		if !strings.Contains(out, `type: "`+commandType+`"`) {
+			t.Errorf("Expected ClientCommand to include %s", commandType)
+		}
+	}
+}
@@ -9,21 +9,30 @@ import (
 	"os/signal"
 	"time"
 
+	"github.com/bit2swaz/codechef-recruit/backend/internal/handlers"
 	"github.com/gorilla/websocket"
 )
 
-type WSMessage struct {
-	Type      string                 `json:"type"`
-	PlayerID  string                 `json:"playerId"`
-	RoomID    string                 `json:"roomId"`
-	Data      map[string]interface{} `json:"data"`
-	Timestamp int64                  `json:"timestamp"`
+// Envelope is a server event, with the payload left undecoded
+type Envelope struct {
+	Version   int             `json:"v"`
+	Type      string          `json:"type"`
+	Seq       uint64          `json:"seq"`
+	Timestamp int64           `json:"timestamp"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// Command is a message sent to the server
+type Command struct {
+	Type string                 `json:"type"`
+	Data map[string]interface{} `json:"data"`
 }
 
 func main() {
 	// Parse command line flags
 	roomID := flag.String("room", "TEST", "Room ID to join")
 	playerID := flag.String("player", "player-1", "Player ID")
+	token := flag.String("token", "", "Session token returned by /room/create or /room/join")
 	serverAddr := flag.String("addr", "localhost:8080", "Server address")
 	flag.Parse()
 
@@ -35,12 +44,14 @@ func main() {
 	u := url.URL{Scheme: "ws", Host: *serverAddr, Path: "/ws/" + *roomID}
 	q := u.Query()
 	q.Set("playerId", *playerID)
+	q.Set("token", *token)
 	u.RawQuery = q.Encode()
 
 	log.Printf("Connecting to %s", u.String())
 
 	// Connect to WebSocket
-	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	dialer := websocket.Dialer{Subprotocols: []string{handlers.SubprotocolV1}}
+	c, _, err := dialer.Dial(u.String(), nil)
 	if err != nil {
 		log.Fatal("dial:", err)
 	}
@@ -59,23 +70,23 @@ func main() {
 				return
 			}
 
-			var wsMsg WSMessage
-			if err := json.Unmarshal(message, &wsMsg); err != nil {
+			var env Envelope
+			if err := json.Unmarshal(message, &env); err != nil {
 				log.Printf("Received (raw): %s", message)
 			} else {
-				log.Printf("Received [%s]: type=%s, playerID=%s, data=%v",
-					time.Unix(wsMsg.Timestamp, 0).Format("15:04:05"),
-					wsMsg.Type,
-					wsMsg.PlayerID,
-					wsMsg.Data)
+				log.Printf("Received [%s]: type=%s, seq=%d, payload=%s",
+					time.UnixMilli(env.Timestamp).Format("15:04:05"),
+					env.Type,
+					env.Seq,
+					env.Payload)
 			}
 		}
 	}()
 
-	// Send a test message after connection
+	// Send a chat message after connection
 	time.Sleep(1 * time.Second)
-	testMsg := WSMessage{
-		Type: "test_message",
+	testMsg := Command{
+		Type: "chat",
 		Data: map[string]interface{}{
 			"message": "Hello from " + *playerID,
 		},
@@ -86,7 +97,7 @@ func main() {
 		log.Println("write:", err)
 		return
 	}
-	log.Println("Sent test message")
+	log.Println("Sent chat message")
 
 	// Wait for interrupt or connection close
 	select {
@@ -0,0 +1,40 @@
+package broker
+
+import "errors"
+
+// ErrBacklog is returned by Publish when a broker's outgoing queue is full
+var ErrBacklog = errors.New("broker publish queue is full")
+
+// Message is one event for the clients of a room. It is published once and
+// delivered to every subscribed server instance, each of which hands it to
+// whichever of the room's clients are connected to it.
+type Message struct {
+	RoomID string `json:"roomId"`
+	// PlayerID narrows delivery to one player's connections. Empty means
+	// the whole room.
+	PlayerID string `json:"playerId,omitempty"`
+	// Seq is the room event's sequence number, or 0 for messages that
+	// aren't part of the room's event log
+	Seq  uint64 `json:"seq,omitempty"`
+	Data []byte `json:"data"`
+	// Legacy, if set, is Data in the deprecated wire format, sent instead
+	// to clients still using it
+	Legacy []byte `json:"legacy,omitempty"`
+	// Close marks Data as a WebSocket close frame: the matching connections
+	// are closed with it instead of being sent it.
+	Close bool `json:"close,omitempty"`
+}
+
+// Broker carries room events between server instances. Messages published
+// by one goroutine are delivered in the order they were published.
+type Broker interface {
+	// Publish hands msg to every subscriber, including this instance's. It
+	// must not block on the network.
+	Publish(msg Message) error
+
+	// Subscribe starts delivering published messages to deliver. It is
+	// called once, and deliver must not block.
+	Subscribe(deliver func(Message)) error
+
+	Close() error
+}
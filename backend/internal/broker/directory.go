@@ -0,0 +1,84 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultClaimTTL is how long a RedisDirectory claim lasts unless it is
+// renewed
+const DefaultClaimTTL = time.Minute
+
+// Directory records which server instance owns each room, so that requests
+// for a room reaching any instance can be sent on to the one holding it.
+// Instances are identified by the address other instances reach them at.
+type Directory interface {
+	// Claim makes addr the owner of roomID, unless another instance owns
+	// it, and returns the room's owner. Claiming a room addr already owns
+	// renews the claim.
+	Claim(ctx context.Context, roomID string, addr string) (owner string, err error)
+
+	// Owner returns the address of roomID's owner, or "" if nobody owns it
+	Owner(ctx context.Context, roomID string) (string, error)
+
+	// Release gives up addr's claim on roomID. It does nothing if another
+	// instance owns the room.
+	Release(ctx context.Context, roomID string, addr string) error
+}
+
+var (
+	claimScript = redis.NewScript(`
+local owner = redis.call("GET", KEYS[1])
+if owner and owner ~= ARGV[1] then
+	return owner
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return ARGV[1]
+`)
+
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+)
+
+// RedisDirectory keeps room owners in Redis, one key per room,
+// <prefix>:owner:<roomId>. Claims expire after ttl, so the rooms of an
+// instance that stops without releasing them are freed; owners renew their
+// claims well within it.
+type RedisDirectory struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedisDirectory creates a directory on client whose claims last ttl.
+// The caller keeps ownership of the client.
+func NewRedisDirectory(client *redis.Client, prefix string, ttl time.Duration) *RedisDirectory {
+	return &RedisDirectory{client: client, prefix: prefix, ttl: ttl}
+}
+
+func (d *RedisDirectory) key(roomID string) string {
+	return d.prefix + ":owner:" + roomID
+}
+
+func (d *RedisDirectory) Claim(ctx context.Context, roomID string, addr string) (string, error) {
+	return claimScript.Run(ctx, d.client, []string{d.key(roomID)}, addr, d.ttl.Milliseconds()).Text()
+}
+
+func (d *RedisDirectory) Owner(ctx context.Context, roomID string) (string, error) {
+	owner, err := d.client.Get(ctx, d.key(roomID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return owner, err
+}
+
+func (d *RedisDirectory) Release(ctx context.Context, roomID string, addr string) error {
+	return releaseScript.Run(ctx, d.client, []string{d.key(roomID)}, addr).Err()
+}
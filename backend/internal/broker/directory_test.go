@@ -0,0 +1,60 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisDirectory(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	dir := NewRedisDirectory(client, "test", time.Minute)
+	ctx := context.Background()
+
+	if owner, err := dir.Owner(ctx, "ABCD"); err != nil || owner != "" {
+		t.Fatalf("Expected an unclaimed room to have no owner, got %q (%v)", owner, err)
+	}
+	if owner, err := dir.Claim(ctx, "ABCD", "http://a"); err != nil || owner != "http://a" {
+		t.Fatalf("Expected a to claim the room, got %q (%v)", owner, err)
+	}
+	if owner, err := dir.Claim(ctx, "ABCD", "http://b"); err != nil || owner != "http://a" {
+		t.Errorf("Expected b's claim to find a owning the room, got %q (%v)", owner, err)
+	}
+
+	// Only the owner's release frees the room
+	dir.Release(ctx, "ABCD", "http://b")
+	if owner, _ := dir.Owner(ctx, "ABCD"); owner != "http://a" {
+		t.Errorf("Expected a to still own the room, got %q", owner)
+	}
+	dir.Release(ctx, "ABCD", "http://a")
+	if owner, _ := dir.Owner(ctx, "ABCD"); owner != "" {
+		t.Errorf("Expected the released room to have no owner, got %q", owner)
+	}
+}
+
+func TestRedisDirectoryClaimsExpire(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	dir := NewRedisDirectory(client, "test", time.Minute)
+	ctx := context.Background()
+
+	dir.Claim(ctx, "ABCD", "http://a")
+	server.FastForward(40 * time.Second)
+	// Claiming again renews the claim
+	dir.Claim(ctx, "ABCD", "http://a")
+	server.FastForward(40 * time.Second)
+	if owner, _ := dir.Owner(ctx, "ABCD"); owner != "http://a" {
+		t.Fatalf("Expected the renewed claim to last, got %q", owner)
+	}
+
+	server.FastForward(time.Minute)
+	if owner, err := dir.Claim(ctx, "ABCD", "http://b"); err != nil || owner != "http://b" {
+		t.Errorf("Expected b to claim the room once a's claim expired, got %q (%v)", owner, err)
+	}
+}
@@ -0,0 +1,37 @@
+package broker
+
+import "sync"
+
+// InProcess delivers messages straight back to this instance. It is the
+// default for a single server.
+type InProcess struct {
+	deliver func(Message)
+	mu      sync.RWMutex
+}
+
+func NewInProcess() *InProcess {
+	return &InProcess{}
+}
+
+func (b *InProcess) Publish(msg Message) error {
+	b.mu.RLock()
+	deliver := b.deliver
+	b.mu.RUnlock()
+
+	if deliver != nil {
+		deliver(msg)
+	}
+	return nil
+}
+
+func (b *InProcess) Subscribe(deliver func(Message)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.deliver = deliver
+	return nil
+}
+
+func (b *InProcess) Close() error {
+	return b.Subscribe(nil)
+}
@@ -0,0 +1,164 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultPublishQueueSize bounds how many messages can wait to be sent
+	// to Redis
+	DefaultPublishQueueSize = 1024
+	// DefaultDrainTimeout bounds how long Close spends sending the messages
+	// still queued
+	DefaultDrainTimeout = 5 * time.Second
+)
+
+// Redis shares room events between server instances over Redis pub/sub.
+// Each room has its own channel, <prefix>:room:<roomId>, and every instance
+// subscribes to all of them with one pattern subscription; an instance with
+// no clients in a room just ignores its events.
+//
+// Publish only queues the message. A single goroutine sends the queue to
+// Redis in order, and every instance, this one included, receives events
+// back from its subscription, so all instances see a room's events in the
+// same order.
+type Redis struct {
+	client *redis.Client
+	prefix string
+	queue  chan Message
+	pubsub *redis.PubSub
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// closing asks the publish loop to send what is queued and stop, and
+	// drained is closed once it has
+	closing   chan struct{}
+	drained   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewRedis creates a broker on client. The caller keeps ownership of the
+// client and closes it after the broker.
+func NewRedis(client *redis.Client, prefix string) *Redis {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Redis{
+		client:  client,
+		prefix:  prefix,
+		queue:   make(chan Message, DefaultPublishQueueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		closing: make(chan struct{}),
+		drained: make(chan struct{}),
+	}
+
+	go b.publishLoop()
+	return b
+}
+
+func (b *Redis) channel(roomID string) string {
+	return b.prefix + ":room:" + roomID
+}
+
+func (b *Redis) Publish(msg Message) error {
+	select {
+	case b.queue <- msg:
+		return nil
+	default:
+		return ErrBacklog
+	}
+}
+
+func (b *Redis) publishLoop() {
+	defer close(b.drained)
+
+	for {
+		select {
+		case msg := <-b.queue:
+			b.send(b.ctx, msg)
+		case <-b.closing:
+			b.drain()
+			return
+		}
+	}
+}
+
+// drain sends the messages still queued, giving up on the rest once
+// DefaultDrainTimeout has passed
+func (b *Redis) drain() {
+	ctx, cancel := context.WithTimeout(b.ctx, DefaultDrainTimeout)
+	defer cancel()
+
+	for {
+		select {
+		case msg := <-b.queue:
+			if err := b.send(ctx, msg); err != nil && ctx.Err() != nil {
+				log.Printf("Dropped %d queued broker messages on close", len(b.queue)+1)
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (b *Redis) send(ctx context.Context, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling broker message: %v", err)
+		return err
+	}
+	if err := b.client.Publish(ctx, b.channel(msg.RoomID), data).Err(); err != nil {
+		log.Printf("Error publishing to room %s: %v", msg.RoomID, err)
+		return err
+	}
+	return nil
+}
+
+// Subscribe returns once Redis has confirmed the subscription, so anything
+// published after it is delivered. The client resubscribes by itself if the
+// connection drops; events published meanwhile are lost.
+func (b *Redis) Subscribe(deliver func(Message)) error {
+	pubsub := b.client.PSubscribe(b.ctx, b.channel("*"))
+	if _, err := pubsub.Receive(b.ctx); err != nil {
+		pubsub.Close()
+		return err
+	}
+	b.pubsub = pubsub
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		for m := range pubsub.Channel() {
+			var msg Message
+			if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+				log.Printf("Ignoring malformed broker message on %s: %v", m.Channel, err)
+				continue
+			}
+			deliver(msg)
+		}
+	}()
+	return nil
+}
+
+// Close sends the messages still queued, waiting up to
+// DefaultDrainTimeout for Redis to take them, then unsubscribes. Messages
+// published after Close are never sent.
+func (b *Redis) Close() error {
+	b.closeOnce.Do(func() { close(b.closing) })
+	<-b.drained
+	b.cancel()
+
+	var err error
+	if b.pubsub != nil {
+		err = b.pubsub.Close()
+	}
+	b.wg.Wait()
+	return err
+}
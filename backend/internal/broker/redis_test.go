@@ -0,0 +1,111 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedis starts a broker subscribed to server, delivering into a channel
+func newTestRedis(t *testing.T, server *miniredis.Miniredis) (*Redis, chan Message) {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	b := NewRedis(client, "test")
+	received := make(chan Message, 100)
+	if err := b.Subscribe(func(msg Message) { received <- msg }); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	t.Cleanup(func() {
+		b.Close()
+		client.Close()
+	})
+	return b, received
+}
+
+func receive(t *testing.T, received chan Message) Message {
+	t.Helper()
+
+	select {
+	case msg := <-received:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for a message")
+		return Message{}
+	}
+}
+
+func TestRedisDeliversToEveryInstance(t *testing.T) {
+	server := miniredis.RunT(t)
+	a, fromA := newTestRedis(t, server)
+	_, fromB := newTestRedis(t, server)
+
+	sent := Message{RoomID: "ABCD", PlayerID: "p1", Data: []byte(`{"type":"YOUR_ROLE"}`)}
+	if err := a.Publish(sent); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	for name, received := range map[string]chan Message{"publisher": fromA, "other instance": fromB} {
+		msg := receive(t, received)
+		if msg.RoomID != sent.RoomID || msg.PlayerID != sent.PlayerID || string(msg.Data) != string(sent.Data) {
+			t.Errorf("Expected %s to receive %+v, got %+v", name, sent, msg)
+		}
+	}
+}
+
+func TestRedisKeepsOrder(t *testing.T) {
+	server := miniredis.RunT(t)
+	a, _ := newTestRedis(t, server)
+	_, fromB := newTestRedis(t, server)
+
+	for i := 0; i < 50; i++ {
+		a.Publish(Message{RoomID: fmt.Sprintf("R%d", i%3), Data: []byte(fmt.Sprint(i))})
+	}
+	a.Publish(Message{RoomID: "R0", Data: []byte{0x03, 0xe8}, Close: true})
+
+	for i := 0; i < 50; i++ {
+		if msg := receive(t, fromB); string(msg.Data) != fmt.Sprint(i) {
+			t.Fatalf("Expected message %d, got %q", i, msg.Data)
+		}
+	}
+	if msg := receive(t, fromB); !msg.Close || len(msg.Data) != 2 {
+		t.Errorf("Expected the close frame last, got %+v", msg)
+	}
+}
+
+func TestRedisIgnoresMalformedMessages(t *testing.T) {
+	server := miniredis.RunT(t)
+	a, received := newTestRedis(t, server)
+
+	server.Publish("test:room:ABCD", "not json")
+	a.Publish(Message{RoomID: "ABCD", Data: []byte("ok")})
+
+	if msg := receive(t, received); string(msg.Data) != "ok" {
+		t.Errorf("Expected the malformed message to be skipped, got %+v", msg)
+	}
+}
+
+func TestRedisCloseSendsQueuedMessages(t *testing.T) {
+	server := miniredis.RunT(t)
+	_, fromB := newTestRedis(t, server)
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	a := NewRedis(client, "test")
+	for i := 0; i < 100; i++ {
+		a.Publish(Message{RoomID: "ABCD", Data: []byte(fmt.Sprint(i))})
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if msg := receive(t, fromB); string(msg.Data) != fmt.Sprint(i) {
+			t.Fatalf("Expected message %d, got %q", i, msg.Data)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package game
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+)
+
+// TestConcurrentGuessesScoreOnce fires many simultaneous guesses at the same
+// round and checks that exactly one of them is scored
+func TestConcurrentGuessesScoreOnce(t *testing.T) {
+	for iter := 0; iter < 20; iter++ {
+		room := setupMatchRoom(t, 3)
+		if _, err := StartGame(room, hostID(room)); err != nil {
+			t.Fatalf("Failed to start game: %v", err)
+		}
+		mantriID, chorID, _ := findRoles(room)
+
+		numGoroutines := 16
+		var wg sync.WaitGroup
+		wg.Add(numGoroutines)
+
+		results := make(chan *GuessResult, numGoroutines)
+		errs := make(chan error, numGoroutines)
+
+		for i := 0; i < numGoroutines; i++ {
+			go func() {
+				defer wg.Done()
+				result, err := ProcessGuess(room, mantriID, chorID)
+				if err != nil {
+					errs <- err
+					return
+				}
+				results <- result
+			}()
+		}
+
+		wg.Wait()
+		close(results)
+		close(errs)
+
+		if len(results) != 1 {
+			t.Fatalf("Iteration %d: expected exactly 1 scored guess, got %d", iter, len(results))
+		}
+		for err := range errs {
+			if !errors.Is(err, store.ErrPhaseConflict) {
+				t.Errorf("Iteration %d: expected phase conflict for rejected guess, got %v", iter, err)
+			}
+		}
+
+		total := 0
+		for _, p := range room.GetPlayers() {
+			total += p.Score
+		}
+		if total != 2300 {
+			t.Errorf("Iteration %d: expected a single round's 2300 points, got %d", iter, total)
+		}
+		if played := room.Snapshot().RoundsPlayed; played != 1 {
+			t.Errorf("Iteration %d: expected 1 round played, got %d", iter, played)
+		}
+	}
+}
+
+// TestConcurrentStartGame checks that only one of many simultaneous starts wins
+func TestConcurrentStartGame(t *testing.T) {
+	room := setupMatchRoom(t, 1)
+
+	numGoroutines := 16
+	var wg sync.WaitGroup
+	wg.Add(numGoroutines)
+
+	var mu sync.Mutex
+	started := 0
+
+	for i := 0; i < numGoroutines; i++ {
+		go func() {
+			defer wg.Done()
+			if _, err := StartGame(room, hostID(room)); err == nil {
+				mu.Lock()
+				started++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if started != 1 {
+		t.Errorf("Expected exactly 1 successful start, got %d", started)
+	}
+}
+
+// TestConcurrentNextRound checks that a round can't be dealt twice
+func TestConcurrentNextRound(t *testing.T) {
+	room := setupMatchRoom(t, 3)
+	StartGame(room, hostID(room))
+	mantriID, chorID, _ := findRoles(room)
+	if _, err := ProcessGuess(room, mantriID, chorID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	numGoroutines := 16
+	var wg sync.WaitGroup
+	wg.Add(numGoroutines)
+
+	var mu sync.Mutex
+	advanced := 0
+
+	for i := 0; i < numGoroutines; i++ {
+		go func() {
+			defer wg.Done()
+			if _, err := NextRound(room, hostID(room)); err == nil {
+				mu.Lock()
+				advanced++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if advanced != 1 {
+		t.Errorf("Expected exactly 1 successful NextRound, got %d", advanced)
+	}
+}
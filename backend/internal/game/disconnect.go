@@ -0,0 +1,104 @@
+package game
+
+import (
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+)
+
+// DisconnectPolicy decides what happens to a round that is waiting on a
+// player who stayed disconnected past the grace period
+type DisconnectPolicy string
+
+const (
+	// PolicyForfeit scores the round as if the absent Mantri guessed wrong
+	PolicyForfeit DisconnectPolicy = "forfeit"
+	// PolicyBot has a bot make the absent Mantri's guess at random
+	PolicyBot DisconnectPolicy = "bot"
+	// PolicyAbort abandons the round unscored and sends the room back to
+	// WAITING, as if the player had left
+	PolicyAbort DisconnectPolicy = "abort"
+
+	DefaultDisconnectPolicy = PolicyForfeit
+)
+
+// ParseDisconnectPolicy returns the named policy, or the default one if
+// name is empty
+func ParseDisconnectPolicy(name string) (DisconnectPolicy, error) {
+	switch policy := DisconnectPolicy(name); policy {
+	case "":
+		return DefaultDisconnectPolicy, nil
+	case PolicyForfeit, PolicyBot, PolicyAbort:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown disconnect policy %q", name)
+}
+
+// DisconnectOutcome is what ResolveDisconnect did to the round
+type DisconnectOutcome struct {
+	Policy DisconnectPolicy
+	// Result is the scored round, when it was forfeited or a bot guessed
+	Result *GuessResult
+	// RoundAborted is set when the round was abandoned and the room went
+	// back to WAITING
+	RoundAborted bool
+}
+
+// ResolveDisconnect applies the room's disconnect policy once playerID's
+// grace period has run out. It only acts while the round is waiting on
+// them, that is while they are the Mantri of a round in GUESSING, and
+// returns nil otherwise: every other seat can sit out a round without
+// holding it up.
+func ResolveDisconnect(room *store.Room, playerID string) (*DisconnectOutcome, error) {
+	var outcome *DisconnectOutcome
+
+	err := room.Update(func(state *store.RoomState) error {
+		if state.Status != store.PhaseGuessing {
+			return nil
+		}
+		byRole, err := rolesInPlay(state)
+		if err != nil {
+			return err
+		}
+		if byRole["Mantri"].ID != playerID {
+			return nil
+		}
+
+		policy, err := ParseDisconnectPolicy(state.DisconnectPolicy)
+		if err != nil {
+			return err
+		}
+		outcome = &DisconnectOutcome{Policy: policy}
+
+		switch policy {
+		case PolicyForfeit:
+			outcome.Result, err = scoreRound(state, byRole, "")
+			return err
+
+		case PolicyBot:
+			var suspects []string
+			for _, p := range state.Players {
+				if p.ID != playerID {
+					suspects = append(suspects, p.ID)
+				}
+			}
+			outcome.Result, err = scoreRound(state, byRole, suspects[rand.IntN(len(suspects))])
+			return err
+
+		case PolicyAbort:
+			if err := state.Transition(store.PhaseWaiting); err != nil {
+				return err
+			}
+			for i := range state.Players {
+				state.Players[i].Role = ""
+			}
+			outcome.RoundAborted = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return outcome, nil
+}
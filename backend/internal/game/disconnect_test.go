@@ -0,0 +1,83 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+)
+
+func TestParseDisconnectPolicy(t *testing.T) {
+	if policy, err := ParseDisconnectPolicy(""); err != nil || policy != DefaultDisconnectPolicy {
+		t.Errorf("Expected the default policy, got %q (%v)", policy, err)
+	}
+	if _, err := ParseDisconnectPolicy("shrug"); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
+
+// TestResolveDisconnect tests each policy against a round whose Mantri is
+// gone
+func TestResolveDisconnect(t *testing.T) {
+	tests := []struct {
+		policy    DisconnectPolicy
+		wantPhase store.Phase
+		check     func(t *testing.T, room *store.Room, outcome *DisconnectOutcome, mantriID string, chorID string)
+	}{
+		{PolicyForfeit, store.PhaseFinished, func(t *testing.T, room *store.Room, outcome *DisconnectOutcome, mantriID string, chorID string) {
+			if outcome.Result == nil || outcome.Result.Correct {
+				t.Fatalf("Expected the round to be scored as a wrong guess, got %+v", outcome.Result)
+			}
+			if outcome.Result.UpdatedScores[mantriID] != 0 || outcome.Result.UpdatedScores[chorID] != 800 {
+				t.Errorf("Expected the Chor to take the Mantri's points, got %v", outcome.Result.UpdatedScores)
+			}
+		}},
+		{PolicyBot, store.PhaseFinished, func(t *testing.T, room *store.Room, outcome *DisconnectOutcome, mantriID string, chorID string) {
+			if outcome.Result == nil || outcome.Result.ChorID == "" || outcome.Result.ChorID == mantriID {
+				t.Errorf("Expected the bot to accuse another player, got %+v", outcome.Result)
+			}
+		}},
+		{PolicyAbort, store.PhaseWaiting, func(t *testing.T, room *store.Room, outcome *DisconnectOutcome, mantriID string, chorID string) {
+			if !outcome.RoundAborted || outcome.Result != nil {
+				t.Errorf("Expected the round to be abandoned unscored, got %+v", outcome)
+			}
+			for _, p := range room.GetPlayers() {
+				if p.Role != "" || p.Score != 0 {
+					t.Errorf("Expected roles cleared and no score, got %+v", p)
+				}
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			room := setupMatchRoom(t, 1)
+			room.SetDisconnectPolicy(string(tt.policy))
+			if _, err := StartGame(room, hostID(room)); err != nil {
+				t.Fatalf("StartGame failed: %v", err)
+			}
+			mantriID, chorID, sipahiID := findRoles(room)
+
+			// A player the round isn't waiting on changes nothing
+			if outcome, err := ResolveDisconnect(room, sipahiID); err != nil || outcome != nil {
+				t.Fatalf("Expected nothing to happen for the Sipahi, got %+v (%v)", outcome, err)
+			}
+
+			outcome, err := ResolveDisconnect(room, mantriID)
+			if err != nil {
+				t.Fatalf("ResolveDisconnect failed: %v", err)
+			}
+			if outcome == nil || outcome.Policy != tt.policy {
+				t.Fatalf("Expected policy %s to be applied, got %+v", tt.policy, outcome)
+			}
+			if room.GetStatus() != tt.wantPhase {
+				t.Errorf("Expected room to be %s, got %s", tt.wantPhase, room.GetStatus())
+			}
+			tt.check(t, room, outcome, mantriID, chorID)
+
+			// Once the round has moved on, a late expiry is ignored
+			if outcome, err := ResolveDisconnect(room, mantriID); err != nil || outcome != nil {
+				t.Errorf("Expected a second expiry to do nothing, got %+v (%v)", outcome, err)
+			}
+		})
+	}
+}
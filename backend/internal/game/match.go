@@ -0,0 +1,101 @@
+package game
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+)
+
+// Standing is a player's position in the match by cumulative score
+type Standing struct {
+	Rank     int    `json:"rank"`
+	PlayerID string `json:"playerId"`
+	Name     string `json:"name"`
+	Score    int    `json:"score"`
+}
+
+// Deal is a freshly dealt round as the update that dealt it left the room
+type Deal struct {
+	Round       int
+	TotalRounds int
+	// Players are the room's players holding their new roles
+	Players []store.Player
+}
+
+// StartGame deals roles for the first round of a match, or for the
+// interrupted round of a match that went back to WAITING when a player left.
+// actorID must be the room's host.
+func StartGame(room *store.Room, actorID string) (*Deal, error) {
+	return deal(room, func(state *store.RoomState) error {
+		if err := state.RequireHost(actorID); err != nil {
+			return err
+		}
+		if state.Status != store.PhaseWaiting {
+			return &store.PhaseError{Op: "start the game", Phase: state.Status}
+		}
+		return nil
+	})
+}
+
+// NextRound starts the next round of a match by reshuffling roles.
+// Cumulative scores are carried over untouched. actorID must be the room's
+// host.
+func NextRound(room *store.Room, actorID string) (*Deal, error) {
+	return deal(room, func(state *store.RoomState) error {
+		if err := state.RequireHost(actorID); err != nil {
+			return err
+		}
+		if state.Status != store.PhaseRoundOver {
+			return &store.PhaseError{Op: "start the next round", Phase: state.Status}
+		}
+		return nil
+	})
+}
+
+// deal deals roles if check allows it, and returns the round dealt
+func deal(room *store.Room, check func(state *store.RoomState) error) (*Deal, error) {
+	var dealt *Deal
+	err := room.Update(func(state *store.RoomState) error {
+		if err := check(state); err != nil {
+			return err
+		}
+		if err := assignRoles(state); err != nil {
+			return err
+		}
+		dealt = &Deal{
+			Round:       state.RoundsPlayed + 1,
+			TotalRounds: state.TotalRounds,
+			Players:     slices.Clone(state.Players),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dealt, nil
+}
+
+// Standings ranks players by cumulative score. Players with equal scores
+// share a rank.
+func Standings(players []store.Player) []Standing {
+	players = slices.Clone(players)
+	sort.SliceStable(players, func(i, j int) bool {
+		return players[i].Score > players[j].Score
+	})
+
+	standings := make([]Standing, len(players))
+	for i, p := range players {
+		rank := i + 1
+		if i > 0 && p.Score == players[i-1].Score {
+			rank = standings[i-1].Rank
+		}
+		standings[i] = Standing{
+			Rank:     rank,
+			PlayerID: p.ID,
+			Name:     p.Name,
+			Score:    p.Score,
+		}
+	}
+	return standings
+}
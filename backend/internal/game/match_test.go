@@ -0,0 +1,287 @@
+package game
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+)
+
+func setupMatchRoom(t *testing.T, rounds int) *store.Room {
+	t.Helper()
+
+	rm := store.NewRoomManager()
+	room, _ := rm.CreateRoom("MATCH")
+	room.SetTotalRounds(rounds)
+
+	for _, name := range []string{"Alice", "Bob", "Charlie", "David"} {
+		room.AddPlayer(store.Player{Identity: store.Identity{ID: name + "-id", Name: name}})
+	}
+	return room
+}
+
+func findRoles(room *store.Room) (mantriID, chorID, sipahiID string) {
+	for _, p := range room.GetPlayers() {
+		switch p.Role {
+		case "Mantri":
+			mantriID = p.ID
+		case "Chor":
+			chorID = p.ID
+		case "Sipahi":
+			sipahiID = p.ID
+		}
+	}
+	return
+}
+
+// TestMultiRoundCumulativeScores plays a full 3-round match and checks that
+// per-round deltas accumulate into the running totals
+func TestMultiRoundCumulativeScores(t *testing.T) {
+	room := setupMatchRoom(t, 3)
+	AssignRoles(room)
+
+	expectedTotals := make(map[string]int)
+	for round := 1; round <= 3; round++ {
+		mantriID, chorID, sipahiID := findRoles(room)
+
+		guess := chorID
+		if round == 2 {
+			guess = sipahiID
+		}
+
+		result, err := ProcessGuess(room, mantriID, guess)
+		if err != nil {
+			t.Fatalf("Round %d: unexpected error: %v", round, err)
+		}
+
+		if result.Round != round {
+			t.Errorf("Expected round %d, got %d", round, result.Round)
+		}
+
+		roundTotal := 0
+		for id, delta := range result.RoundScores {
+			expectedTotals[id] += delta
+			roundTotal += delta
+		}
+		if roundTotal != 2300 {
+			t.Errorf("Round %d: expected deltas to total 2300, got %d", round, roundTotal)
+		}
+
+		for id, total := range expectedTotals {
+			if result.UpdatedScores[id] != total {
+				t.Errorf("Round %d: player %s expected total %d, got %d", round, id, total, result.UpdatedScores[id])
+			}
+		}
+
+		if round < 3 {
+			if result.MatchOver {
+				t.Fatalf("Round %d: match should not be over yet", round)
+			}
+			if room.GetStatus() != "ROUND_OVER" {
+				t.Fatalf("Round %d: expected status ROUND_OVER, got %s", round, room.GetStatus())
+			}
+			if _, err := NextRound(room, hostID(room)); err != nil {
+				t.Fatalf("Round %d: NextRound failed: %v", round, err)
+			}
+			if room.GetStatus() != "GUESSING" {
+				t.Fatalf("Round %d: expected status GUESSING after NextRound, got %s", round, room.GetStatus())
+			}
+		} else {
+			if !result.MatchOver {
+				t.Error("Expected match to be over after the final round")
+			}
+			if room.GetStatus() != "FINISHED" {
+				t.Errorf("Expected status FINISHED, got %s", room.GetStatus())
+			}
+		}
+	}
+
+	grandTotal := 0
+	for _, total := range expectedTotals {
+		grandTotal += total
+	}
+	if grandTotal != 3*2300 {
+		t.Errorf("Expected grand total %d, got %d", 3*2300, grandTotal)
+	}
+}
+
+// TestProcessGuessRejectsRescoring ensures a finished round can't be scored twice
+func TestProcessGuessRejectsRescoring(t *testing.T) {
+	room := setupMatchRoom(t, 2)
+	AssignRoles(room)
+
+	mantriID, chorID, _ := findRoles(room)
+	if _, err := ProcessGuess(room, mantriID, chorID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	before := room.GetPlayers()
+	if _, err := ProcessGuess(room, mantriID, chorID); !errors.Is(err, store.ErrPhaseConflict) {
+		t.Fatalf("Expected phase conflict when guessing twice in the same round, got %v", err)
+	}
+
+	after := room.GetPlayers()
+	for i := range before {
+		if before[i].Score != after[i].Score {
+			t.Errorf("Player %s score changed from %d to %d", before[i].ID, before[i].Score, after[i].Score)
+		}
+	}
+}
+
+// TestNextRoundRequiresRoundOver tests NextRound outside of the between-rounds phase
+func TestNextRoundRequiresRoundOver(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Setup func(room *store.Room)
+	}{
+		{"Waiting room", func(room *store.Room) {}},
+		{"Round in progress", func(room *store.Room) { AssignRoles(room) }},
+		{"Match finished", func(room *store.Room) {
+			AssignRoles(room)
+			mantriID, chorID, _ := findRoles(room)
+			ProcessGuess(room, mantriID, chorID)
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			room := setupMatchRoom(t, 1)
+			tc.Setup(room)
+
+			if _, err := NextRound(room, hostID(room)); err == nil {
+				t.Error("Expected NextRound to fail")
+			}
+		})
+	}
+}
+
+// TestRoundsRequireHost checks that StartGame and NextRound reject anyone but
+// the current host, including a host who has just handed over
+func TestRoundsRequireHost(t *testing.T) {
+	room := setupMatchRoom(t, 2)
+
+	if _, err := StartGame(room, "Bob-id"); !errors.Is(err, store.ErrNotHost) {
+		t.Fatalf("Expected ErrNotHost from non-host StartGame, got %v", err)
+	}
+	if room.GetStatus() != store.PhaseWaiting {
+		t.Fatalf("Expected room to stay WAITING, got %s", room.GetStatus())
+	}
+
+	if _, err := StartGame(room, "Alice-id"); err != nil {
+		t.Fatalf("StartGame failed: %v", err)
+	}
+	mantriID, chorID, _ := findRoles(room)
+	ProcessGuess(room, mantriID, chorID)
+
+	if err := room.TransferHost("Alice-id", "Bob-id"); err != nil {
+		t.Fatalf("TransferHost failed: %v", err)
+	}
+	if _, err := NextRound(room, "Alice-id"); !errors.Is(err, store.ErrNotHost) {
+		t.Fatalf("Expected ErrNotHost from old host, got %v", err)
+	}
+	if _, err := NextRound(room, "Bob-id"); err != nil {
+		t.Fatalf("NextRound by new host failed: %v", err)
+	}
+}
+
+// TestStandings tests ranking by cumulative score including ties
+func TestStandings(t *testing.T) {
+	rm := store.NewRoomManager()
+	room, _ := rm.CreateRoom("STANDINGS")
+	room.AddPlayer(store.Player{Identity: store.Identity{ID: "a", Name: "A"}, Seat: store.Seat{Score: 1500}})
+	room.AddPlayer(store.Player{Identity: store.Identity{ID: "b", Name: "B"}, Seat: store.Seat{Score: 2300}})
+	room.AddPlayer(store.Player{Identity: store.Identity{ID: "c", Name: "C"}, Seat: store.Seat{Score: 1500}})
+	room.AddPlayer(store.Player{Identity: store.Identity{ID: "d", Name: "D"}})
+
+	standings := Standings(room.GetPlayers())
+
+	expected := []struct {
+		ID   string
+		Rank int
+	}{
+		{"b", 1}, {"a", 2}, {"c", 2}, {"d", 4},
+	}
+
+	for i, e := range expected {
+		if standings[i].PlayerID != e.ID || standings[i].Rank != e.Rank {
+			t.Errorf("Position %d: expected %s at rank %d, got %s at rank %d",
+				i, e.ID, e.Rank, standings[i].PlayerID, standings[i].Rank)
+		}
+	}
+}
+
+// TestHostSurvivesRounds checks that dealing game roles each round leaves
+// the host's seat permission alone
+func TestHostSurvivesRounds(t *testing.T) {
+	room := setupMatchRoom(t, 2)
+
+	if _, err := StartGame(room, hostID(room)); err != nil {
+		t.Fatalf("StartGame failed: %v", err)
+	}
+	mantriID, chorID, _ := findRoles(room)
+	if _, err := ProcessGuess(room, mantriID, chorID); err != nil {
+		t.Fatalf("ProcessGuess failed: %v", err)
+	}
+	if _, err := NextRound(room, hostID(room)); err != nil {
+		t.Fatalf("NextRound failed: %v", err)
+	}
+
+	if hostID(room) != "Alice-id" {
+		t.Errorf("Expected Alice to still be host, got %q", hostID(room))
+	}
+	for _, p := range room.GetPlayers() {
+		if p.Role == "" {
+			t.Errorf("Player %s has no game role in round 2", p.ID)
+		}
+		want := store.PermissionPlayer
+		if p.ID == "Alice-id" {
+			want = store.PermissionHost
+		}
+		if p.Permission != want {
+			t.Errorf("Player %s: expected permission %s, got %s", p.ID, want, p.Permission)
+		}
+	}
+}
+
+// TestDealsAndResultsAreSnapshots checks that a deal and a scored round
+// carry the room as their own update left it, unaffected by later changes
+func TestDealsAndResultsAreSnapshots(t *testing.T) {
+	room := setupMatchRoom(t, 2)
+
+	dealt, err := StartGame(room, "Alice-id")
+	if err != nil {
+		t.Fatalf("StartGame failed: %v", err)
+	}
+	if dealt.Round != 1 || dealt.TotalRounds != 2 || len(dealt.Players) != 4 {
+		t.Fatalf("Expected round 1 of 2 dealt to 4 players, got %+v", dealt)
+	}
+	for _, p := range dealt.Players {
+		if p.Role == "" {
+			t.Errorf("Expected every dealt player to hold a role, got %+v", p)
+		}
+	}
+
+	mantriID, chorID, _ := findRoles(room)
+	result, err := ProcessGuess(room, mantriID, chorID)
+	if err != nil {
+		t.Fatalf("ProcessGuess failed: %v", err)
+	}
+	next, err := NextRound(room, "Alice-id")
+	if err != nil {
+		t.Fatalf("NextRound failed: %v", err)
+	}
+	if next.Round != 2 || next.TotalRounds != 2 {
+		t.Errorf("Expected round 2 of 2, got %d of %d", next.Round, next.TotalRounds)
+	}
+
+	// The next deal reshuffled the roles, but the result still has the
+	// scored round's
+	for _, p := range result.Players {
+		if p.Score != result.UpdatedScores[p.ID] {
+			t.Errorf("Expected %s to have the scored round's score, got %d", p.ID, p.Score)
+		}
+		if p.ID == mantriID && p.Role != "Mantri" {
+			t.Errorf("Expected the result to keep the scored round's roles, got %s for the Mantri", p.Role)
+		}
+	}
+}
@@ -2,30 +2,40 @@ package game
 
 import (
 	"errors"
+	"fmt"
 	"math/rand/v2"
+	"slices"
 
 	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
 )
 
-func AssignRoles(room *store.Room) {
-	players := room.GetPlayers()
-	if len(players) != 4 {
-		return
+func AssignRoles(room *store.Room) error {
+	return room.Update(assignRoles)
+}
+
+// assignRoles deals a shuffled role table to the players in state and moves
+// it to GUESSING
+func assignRoles(state *store.RoomState) error {
+	table, err := RoleTableFor(state.PlayerCount)
+	if err != nil {
+		return err
+	}
+
+	if len(state.Players) != table.Players {
+		return fmt.Errorf("need exactly %d players to assign roles, room has %d", table.Players, len(state.Players))
 	}
 
-	roles := []string{"Raja", "Mantri", "Chor", "Sipahi"}
+	roles := table.Roles
 
 	rand.Shuffle(len(roles), func(i, j int) {
 		roles[i], roles[j] = roles[j], roles[i]
 	})
 
-	updatedPlayers := make([]store.Player, len(players))
-	for i := 0; i < len(players); i++ {
-		updatedPlayers[i] = players[i]
-		updatedPlayers[i].Role = roles[i]
+	for i := range state.Players {
+		state.Players[i].Role = roles[i]
 	}
 
-	room.UpdatePlayersAndStatus(updatedPlayers, "GUESSING")
+	return state.Transition(store.PhaseGuessing)
 }
 
 type GuessResult struct {
@@ -33,74 +43,113 @@ type GuessResult struct {
 	MantriID      string
 	ChorID        string
 	ActualChorID  string
+	Round         int
+	TotalRounds   int
+	MatchOver     bool
+	RoundScores   map[string]int
 	UpdatedScores map[string]int
+	// Players are the room's players as the scored round left them
+	Players []store.Player `json:"-"`
 }
 
+// ProcessGuess scores the Mantri's guess for the current round. Validation and
+// scoring happen in a single room update, so a round can only be scored once
+// even when guesses race.
 func ProcessGuess(room *store.Room, mantriPlayerID string, guessedChorPlayerID string) (*GuessResult, error) {
-	players := room.GetPlayers()
+	var result *GuessResult
+
+	err := room.Update(func(state *store.RoomState) error {
+		byRole, err := rolesInPlay(state)
+		if err != nil {
+			return err
+		}
+		if byRole["Mantri"].ID != mantriPlayerID {
+			return errors.New("only the Mantri can make a guess")
+		}
+
+		result, err = scoreRound(state, byRole, guessedChorPlayerID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
 
-	var mantri, chor, raja, sipahi *store.Player
+// rolesInPlay maps each role of the current round to its player, checking
+// that the room's whole role table has been dealt
+func rolesInPlay(state *store.RoomState) (map[string]*store.Player, error) {
+	table, err := RoleTableFor(state.PlayerCount)
+	if err != nil {
+		return nil, err
+	}
+
+	players := state.Players
 
+	byRole := make(map[string]*store.Player, len(players))
 	for i := range players {
-		player := &players[i]
-		switch player.Role {
-		case "Mantri":
-			mantri = player
-		case "Chor":
-			chor = player
-		case "Raja":
-			raja = player
-		case "Sipahi":
-			sipahi = player
-		}
+		byRole[players[i].Role] = &players[i]
 	}
 
-	if mantri == nil || chor == nil || raja == nil || sipahi == nil {
+	if len(players) != table.Players || len(byRole) != len(table.Roles) {
 		return nil, errors.New("room does not have all required roles assigned")
 	}
+	for _, role := range table.Roles {
+		if byRole[role] == nil {
+			return nil, errors.New("room does not have all required roles assigned")
+		}
+	}
+	return byRole, nil
+}
+
+// scoreRound completes the current round with the Mantri having accused
+// guessedChorPlayerID, which is empty if they never guessed, and adds the
+// round's scores to the players in state
+func scoreRound(state *store.RoomState, byRole map[string]*store.Player, guessedChorPlayerID string) (*GuessResult, error) {
+	players := state.Players
+	mantri, chor := byRole["Mantri"], byRole["Chor"]
 
-	if mantri.ID != mantriPlayerID {
-		return nil, errors.New("only the Mantri can make a guess")
+	policy, err := LookupScoringPolicy(state.Scoring)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := state.CompleteRound(); err != nil {
+		return nil, err
 	}
 
 	correctGuess := (guessedChorPlayerID == chor.ID)
 
-	updatedPlayers := make([]store.Player, len(players))
-	copy(updatedPlayers, players)
-
-	for i := range updatedPlayers {
-		if updatedPlayers[i].Role == "Raja" {
-			updatedPlayers[i].Score = 1000
-		} else if updatedPlayers[i].Role == "Mantri" {
-			if correctGuess {
-				updatedPlayers[i].Score = 800
-			} else {
-				updatedPlayers[i].Score = 0
-			}
-		} else if updatedPlayers[i].Role == "Sipahi" {
-			updatedPlayers[i].Score = 500
-		} else if updatedPlayers[i].Role == "Chor" {
-			if correctGuess {
-				updatedPlayers[i].Score = 0
-			} else {
-				updatedPlayers[i].Score = 800
-			}
-		}
+	outcome := RoundOutcome{
+		Roles:     make(map[string]string, len(players)),
+		MantriID:  mantri.ID,
+		ChorID:    chor.ID,
+		GuessedID: guessedChorPlayerID,
+		Correct:   correctGuess,
+	}
+	for _, player := range players {
+		outcome.Roles[player.ID] = player.Role
 	}
 
-	room.UpdatePlayersAndStatus(updatedPlayers, "FINISHED")
+	roundScores := policy.Score(outcome)
 
 	result := &GuessResult{
 		Correct:       correctGuess,
 		MantriID:      mantri.ID,
 		ChorID:        guessedChorPlayerID,
 		ActualChorID:  chor.ID,
+		Round:         state.RoundsPlayed,
+		TotalRounds:   state.TotalRounds,
+		MatchOver:     state.Status == store.PhaseFinished,
+		RoundScores:   roundScores,
 		UpdatedScores: make(map[string]int),
 	}
 
-	for _, player := range updatedPlayers {
-		result.UpdatedScores[player.ID] = player.Score
+	for i := range players {
+		players[i].Score += roundScores[players[i].ID]
+		result.UpdatedScores[players[i].ID] = players[i].Score
 	}
-
+	result.Players = slices.Clone(players)
 	return result, nil
 }
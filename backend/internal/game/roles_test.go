@@ -10,16 +10,13 @@ import (
 func TestAssignRolesExactly4Players(t *testing.T) {
 	// Create a room manager and room
 	rm := store.NewRoomManager()
-	room := rm.CreateRoom("TEST1")
+	room, _ := rm.CreateRoom("TEST1")
 
 	// Add exactly 4 players
 	players := []string{"Alice", "Bob", "Charlie", "David"}
 	for _, name := range players {
 		player := store.Player{
-			ID:    name + "-id",
-			Name:  name,
-			Role:  "", // Initially no role
-			Score: 0,
+			Identity: store.Identity{ID: name + "-id", Name: name},
 		}
 		room.AddPlayer(player)
 	}
@@ -86,15 +83,12 @@ func TestAssignRolesExactly4Players(t *testing.T) {
 // TestAssignRolesLessThan4Players tests that roles are NOT assigned when less than 4 players
 func TestAssignRolesLessThan4Players(t *testing.T) {
 	rm := store.NewRoomManager()
-	room := rm.CreateRoom("TEST2")
+	room, _ := rm.CreateRoom("TEST2")
 
 	// Add only 3 players
 	for i := 1; i <= 3; i++ {
 		player := store.Player{
-			ID:    string(rune(i + 48)),
-			Name:  "Player" + string(rune(i+48)),
-			Role:  "",
-			Score: 0,
+			Identity: store.Identity{ID: string(rune(i + 48)), Name: "Player" + string(rune(i+48))},
 		}
 		room.AddPlayer(player)
 	}
@@ -120,18 +114,18 @@ func TestAssignRolesLessThan4Players(t *testing.T) {
 // TestAssignRolesMoreThan4Players tests that roles are NOT assigned when more than 4 players
 func TestAssignRolesMoreThan4Players(t *testing.T) {
 	rm := store.NewRoomManager()
-	room := rm.CreateRoom("TEST3")
+	room, _ := rm.CreateRoom("TEST3")
 
-	// Add 5 players
+	// Add 5 players. AddPlayer won't overfill the 4-player room, so the cap
+	// is only lowered afterwards.
+	room.SetPlayerCount(5)
 	for i := 1; i <= 5; i++ {
 		player := store.Player{
-			ID:    string(rune(i + 48)),
-			Name:  "Player" + string(rune(i+48)),
-			Role:  "",
-			Score: 0,
+			Identity: store.Identity{ID: string(rune(i + 48)), Name: "Player" + string(rune(i+48))},
 		}
 		room.AddPlayer(player)
 	}
+	room.SetPlayerCount(4)
 
 	// Call AssignRoles
 	AssignRoles(room)
@@ -159,16 +153,13 @@ func TestAssignRolesRandomness(t *testing.T) {
 	iterations := 100
 	for iter := 0; iter < iterations; iter++ {
 		rm := store.NewRoomManager()
-		room := rm.CreateRoom("TEST-RANDOM")
+		room, _ := rm.CreateRoom("TEST-RANDOM")
 
 		// Add 4 players with consistent names
 		players := []string{"Player0", "Player1", "Player2", "Player3"}
 		for _, name := range players {
 			player := store.Player{
-				ID:    name + "-id",
-				Name:  name,
-				Role:  "",
-				Score: 0,
+				Identity: store.Identity{ID: name + "-id", Name: name},
 			}
 			room.AddPlayer(player)
 		}
@@ -200,7 +191,7 @@ func TestAssignRolesRandomness(t *testing.T) {
 // TestAssignRolesWithEmptyRoom tests behavior with empty room
 func TestAssignRolesWithEmptyRoom(t *testing.T) {
 	rm := store.NewRoomManager()
-	room := rm.CreateRoom("TEST-EMPTY")
+	room, _ := rm.CreateRoom("TEST-EMPTY")
 
 	// Don't add any players
 
@@ -229,15 +220,12 @@ func TestAssignRolesThreadSafety(t *testing.T) {
 	rooms := make([]*store.Room, numRooms)
 
 	for i := 0; i < numRooms; i++ {
-		room := rm.CreateRoom("ROOM-" + string(rune(i+48)))
+		room, _ := rm.CreateRoom("ROOM-" + string(rune(i+48)))
 
 		// Add 4 players to each room
 		for j := 0; j < 4; j++ {
 			player := store.Player{
-				ID:    "player-" + string(rune(i+48)) + "-" + string(rune(j+48)),
-				Name:  "Player" + string(rune(j+48)),
-				Role:  "",
-				Score: 0,
+				Identity: store.Identity{ID: "player-" + string(rune(i+48)) + "-" + string(rune(j+48)), Name: "Player" + string(rune(j+48))},
 			}
 			room.AddPlayer(player)
 		}
@@ -289,14 +277,14 @@ func TestAssignRolesThreadSafety(t *testing.T) {
 // TestProcessGuessCorrect tests when Mantri guesses the Chor correctly
 func TestProcessGuessCorrect(t *testing.T) {
 	rm := store.NewRoomManager()
-	room := rm.CreateRoom("GUESS1")
+	room, _ := rm.CreateRoom("GUESS1")
 
 	// Add 4 players with specific roles
 	players := []store.Player{
-		{ID: "raja-1", Name: "Raja Player", Role: "Raja", Score: 0},
-		{ID: "mantri-1", Name: "Mantri Player", Role: "Mantri", Score: 0},
-		{ID: "chor-1", Name: "Chor Player", Role: "Chor", Score: 0},
-		{ID: "sipahi-1", Name: "Sipahi Player", Role: "Sipahi", Score: 0},
+		{Identity: store.Identity{ID: "raja-1", Name: "Raja Player"}, Role: "Raja"},
+		{Identity: store.Identity{ID: "mantri-1", Name: "Mantri Player"}, Role: "Mantri"},
+		{Identity: store.Identity{ID: "chor-1", Name: "Chor Player"}, Role: "Chor"},
+		{Identity: store.Identity{ID: "sipahi-1", Name: "Sipahi Player"}, Role: "Sipahi"},
 	}
 
 	for _, player := range players {
@@ -304,7 +292,7 @@ func TestProcessGuessCorrect(t *testing.T) {
 	}
 
 	// Manually set roles and status to GUESSING
-	room.UpdatePlayersAndStatus(players, "GUESSING")
+	dealPlayers(t, room, players)
 
 	// Mantri makes correct guess
 	result, err := ProcessGuess(room, "mantri-1", "chor-1")
@@ -355,21 +343,21 @@ func TestProcessGuessCorrect(t *testing.T) {
 // TestProcessGuessWrong tests when Mantri guesses incorrectly
 func TestProcessGuessWrong(t *testing.T) {
 	rm := store.NewRoomManager()
-	room := rm.CreateRoom("GUESS2")
+	room, _ := rm.CreateRoom("GUESS2")
 
 	// Add 4 players with specific roles
 	players := []store.Player{
-		{ID: "raja-2", Name: "Raja Player", Role: "Raja", Score: 0},
-		{ID: "mantri-2", Name: "Mantri Player", Role: "Mantri", Score: 0},
-		{ID: "chor-2", Name: "Chor Player", Role: "Chor", Score: 0},
-		{ID: "sipahi-2", Name: "Sipahi Player", Role: "Sipahi", Score: 0},
+		{Identity: store.Identity{ID: "raja-2", Name: "Raja Player"}, Role: "Raja"},
+		{Identity: store.Identity{ID: "mantri-2", Name: "Mantri Player"}, Role: "Mantri"},
+		{Identity: store.Identity{ID: "chor-2", Name: "Chor Player"}, Role: "Chor"},
+		{Identity: store.Identity{ID: "sipahi-2", Name: "Sipahi Player"}, Role: "Sipahi"},
 	}
 
 	for _, player := range players {
 		room.AddPlayer(player)
 	}
 
-	room.UpdatePlayersAndStatus(players, "GUESSING")
+	dealPlayers(t, room, players)
 
 	// Mantri makes wrong guess (guesses Sipahi instead of Chor)
 	result, err := ProcessGuess(room, "mantri-2", "sipahi-2")
@@ -420,20 +408,20 @@ func TestProcessGuessWrong(t *testing.T) {
 // TestProcessGuessNonMantriCaller tests that non-Mantri cannot make guess
 func TestProcessGuessNonMantriCaller(t *testing.T) {
 	rm := store.NewRoomManager()
-	room := rm.CreateRoom("GUESS3")
+	room, _ := rm.CreateRoom("GUESS3")
 
 	players := []store.Player{
-		{ID: "raja-3", Name: "Raja Player", Role: "Raja", Score: 0},
-		{ID: "mantri-3", Name: "Mantri Player", Role: "Mantri", Score: 0},
-		{ID: "chor-3", Name: "Chor Player", Role: "Chor", Score: 0},
-		{ID: "sipahi-3", Name: "Sipahi Player", Role: "Sipahi", Score: 0},
+		{Identity: store.Identity{ID: "raja-3", Name: "Raja Player"}, Role: "Raja"},
+		{Identity: store.Identity{ID: "mantri-3", Name: "Mantri Player"}, Role: "Mantri"},
+		{Identity: store.Identity{ID: "chor-3", Name: "Chor Player"}, Role: "Chor"},
+		{Identity: store.Identity{ID: "sipahi-3", Name: "Sipahi Player"}, Role: "Sipahi"},
 	}
 
 	for _, player := range players {
 		room.AddPlayer(player)
 	}
 
-	room.UpdatePlayersAndStatus(players, "GUESSING")
+	dealPlayers(t, room, players)
 
 	// Try to make guess as Raja (not Mantri)
 	result, err := ProcessGuess(room, "raja-3", "chor-3")
@@ -463,14 +451,14 @@ func TestProcessGuessNonMantriCaller(t *testing.T) {
 // TestProcessGuessNoRolesAssigned tests error when roles not assigned
 func TestProcessGuessNoRolesAssigned(t *testing.T) {
 	rm := store.NewRoomManager()
-	room := rm.CreateRoom("GUESS4")
+	room, _ := rm.CreateRoom("GUESS4")
 
 	// Add 4 players but without roles
 	players := []store.Player{
-		{ID: "player-1", Name: "Player 1", Role: "", Score: 0},
-		{ID: "player-2", Name: "Player 2", Role: "", Score: 0},
-		{ID: "player-3", Name: "Player 3", Role: "", Score: 0},
-		{ID: "player-4", Name: "Player 4", Role: "", Score: 0},
+		{Identity: store.Identity{ID: "player-1", Name: "Player 1"}},
+		{Identity: store.Identity{ID: "player-2", Name: "Player 2"}},
+		{Identity: store.Identity{ID: "player-3", Name: "Player 3"}},
+		{Identity: store.Identity{ID: "player-4", Name: "Player 4"}},
 	}
 
 	for _, player := range players {
@@ -499,16 +487,13 @@ func TestProcessGuessNoRolesAssigned(t *testing.T) {
 // TestProcessGuessWithRealFlow tests the complete flow with AssignRoles
 func TestProcessGuessWithRealFlow(t *testing.T) {
 	rm := store.NewRoomManager()
-	room := rm.CreateRoom("FLOW1")
+	room, _ := rm.CreateRoom("FLOW1")
 
 	// Add 4 players
 	playerNames := []string{"Alice", "Bob", "Charlie", "David"}
 	for _, name := range playerNames {
 		player := store.Player{
-			ID:    name + "-id",
-			Name:  name,
-			Role:  "",
-			Score: 0,
+			Identity: store.Identity{ID: name + "-id", Name: name},
 		}
 		room.AddPlayer(player)
 	}
@@ -560,32 +545,42 @@ func TestProcessGuessWithRealFlow(t *testing.T) {
 
 // TestProcessGuessScoreTotals tests score totals for both scenarios
 func TestProcessGuessScoreTotals(t *testing.T) {
+	policy, err := LookupScoringPolicy(DefaultScoringPolicy)
+	if err != nil {
+		t.Fatalf("Failed to look up default policy: %v", err)
+	}
+	table, _ := RoleTableFor(4)
+	conservedTotal, ok := policy.ConservedTotal(table.Roles)
+	if !ok {
+		t.Fatal("Default policy should declare a conserved total")
+	}
+
 	scenarios := []struct {
 		name          string
 		correctGuess  bool
 		expectedTotal int
 	}{
-		{"Correct Guess", true, 2300}, // 1000 + 800 + 500 + 0
-		{"Wrong Guess", false, 2300},  // 1000 + 0 + 500 + 800
+		{"Correct Guess", true, conservedTotal}, // 1000 + 800 + 500 + 0
+		{"Wrong Guess", false, conservedTotal},  // 1000 + 0 + 500 + 800
 	}
 
 	for _, scenario := range scenarios {
 		t.Run(scenario.name, func(t *testing.T) {
 			rm := store.NewRoomManager()
-			room := rm.CreateRoom("TOTAL-" + scenario.name)
+			room, _ := rm.CreateRoom("TOTAL-" + scenario.name)
 
 			players := []store.Player{
-				{ID: "raja", Name: "Raja", Role: "Raja", Score: 0},
-				{ID: "mantri", Name: "Mantri", Role: "Mantri", Score: 0},
-				{ID: "chor", Name: "Chor", Role: "Chor", Score: 0},
-				{ID: "sipahi", Name: "Sipahi", Role: "Sipahi", Score: 0},
+				{Identity: store.Identity{ID: "raja", Name: "Raja"}, Role: "Raja"},
+				{Identity: store.Identity{ID: "mantri", Name: "Mantri"}, Role: "Mantri"},
+				{Identity: store.Identity{ID: "chor", Name: "Chor"}, Role: "Chor"},
+				{Identity: store.Identity{ID: "sipahi", Name: "Sipahi"}, Role: "Sipahi"},
 			}
 
 			for _, player := range players {
 				room.AddPlayer(player)
 			}
 
-			room.UpdatePlayersAndStatus(players, "GUESSING")
+			dealPlayers(t, room, players)
 
 			// Make guess based on scenario
 			var guessID string
@@ -0,0 +1,145 @@
+package game
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const DefaultScoringPolicy = "classic"
+
+// RoundOutcome describes how a round played out, for scoring purposes
+type RoundOutcome struct {
+	Roles     map[string]string // player ID -> role
+	MantriID  string
+	ChorID    string
+	GuessedID string
+	Correct   bool
+}
+
+// ScoringPolicy turns the outcome of a round into per-player score deltas
+type ScoringPolicy interface {
+	Name() string
+	Score(outcome RoundOutcome) map[string]int
+	// ConservedTotal reports the sum of deltas every round played with the
+	// given roles adds up to, regardless of the outcome. ok is false if the
+	// policy makes no such promise.
+	ConservedTotal(roles []string) (total int, ok bool)
+}
+
+// TablePolicy scores each role from a fixed table depending on whether the
+// Mantri caught the Chor. Roles missing from a table score 0. A conserving
+// table must give the same sum for both outcomes over any role table.
+type TablePolicy struct {
+	PolicyName string
+	OnCorrect  map[string]int
+	OnWrong    map[string]int
+	Conserves  bool
+}
+
+func (p *TablePolicy) Name() string {
+	return p.PolicyName
+}
+
+func (p *TablePolicy) Score(outcome RoundOutcome) map[string]int {
+	table := p.OnWrong
+	if outcome.Correct {
+		table = p.OnCorrect
+	}
+
+	deltas := make(map[string]int, len(outcome.Roles))
+	for playerID, role := range outcome.Roles {
+		deltas[playerID] = table[role]
+	}
+	return deltas
+}
+
+func (p *TablePolicy) ConservedTotal(roles []string) (int, bool) {
+	if !p.Conserves {
+		return 0, false
+	}
+
+	total := 0
+	for _, role := range roles {
+		total += p.OnCorrect[role]
+	}
+	return total, true
+}
+
+var (
+	policies   = make(map[string]ScoringPolicy)
+	policiesMu sync.RWMutex
+)
+
+func init() {
+	// The traditional table: the Chor steals the Mantri's 800 on a wrong guess.
+	// Rani, Senapati, Sainik and Daku only appear in the larger role tables.
+	RegisterScoringPolicy(&TablePolicy{
+		PolicyName: DefaultScoringPolicy,
+		OnCorrect:  map[string]int{"Raja": 1000, "Rani": 900, "Mantri": 800, "Senapati": 600, "Sipahi": 500, "Sainik": 400, "Daku": 300, "Chor": 0},
+		OnWrong:    map[string]int{"Raja": 1000, "Rani": 900, "Mantri": 0, "Senapati": 600, "Sipahi": 500, "Sainik": 400, "Daku": 300, "Chor": 800},
+		Conserves:  true,
+	})
+
+	// The Sipahi gets a 200 bonus for helping catch the Chor
+	RegisterScoringPolicy(&TablePolicy{
+		PolicyName: "sipahi-bonus",
+		OnCorrect:  map[string]int{"Raja": 1000, "Rani": 900, "Mantri": 800, "Senapati": 600, "Sipahi": 700, "Sainik": 400, "Daku": 300, "Chor": 0},
+		OnWrong:    map[string]int{"Raja": 1000, "Rani": 900, "Mantri": 0, "Senapati": 600, "Sipahi": 500, "Sainik": 400, "Daku": 300, "Chor": 800},
+	})
+
+	// A wrong guess splits the Mantri's points with the Chor
+	RegisterScoringPolicy(&TablePolicy{
+		PolicyName: "partial-credit",
+		OnCorrect:  map[string]int{"Raja": 1000, "Rani": 900, "Mantri": 800, "Senapati": 600, "Sipahi": 500, "Sainik": 400, "Daku": 300, "Chor": 0},
+		OnWrong:    map[string]int{"Raja": 1000, "Rani": 900, "Mantri": 400, "Senapati": 600, "Sipahi": 500, "Sainik": 400, "Daku": 300, "Chor": 400},
+		Conserves:  true,
+	})
+
+	// A wrong guess costs the Mantri points, and a caught Chor loses points
+	RegisterScoringPolicy(&TablePolicy{
+		PolicyName: "penalty",
+		OnCorrect:  map[string]int{"Raja": 1000, "Rani": 900, "Mantri": 800, "Senapati": 600, "Sipahi": 500, "Sainik": 400, "Daku": 300, "Chor": -300},
+		OnWrong:    map[string]int{"Raja": 1000, "Rani": 900, "Mantri": -300, "Senapati": 600, "Sipahi": 500, "Sainik": 400, "Daku": 300, "Chor": 800},
+		Conserves:  true,
+	})
+}
+
+// RegisterScoringPolicy makes a policy selectable by name, replacing any
+// policy already registered under the same name
+func RegisterScoringPolicy(policy ScoringPolicy) {
+	policiesMu.Lock()
+	defer policiesMu.Unlock()
+
+	policies[policy.Name()] = policy
+}
+
+// LookupScoringPolicy returns the policy registered under name. An empty
+// name selects the default policy.
+func LookupScoringPolicy(name string) (ScoringPolicy, error) {
+	if name == "" {
+		name = DefaultScoringPolicy
+	}
+
+	policiesMu.RLock()
+	defer policiesMu.RUnlock()
+
+	policy, ok := policies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown scoring policy %q", name)
+	}
+	return policy, nil
+}
+
+// ScoringPolicyNames lists all registered policy names in sorted order
+func ScoringPolicyNames() []string {
+	policiesMu.RLock()
+	defer policiesMu.RUnlock()
+
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
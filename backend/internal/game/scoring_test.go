@@ -0,0 +1,170 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+)
+
+func classicOutcome(guessedID string) RoundOutcome {
+	return RoundOutcome{
+		Roles: map[string]string{
+			"raja":   "Raja",
+			"mantri": "Mantri",
+			"chor":   "Chor",
+			"sipahi": "Sipahi",
+		},
+		MantriID:  "mantri",
+		ChorID:    "chor",
+		GuessedID: guessedID,
+		Correct:   guessedID == "chor",
+	}
+}
+
+// outcomeForTable builds an outcome with one player per role, named after the role
+func outcomeForTable(table RoleTable, guessedRole string) RoundOutcome {
+	outcome := RoundOutcome{
+		Roles:     make(map[string]string, len(table.Roles)),
+		MantriID:  "Mantri",
+		ChorID:    "Chor",
+		GuessedID: guessedRole,
+		Correct:   guessedRole == "Chor",
+	}
+	for _, role := range table.Roles {
+		outcome.Roles[role] = role
+	}
+	return outcome
+}
+
+// TestScoringPoliciesConservation checks every registered policy that declares
+// a conserved total against every role table and every possible guess
+func TestScoringPoliciesConservation(t *testing.T) {
+	for _, name := range ScoringPolicyNames() {
+		policy, err := LookupScoringPolicy(name)
+		if err != nil {
+			t.Fatalf("Failed to look up registered policy %s: %v", name, err)
+		}
+
+		t.Run(name, func(t *testing.T) {
+			for players := MinPlayers; players <= MaxPlayers; players++ {
+				table, err := RoleTableFor(players)
+				if err != nil {
+					t.Fatalf("Missing role table for %d players: %v", players, err)
+				}
+
+				expectedTotal, conserved := policy.ConservedTotal(table.Roles)
+				if !conserved {
+					t.Skipf("Policy %s does not declare a conserved total", name)
+				}
+
+				for _, guess := range table.Roles {
+					deltas := policy.Score(outcomeForTable(table, guess))
+
+					total := 0
+					for _, delta := range deltas {
+						total += delta
+					}
+					if total != expectedTotal {
+						t.Errorf("%d players, guess %s: expected total %d, got %d", players, guess, expectedTotal, total)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestScoringPolicyVariants is a table-driven test of the built-in variants
+func TestScoringPolicyVariants(t *testing.T) {
+	testCases := []struct {
+		Policy         string
+		Guess          string
+		ExpectedScores map[string]int
+	}{
+		{"classic", "chor", map[string]int{"raja": 1000, "mantri": 800, "sipahi": 500, "chor": 0}},
+		{"classic", "sipahi", map[string]int{"raja": 1000, "mantri": 0, "sipahi": 500, "chor": 800}},
+		{"sipahi-bonus", "chor", map[string]int{"raja": 1000, "mantri": 800, "sipahi": 700, "chor": 0}},
+		{"sipahi-bonus", "raja", map[string]int{"raja": 1000, "mantri": 0, "sipahi": 500, "chor": 800}},
+		{"partial-credit", "sipahi", map[string]int{"raja": 1000, "mantri": 400, "sipahi": 500, "chor": 400}},
+		{"penalty", "chor", map[string]int{"raja": 1000, "mantri": 800, "sipahi": 500, "chor": -300}},
+		{"penalty", "raja", map[string]int{"raja": 1000, "mantri": -300, "sipahi": 500, "chor": 800}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Policy+"/"+tc.Guess, func(t *testing.T) {
+			policy, err := LookupScoringPolicy(tc.Policy)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			deltas := policy.Score(classicOutcome(tc.Guess))
+			for id, expected := range tc.ExpectedScores {
+				if deltas[id] != expected {
+					t.Errorf("Player %s: expected %d, got %d", id, expected, deltas[id])
+				}
+			}
+		})
+	}
+}
+
+func TestLookupScoringPolicy(t *testing.T) {
+	policy, err := LookupScoringPolicy("")
+	if err != nil {
+		t.Fatalf("Unexpected error for default policy: %v", err)
+	}
+	if policy.Name() != DefaultScoringPolicy {
+		t.Errorf("Expected default policy %s, got %s", DefaultScoringPolicy, policy.Name())
+	}
+
+	if _, err := LookupScoringPolicy("no-such-policy"); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
+
+// TestProcessGuessUsesRoomPolicy tests that the room's selected policy drives scoring
+func TestProcessGuessUsesRoomPolicy(t *testing.T) {
+	rm := store.NewRoomManager()
+	room, _ := rm.CreateRoom("POLICY")
+	room.SetScoringPolicy("penalty")
+
+	players := []store.Player{
+		{Identity: store.Identity{ID: "raja", Name: "Raja"}, Role: "Raja"},
+		{Identity: store.Identity{ID: "mantri", Name: "Mantri"}, Role: "Mantri"},
+		{Identity: store.Identity{ID: "chor", Name: "Chor"}, Role: "Chor"},
+		{Identity: store.Identity{ID: "sipahi", Name: "Sipahi"}, Role: "Sipahi"},
+	}
+	dealPlayers(t, room, players)
+
+	result, err := ProcessGuess(room, "mantri", "sipahi")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if result.UpdatedScores["mantri"] != -300 {
+		t.Errorf("Expected Mantri to be penalised to -300, got %d", result.UpdatedScores["mantri"])
+	}
+	if result.UpdatedScores["chor"] != 800 {
+		t.Errorf("Expected Chor to get 800, got %d", result.UpdatedScores["chor"])
+	}
+}
+
+// TestProcessGuessUnknownPolicy tests that a misconfigured room fails without scoring
+func TestProcessGuessUnknownPolicy(t *testing.T) {
+	rm := store.NewRoomManager()
+	room, _ := rm.CreateRoom("BAD-POLICY")
+	room.SetScoringPolicy("no-such-policy")
+
+	players := []store.Player{
+		{Identity: store.Identity{ID: "raja", Name: "Raja"}, Role: "Raja"},
+		{Identity: store.Identity{ID: "mantri", Name: "Mantri"}, Role: "Mantri"},
+		{Identity: store.Identity{ID: "chor", Name: "Chor"}, Role: "Chor"},
+		{Identity: store.Identity{ID: "sipahi", Name: "Sipahi"}, Role: "Sipahi"},
+	}
+	dealPlayers(t, room, players)
+
+	if _, err := ProcessGuess(room, "mantri", "chor"); err == nil {
+		t.Fatal("Expected error for unknown scoring policy")
+	}
+	if room.GetStatus() != "GUESSING" {
+		t.Errorf("Expected status to remain GUESSING, got %s", room.GetStatus())
+	}
+}
@@ -0,0 +1,37 @@
+package game
+
+import "fmt"
+
+const (
+	MinPlayers = 3
+	MaxPlayers = 8
+)
+
+// RoleTable is the set of roles dealt out for a given number of players.
+// Every table contains a Mantri, who guesses, and a Chor, who is guessed.
+type RoleTable struct {
+	Players int
+	Roles   []string
+}
+
+var roleTables = map[int]RoleTable{
+	3: {Players: 3, Roles: []string{"Raja", "Mantri", "Chor"}},
+	4: {Players: 4, Roles: []string{"Raja", "Mantri", "Chor", "Sipahi"}},
+	5: {Players: 5, Roles: []string{"Raja", "Rani", "Mantri", "Chor", "Sipahi"}},
+	6: {Players: 6, Roles: []string{"Raja", "Rani", "Mantri", "Senapati", "Chor", "Sipahi"}},
+	7: {Players: 7, Roles: []string{"Raja", "Rani", "Mantri", "Senapati", "Chor", "Sipahi", "Daku"}},
+	8: {Players: 8, Roles: []string{"Raja", "Rani", "Mantri", "Senapati", "Chor", "Sipahi", "Daku", "Sainik"}},
+}
+
+// RoleTableFor returns the built-in role table for the given player count
+func RoleTableFor(players int) (RoleTable, error) {
+	table, ok := roleTables[players]
+	if !ok {
+		return RoleTable{}, fmt.Errorf("no role table for %d players (supported: %d to %d)", players, MinPlayers, MaxPlayers)
+	}
+
+	roles := make([]string, len(table.Roles))
+	copy(roles, table.Roles)
+	table.Roles = roles
+	return table, nil
+}
@@ -0,0 +1,130 @@
+package game
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+)
+
+func setupSizedRoom(t *testing.T, playerCount int, joined int) *store.Room {
+	t.Helper()
+
+	rm := store.NewRoomManager()
+	room, _ := rm.CreateRoom(fmt.Sprintf("SIZE-%d-%d", playerCount, joined))
+	// AddPlayer won't overfill a room, so the cap is only lowered once
+	// everyone is seated
+	room.SetPlayerCount(max(playerCount, joined))
+
+	for i := 0; i < joined; i++ {
+		room.AddPlayer(store.Player{
+			Identity: store.Identity{ID: fmt.Sprintf("player-%d", i), Name: fmt.Sprintf("Player %d", i)},
+		})
+	}
+	room.SetPlayerCount(playerCount)
+	return room
+}
+
+// TestRoleTables checks that every supported size has a well-formed role table
+func TestRoleTables(t *testing.T) {
+	for players := MinPlayers; players <= MaxPlayers; players++ {
+		table, err := RoleTableFor(players)
+		if err != nil {
+			t.Fatalf("%d players: unexpected error: %v", players, err)
+		}
+
+		if len(table.Roles) != players {
+			t.Errorf("%d players: table has %d roles", players, len(table.Roles))
+		}
+
+		seen := make(map[string]bool)
+		for _, role := range table.Roles {
+			if seen[role] {
+				t.Errorf("%d players: role %s appears twice", players, role)
+			}
+			seen[role] = true
+		}
+
+		if !seen["Mantri"] || !seen["Chor"] {
+			t.Errorf("%d players: table must contain a Mantri and a Chor", players)
+		}
+	}
+
+	for _, players := range []int{0, 2, 9} {
+		if _, err := RoleTableFor(players); err == nil {
+			t.Errorf("Expected error for %d players", players)
+		}
+	}
+}
+
+// TestAssignRolesAllSizes deals and scores a round for every supported size
+func TestAssignRolesAllSizes(t *testing.T) {
+	for players := MinPlayers; players <= MaxPlayers; players++ {
+		t.Run(fmt.Sprintf("%d players", players), func(t *testing.T) {
+			room := setupSizedRoom(t, players, players)
+
+			if err := AssignRoles(room); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			table, _ := RoleTableFor(players)
+			expected := make(map[string]bool)
+			for _, role := range table.Roles {
+				expected[role] = true
+			}
+
+			var mantriID, chorID string
+			for _, p := range room.GetPlayers() {
+				if !expected[p.Role] {
+					t.Errorf("Player %s got unexpected or duplicate role %q", p.ID, p.Role)
+				}
+				delete(expected, p.Role)
+
+				switch p.Role {
+				case "Mantri":
+					mantriID = p.ID
+				case "Chor":
+					chorID = p.ID
+				}
+			}
+
+			result, err := ProcessGuess(room, mantriID, chorID)
+			if err != nil {
+				t.Fatalf("Unexpected error processing guess: %v", err)
+			}
+			if !result.Correct {
+				t.Error("Expected correct guess")
+			}
+			if len(result.UpdatedScores) != players {
+				t.Errorf("Expected %d scores, got %d", players, len(result.UpdatedScores))
+			}
+		})
+	}
+}
+
+// TestAssignRolesCountMismatch tests that mismatched counts return an error
+// instead of silently doing nothing
+func TestAssignRolesCountMismatch(t *testing.T) {
+	testCases := []struct {
+		Name        string
+		PlayerCount int
+		Joined      int
+	}{
+		{"Too few for 6-player table", 6, 5},
+		{"Too many for 3-player table", 3, 4},
+		{"Unsupported table", 9, 9},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			room := setupSizedRoom(t, tc.PlayerCount, tc.Joined)
+
+			if err := AssignRoles(room); err == nil {
+				t.Fatal("Expected error, got nil")
+			}
+			if room.GetStatus() != "WAITING" {
+				t.Errorf("Expected status to remain WAITING, got %s", room.GetStatus())
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+)
+
+// Room admin actions. Each one checks that actorID is the room's host in the
+// same change it makes, so the HTTP handlers and the WebSocket commands
+// share the same permission checks and the host can't change in between.
+
+func (s *Server) kickPlayer(room *store.Room, actorID string, targetID string) error {
+	departure, err := room.KickPlayer(actorID, targetID)
+	if err != nil {
+		return err
+	}
+
+	s.announceDeparture(room, departure, true)
+	return nil
+}
+
+func (s *Server) transferHost(room *store.Room, actorID string, targetID string) error {
+	if err := room.TransferHost(actorID, targetID); err != nil {
+		return err
+	}
+
+	s.BroadcastHostChanged(room.ID, actorID, targetID)
+	return nil
+}
+
+func (s *Server) lockRoom(room *store.Room, actorID string, locked bool) error {
+	if err := room.LockAs(actorID, locked); err != nil {
+		return err
+	}
+
+	s.BroadcastRoomLocked(room.ID, locked)
+	return nil
+}
+
+func (s *Server) closeRoom(room *store.Room, actorID string) error {
+	closed, err := s.rooms.CloseRoom(room.ID, actorID)
+	if err != nil {
+		return err
+	}
+	if closed == nil {
+		// Someone else closed or deleted it first, and announced it
+		return store.ErrRoomNotFound
+	}
+
+	s.releaseRoom(room.ID)
+	s.BroadcastRoomClosed(room.ID, "closed by host")
+	s.hub.DisconnectRoom(room.ID, CloseRoomClosed, "room closed")
+	return nil
+}
+
+type KickPlayerRequest struct {
+	RoomID   string `json:"roomId"`
+	PlayerID string `json:"playerId"`
+}
+
+type TransferHostRequest struct {
+	RoomID   string `json:"roomId"`
+	PlayerID string `json:"playerId"`
+}
+
+type LockRoomRequest struct {
+	RoomID string `json:"roomId"`
+	Locked *bool  `json:"locked"`
+}
+
+type CloseRoomRequest struct {
+	RoomID string `json:"roomId"`
+}
+
+func writeAdminOK(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+func (s *Server) KickPlayer(w http.ResponseWriter, r *http.Request) {
+	var req KickPlayerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	if req.PlayerID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "roomId and playerId are required"})
+		return
+	}
+
+	room, session, ok := s.sessionRoom(w, r, req.RoomID)
+	if !ok {
+		return
+	}
+
+	if err := s.kickPlayer(room, session.PlayerID, req.PlayerID); err != nil {
+		writeGameError(w, err)
+		return
+	}
+
+	writeAdminOK(w, "Player kicked")
+}
+
+func (s *Server) TransferHost(w http.ResponseWriter, r *http.Request) {
+	var req TransferHostRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	if req.PlayerID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "roomId and playerId are required"})
+		return
+	}
+
+	room, session, ok := s.sessionRoom(w, r, req.RoomID)
+	if !ok {
+		return
+	}
+
+	if err := s.transferHost(room, session.PlayerID, req.PlayerID); err != nil {
+		writeGameError(w, err)
+		return
+	}
+
+	writeAdminOK(w, "Host transferred")
+}
+
+func (s *Server) LockRoom(w http.ResponseWriter, r *http.Request) {
+	var req LockRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	// Locking is the default so that {"roomId": "..."} alone locks the room
+	locked := true
+	if req.Locked != nil {
+		locked = *req.Locked
+	}
+
+	room, session, ok := s.sessionRoom(w, r, req.RoomID)
+	if !ok {
+		return
+	}
+
+	if err := s.lockRoom(room, session.PlayerID, locked); err != nil {
+		writeGameError(w, err)
+		return
+	}
+
+	if locked {
+		writeAdminOK(w, "Room locked")
+	} else {
+		writeAdminOK(w, "Room unlocked")
+	}
+}
+
+func (s *Server) CloseRoom(w http.ResponseWriter, r *http.Request) {
+	var req CloseRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	room, session, ok := s.sessionRoom(w, r, req.RoomID)
+	if !ok {
+		return
+	}
+
+	if err := s.closeRoom(room, session.PlayerID); err != nil {
+		writeGameError(w, err)
+		return
+	}
+
+	writeAdminOK(w, "Room closed")
+}
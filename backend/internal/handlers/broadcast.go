@@ -1,106 +1,250 @@
 package handlers
 
 import (
-	"encoding/json"
 	"log"
+	"time"
 
+	"github.com/bit2swaz/codechef-recruit/backend/internal/game"
 	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
 )
 
-type GameMessage struct {
-	Type    string                 `json:"type"`
-	Payload map[string]interface{} `json:"payload"`
-}
-
-// Broadcast sends a message to all connected clients in a room
-func Broadcast(roomID string, messageType string, payload map[string]interface{}) {
-	message := GameMessage{
-		Type:    messageType,
-		Payload: payload,
+// publishEvent numbers an event in the room's event log and sends it, to
+// the whole room or just playerID. Events for a room that no longer exists
+// are sent without a number.
+func (s *Server) publishEvent(roomID string, playerID string, payload EventPayload) error {
+	room := s.rooms.GetRoom(roomID)
+	if room == nil {
+		event := store.Event{PlayerID: playerID}
+		if err := encodeEvent(&event, roomID, playerID, payload); err != nil {
+			return err
+		}
+		s.hub.PublishEvent(roomID, event)
+		return nil
 	}
 
-	messageJSON, err := json.Marshal(message)
-	if err != nil {
+	_, err := room.Events().Append(playerID,
+		func(event *store.Event) error {
+			return encodeEvent(event, roomID, playerID, payload)
+		},
+		func(event store.Event) {
+			s.hub.PublishEvent(roomID, event)
+		},
+	)
+	return err
+}
+
+// Broadcast sends an event to all connected clients in a room
+func (s *Server) Broadcast(roomID string, payload EventPayload) {
+	if err := s.publishEvent(roomID, "", payload); err != nil {
 		log.Printf("Error marshaling broadcast message: %v", err)
 		return
 	}
-
-	hub := GetHub()
-	hub.BroadcastToRoom(roomID, messageJSON)
-	log.Printf("Broadcast to room %s: type=%s, payload=%v", roomID, messageType, payload)
+	log.Printf("Broadcast to room %s: type=%s, payload=%+v", roomID, payload.EventType(), payload)
 }
 
-// SendToPlayer sends a message to a specific player in a room
-func SendToPlayer(roomID string, playerID string, messageType string, payload map[string]interface{}) {
-	message := GameMessage{
-		Type:    messageType,
-		Payload: payload,
-	}
-
-	messageJSON, err := json.Marshal(message)
-	if err != nil {
+// SendToPlayer sends an event to a specific player in a room
+func (s *Server) SendToPlayer(roomID string, playerID string, payload EventPayload) {
+	if err := s.publishEvent(roomID, playerID, payload); err != nil {
 		log.Printf("Error marshaling player message: %v", err)
 		return
 	}
+	log.Printf("Sent to player %s in room %s: type=%s", playerID, roomID, payload.EventType())
+}
 
-	hub := GetHub()
-
-	hub.mu.RLock()
-	clients := hub.rooms[roomID]
-	hub.mu.RUnlock()
+// PlayerView is what a player can see about their own seat, including their
+// private game role
+type PlayerView struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Permission string `json:"permission"`
+	Role       string `json:"role,omitempty"`
+	Score      int    `json:"score"`
+}
 
-	for client := range clients {
-		if client.PlayerID == playerID {
-			select {
-			case client.Send <- messageJSON:
-				log.Printf("Sent to player %s in room %s: type=%s", playerID, roomID, messageType)
-			default:
-				log.Printf("Failed to send to player %s (channel full)", playerID)
+// snapshotEvent builds the SNAPSHOT message for playerID: the public room
+// details plus their own seat and role, read from the store in one go, so a
+// client that reconnects mid-game can rebuild its view from it alone. Its
+// seq is the last room event the snapshot is known to include.
+func snapshotEvent(room *store.Room, playerID string) (store.Event, error) {
+	event := store.Event{Seq: room.Events().LastSeq(), PlayerID: playerID}
+	state := room.Snapshot()
+
+	payload := SnapshotPayload{Room: roomDetails(room.ID, state)}
+	for _, p := range state.Players {
+		if p.ID == playerID {
+			payload.You = &PlayerView{
+				ID:         p.ID,
+				Name:       p.Name,
+				Permission: string(p.Permission),
+				Role:       p.Role,
+				Score:      p.Score,
 			}
 			break
 		}
 	}
+
+	err := encodeEvent(&event, room.ID, playerID, payload)
+	return event, err
+}
+
+func (s *Server) BroadcastPlayerJoined(roomID string, playerName string, playerID string) {
+	s.Broadcast(roomID, PlayerJoinedPayload{
+		Name:     playerName,
+		PlayerID: playerID,
+	})
+}
+
+// BroadcastPlayerLeft tells the room a player gave up their seat, with the
+// roster left behind
+func (s *Server) BroadcastPlayerLeft(roomID string, departure store.Departure) {
+	s.Broadcast(roomID, departurePayload(departure))
+}
+
+// BroadcastPlayerKicked tells the room, including the kicked player, that a
+// player was removed by the host
+func (s *Server) BroadcastPlayerKicked(roomID string, departure store.Departure) {
+	s.Broadcast(roomID, PlayerKickedPayload(departurePayload(departure)))
+}
+
+func departurePayload(departure store.Departure) PlayerLeftPayload {
+	return PlayerLeftPayload{
+		Name:         departure.Player.Name,
+		PlayerID:     departure.Player.ID,
+		RoundAborted: departure.RoundAborted,
+		Players:      publicPlayers(departure.Players),
+	}
+}
+
+func (s *Server) BroadcastHostChanged(roomID string, previousHostID string, hostID string) {
+	s.Broadcast(roomID, HostChangedPayload{
+		PreviousHostID: previousHostID,
+		HostID:         hostID,
+	})
+}
+
+// BroadcastRoomLocked sends ROOM_LOCKED or ROOM_UNLOCKED
+func (s *Server) BroadcastRoomLocked(roomID string, locked bool) {
+	if locked {
+		s.Broadcast(roomID, RoomLockedPayload{Locked: true})
+	} else {
+		s.Broadcast(roomID, RoomUnlockedPayload{Locked: false})
+	}
+}
+
+func (s *Server) BroadcastRoomClosed(roomID string, reason string) {
+	s.Broadcast(roomID, RoomClosedPayload{Reason: reason})
+}
+
+// BroadcastRoomExpiring warns the room that it will be reaped at expiresAt
+func (s *Server) BroadcastRoomExpiring(roomID string, reason store.ExpiryReason, expiresAt time.Time) {
+	s.Broadcast(roomID, RoomExpiringPayload{
+		Reason:    string(reason),
+		ExpiresAt: expiresAt.UnixMilli(),
+	})
+}
+
+func (s *Server) BroadcastPlayerReady(roomID string, playerID string, ready bool) {
+	s.Broadcast(roomID, PlayerReadyPayload{
+		PlayerID: playerID,
+		Ready:    ready,
+	})
 }
 
-func BroadcastPlayerJoined(roomID string, playerName string, playerID string) {
-	Broadcast(roomID, "PLAYER_JOINED", map[string]interface{}{
-		"name":     playerName,
-		"playerId": playerID,
+// BroadcastChat relays a chat line. The sender is taken from the connection,
+// never from the client's message.
+func (s *Server) BroadcastChat(roomID string, player store.Player, message string) {
+	s.Broadcast(roomID, ChatPayload{
+		PlayerID: player.ID,
+		Name:     player.Name,
+		Message:  message,
 	})
 }
 
-func BroadcastGameStart(roomID string) {
-	Broadcast(roomID, "GAME_START", map[string]interface{}{
-		"message": "All players ready! Roles have been assigned.",
+// BroadcastPhaseChanged announces every room phase transition
+func (s *Server) BroadcastPhaseChanged(roomID string, from store.Phase, to store.Phase) {
+	s.Broadcast(roomID, PhaseChangedPayload{
+		From: string(from),
+		To:   string(to),
 	})
 }
 
-func SendRoleToPlayer(roomID string, player store.Player) {
-	SendToPlayer(roomID, player.ID, "YOUR_ROLE", map[string]interface{}{
-		"role": player.Role,
-		"name": player.Name,
+// BroadcastPresenceChanged announces a seated player's new presence
+func (s *Server) BroadcastPresenceChanged(roomID string, playerID string, status store.PresenceStatus, at time.Time) {
+	s.Broadcast(roomID, PresenceChangedPayload{
+		PlayerID: playerID,
+		Presence: string(status),
+		LastSeen: at.UnixMilli(),
 	})
 }
 
-func BroadcastRolesAssigned(roomID string, players []store.Player) {
-	BroadcastGameStart(roomID)
+// BroadcastDisconnectResolved tells the room what its disconnect policy did
+// to a round whose player didn't come back in time
+func (s *Server) BroadcastDisconnectResolved(roomID string, playerID string, outcome *game.DisconnectOutcome) {
+	s.Broadcast(roomID, DisconnectResolvedPayload{
+		PlayerID:     playerID,
+		Policy:       string(outcome.Policy),
+		RoundAborted: outcome.RoundAborted,
+	})
+}
+
+func (s *Server) BroadcastGameStart(roomID string) {
+	s.Broadcast(roomID, GameStartPayload{
+		Message: "All players ready! Roles have been assigned.",
+	})
+}
+
+func (s *Server) SendRoleToPlayer(roomID string, player store.Player) {
+	s.SendToPlayer(roomID, player.ID, YourRolePayload{
+		Role: player.Role,
+		Name: player.Name,
+	})
+}
+
+func (s *Server) BroadcastRolesAssigned(roomID string, players []store.Player) {
+	s.BroadcastGameStart(roomID)
 
 	for _, player := range players {
-		SendRoleToPlayer(roomID, player)
+		s.SendRoleToPlayer(roomID, player)
 	}
 }
 
-func BroadcastGuessResult(roomID string, mantriName string, correct bool, scores map[string]int) {
-	Broadcast(roomID, "GUESS_RESULT", map[string]interface{}{
-		"mantri":  mantriName,
-		"correct": correct,
-		"scores":  scores,
+func (s *Server) BroadcastGuessResult(roomID string, mantriName string, result *game.GuessResult) {
+	s.Broadcast(roomID, GuessResultPayload{
+		Mantri:      mantriName,
+		Correct:     result.Correct,
+		Round:       result.Round,
+		TotalRounds: result.TotalRounds,
+		RoundScores: result.RoundScores,
+		Scores:      result.UpdatedScores,
+	})
+}
+
+// BroadcastRoundEnd announces the running standings between rounds of a match
+func (s *Server) BroadcastRoundEnd(roomID string, round int, totalRounds int, standings []game.Standing) {
+	s.Broadcast(roomID, RoundEndPayload{
+		Round:       round,
+		TotalRounds: totalRounds,
+		Standings:   standings,
 	})
 }
 
-func BroadcastGameEnd(roomID string, finalScores map[string]interface{}) {
-	Broadcast(roomID, "GAME_END", map[string]interface{}{
-		"message": "Game finished!",
-		"scores":  finalScores,
+// BroadcastNextRound announces a new round and privately sends every player
+// their freshly shuffled role
+func (s *Server) BroadcastNextRound(roomID string, round int, totalRounds int, players []store.Player) {
+	s.Broadcast(roomID, NextRoundPayload{
+		Round:       round,
+		TotalRounds: totalRounds,
+	})
+
+	for _, player := range players {
+		s.SendRoleToPlayer(roomID, player)
+	}
+}
+
+func (s *Server) BroadcastGameEnd(roomID string, finalScores map[string]int, standings []game.Standing) {
+	s.Broadcast(roomID, GameEndPayload{
+		Message:   "Game finished!",
+		Scores:    finalScores,
+		Standings: standings,
 	})
 }
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/broker"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/roomcode"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// DefaultClaimRefresh is how often an instance renews its claims on the
+	// rooms it holds
+	DefaultClaimRefresh = 20 * time.Second
+
+	// routedHeader marks a request another instance sent on to this one,
+	// which this one serves itself rather than send on again
+	routedHeader = "X-Routed-By"
+
+	// maxRoutedBody is how much of a request body is read for its roomId
+	maxRoutedBody = 64 << 10
+)
+
+// JoinCluster makes the server one of several instances that record who
+// owns each room in dir, and that reach this one at addr, e.g.
+// http://10.0.0.5:8080. It claims the rooms the server holds and every room
+// it creates from then on, so room codes stay unique across the instances,
+// and RouteToOwner sends requests for rooms another instance owns on to it.
+// Claims are renewed every refresh, which must be well within the
+// directory's claim TTL, until stop is called. It must be called before the
+// server starts taking requests.
+func (s *Server) JoinCluster(dir broker.Directory, addr string, refresh time.Duration) (stop func(), err error) {
+	if u, err := url.Parse(addr); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid instance address %q", addr)
+	}
+	s.directory, s.addr = dir, addr
+	s.renewClaims()
+
+	ticker := time.NewTicker(refresh)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				s.renewClaims()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}, nil
+}
+
+// renewClaims claims every room the server holds, renewing the claims it
+// already has
+func (s *Server) renewClaims() {
+	s.rooms.Range(func(room *store.Room) bool {
+		owner, err := s.directory.Claim(context.Background(), room.ID, s.addr)
+		if err != nil {
+			log.Printf("Error claiming room %s: %v", room.ID, err)
+		} else if owner != s.addr {
+			log.Printf("Room %s is held here but owned by %s, which gets its requests", room.ID, owner)
+		}
+		return true
+	})
+}
+
+// claimRoom claims a room the server has just created. It fails with
+// roomcode.ErrTaken if another instance owns a room with its code.
+func (s *Server) claimRoom(roomID string) error {
+	if s.directory == nil {
+		return nil
+	}
+
+	owner, err := s.directory.Claim(context.Background(), roomID, s.addr)
+	if err != nil {
+		return err
+	}
+	if owner != s.addr {
+		return roomcode.ErrTaken
+	}
+	return nil
+}
+
+// releaseRoom gives up the server's claim on a room it has deleted
+func (s *Server) releaseRoom(roomID string) {
+	if s.directory == nil {
+		return
+	}
+
+	if err := s.directory.Release(context.Background(), roomID, s.addr); err != nil {
+		log.Printf("Error releasing room %s: %v", roomID, err)
+	}
+}
+
+// RouteToOwner is middleware that sends a request for a room another
+// instance owns on to that instance, so a room's players and connections
+// all end up on the instance holding it, whichever one they reach. The room
+// is the roomId path variable, or the roomId in a JSON request body. Other
+// requests are served here, as are all of them before JoinCluster.
+func (s *Server) RouteToOwner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.directory == nil || r.Header.Get(routedHeader) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		roomID := requestRoomID(r)
+		if roomID == "" || s.rooms.GetRoom(roomID) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		owner, err := s.directory.Owner(r.Context(), roomID)
+		if err != nil {
+			log.Printf("Error looking up the owner of room %s: %v", roomID, err)
+		}
+		if owner == "" || owner == s.addr {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		proxy, err := s.proxyTo(owner)
+		if err != nil {
+			log.Printf("Error routing to the owner of room %s: %v", roomID, err)
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "Room owner is unreachable"})
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// requestRoomID returns the room a request is for, or "" if it names none.
+// A body read for its roomId is put back for the handler.
+func requestRoomID(r *http.Request) string {
+	if roomID := mux.Vars(r)["roomId"]; roomID != "" {
+		return roomID
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return ""
+	}
+
+	head, err := io.ReadAll(io.LimitReader(r.Body, maxRoutedBody))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+	if err != nil {
+		return ""
+	}
+
+	var req struct {
+		RoomID string `json:"roomId"`
+	}
+	json.Unmarshal(head, &req)
+	return req.RoomID
+}
+
+// proxyTo returns the proxy that sends requests on to owner. Responses are
+// flushed as they are written, for SSE streams, and WebSocket upgrades are
+// passed through.
+func (s *Server) proxyTo(owner string) (*httputil.ReverseProxy, error) {
+	if proxy, ok := s.proxies.Load(owner); ok {
+		return proxy.(*httputil.ReverseProxy), nil
+	}
+
+	target, err := url.Parse(owner)
+	if err != nil {
+		return nil, err
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			pr.Out.Header.Set(routedHeader, s.addr)
+		},
+		FlushInterval: -1,
+	}
+	actual, _ := s.proxies.LoadOrStore(owner, proxy)
+	return actual.(*httputil.ReverseProxy), nil
+}
@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+)
+
+const maxChatLength = 280
+
+// Error codes sent in the data of an "error" reply to a rejected command
+const (
+	CodeMalformed      = "malformed"
+	CodeUnknownCommand = "unknown_command"
+	CodeServerOnly     = "server_only"
+	CodeInvalidPayload = "invalid_payload"
+	CodeBadRequest     = "bad_request"
+	CodeForbidden      = "forbidden"
+	CodeNotFound       = "not_found"
+	CodeConflict       = "conflict"
+	CodeInternal       = "internal"
+)
+
+// serverOnlyTypes are the event types only the server may send, under
+// both their current and legacy names. A client sending one is refused
+// instead of having it relayed to the room.
+var serverOnlyTypes = make(map[string]bool)
+
+func init() {
+	for _, payload := range EventPayloads() {
+		serverOnlyTypes[payload.EventType()] = true
+	}
+	for _, legacyType := range legacyConnectionTypes {
+		serverOnlyTypes[legacyType] = true
+	}
+}
+
+// inboundCommand is a message sent by a client. Only type and data are
+// read; any playerId or roomId the client sends is ignored in favour of the
+// connection's own.
+type inboundCommand struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// commandPayload is the validated data of a command
+type commandPayload interface {
+	validate() error
+}
+
+type GuessCommand struct {
+	GuessedChorPlayerID string `json:"guessedChorPlayerId"`
+}
+
+func (c *GuessCommand) validate() error {
+	if c.GuessedChorPlayerID == "" {
+		return errors.New("guessedChorPlayerId is required")
+	}
+	return nil
+}
+
+type ChatCommand struct {
+	Message string `json:"message"`
+}
+
+func (c *ChatCommand) validate() error {
+	c.Message = strings.TrimSpace(c.Message)
+	if c.Message == "" {
+		return errors.New("message is required")
+	}
+	if len(c.Message) > maxChatLength {
+		return fmt.Errorf("message must be at most %d bytes", maxChatLength)
+	}
+	return nil
+}
+
+type ReadyCommand struct {
+	Ready *bool `json:"ready"`
+}
+
+func (c *ReadyCommand) validate() error {
+	if c.Ready == nil {
+		return errors.New("ready is required")
+	}
+	return nil
+}
+
+// PresenceCommand tells the server whether the player is looking at this
+// connection, for example from the page's visibility
+type PresenceCommand struct {
+	Status store.PresenceStatus `json:"status"`
+}
+
+func (c *PresenceCommand) validate() error {
+	if c.Status != store.PresenceOnline && c.Status != store.PresenceAway {
+		return fmt.Errorf("status must be %s or %s", store.PresenceOnline, store.PresenceAway)
+	}
+	return nil
+}
+
+// TargetCommand is the payload of commands aimed at another player
+type TargetCommand struct {
+	PlayerID string `json:"playerId"`
+}
+
+func (c *TargetCommand) validate() error {
+	if c.PlayerID == "" {
+		return errors.New("playerId is required")
+	}
+	return nil
+}
+
+// commandSpec describes one inbound command. payload returns a fresh value
+// to decode the command's data into, or is nil for commands without data.
+type commandSpec struct {
+	payload func() commandPayload
+	run     func(s *Server, c *Client, room *store.Room, payload commandPayload) error
+}
+
+var commands = map[string]commandSpec{
+	"start_game": {
+		run: func(s *Server, c *Client, room *store.Room, _ commandPayload) error {
+			return s.startGame(room, c.PlayerID)
+		},
+	},
+	"guess": {
+		payload: func() commandPayload { return &GuessCommand{} },
+		run: func(s *Server, c *Client, room *store.Room, payload commandPayload) error {
+			_, err := s.submitGuess(room, c.PlayerID, payload.(*GuessCommand).GuessedChorPlayerID)
+			return err
+		},
+	},
+	"next_round": {
+		run: func(s *Server, c *Client, room *store.Room, _ commandPayload) error {
+			_, err := s.nextRound(room, c.PlayerID)
+			return err
+		},
+	},
+	"chat": {
+		payload: func() commandPayload { return &ChatCommand{} },
+		run: func(s *Server, c *Client, room *store.Room, payload commandPayload) error {
+			for _, p := range room.GetPlayers() {
+				if p.ID == c.PlayerID {
+					s.BroadcastChat(room.ID, p, payload.(*ChatCommand).Message)
+					return nil
+				}
+			}
+			return store.ErrPlayerNotFound
+		},
+	},
+	"ready": {
+		payload: func() commandPayload { return &ReadyCommand{} },
+		run: func(s *Server, c *Client, room *store.Room, payload commandPayload) error {
+			ready := *payload.(*ReadyCommand).Ready
+			if err := room.SetReady(c.PlayerID, ready); err != nil {
+				return err
+			}
+			s.BroadcastPlayerReady(room.ID, c.PlayerID, ready)
+			return nil
+		},
+	},
+	"presence": {
+		payload: func() commandPayload { return &PresenceCommand{} },
+		run: func(s *Server, c *Client, room *store.Room, payload commandPayload) error {
+			s.hub.SetAway(c, payload.(*PresenceCommand).Status == store.PresenceAway)
+			return nil
+		},
+	},
+	"leave_room": {
+		run: func(s *Server, c *Client, room *store.Room, _ commandPayload) error {
+			return s.removePlayer(room, c.PlayerID)
+		},
+	},
+	"kick_player": {
+		payload: func() commandPayload { return &TargetCommand{} },
+		run: func(s *Server, c *Client, room *store.Room, payload commandPayload) error {
+			return s.kickPlayer(room, c.PlayerID, payload.(*TargetCommand).PlayerID)
+		},
+	},
+	"transfer_host": {
+		payload: func() commandPayload { return &TargetCommand{} },
+		run: func(s *Server, c *Client, room *store.Room, payload commandPayload) error {
+			return s.transferHost(room, c.PlayerID, payload.(*TargetCommand).PlayerID)
+		},
+	},
+	"lock_room": {
+		run: func(s *Server, c *Client, room *store.Room, _ commandPayload) error {
+			return s.lockRoom(room, c.PlayerID, true)
+		},
+	},
+	"unlock_room": {
+		run: func(s *Server, c *Client, room *store.Room, _ commandPayload) error {
+			return s.lockRoom(room, c.PlayerID, false)
+		},
+	},
+	"close_room": {
+		run: func(s *Server, c *Client, room *store.Room, _ commandPayload) error {
+			return s.closeRoom(room, c.PlayerID)
+		},
+	},
+}
+
+// CommandPayloads returns every command a client can send with a zero value
+// of its payload, or nil for commands that take none
+func CommandPayloads() map[string]interface{} {
+	payloads := make(map[string]interface{}, len(commands))
+	for commandType, spec := range commands {
+		if spec.payload != nil {
+			payloads[commandType] = spec.payload()
+		} else {
+			payloads[commandType] = nil
+		}
+	}
+	return payloads
+}
+
+// decodePayload strictly decodes a command's data: unknown fields and
+// trailing data are rejected, then the payload validates itself
+func decodePayload(data json.RawMessage, payload commandPayload) error {
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		data = []byte("{}")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(payload); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return errors.New("unexpected data after payload")
+	}
+	return payload.validate()
+}
+
+// errorCode maps a game error to the code sent back to the client
+func errorCode(err error) string {
+	switch errorStatus(err) {
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusInternalServerError:
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
+
+// dispatchCommand validates one inbound message and runs it. Anything that
+// is refused is answered to the sender with an "error" message carrying the
+// command, a machine-readable code and a description.
+func (s *Server) dispatchCommand(c *Client, message []byte) {
+	var cmd inboundCommand
+	if err := json.Unmarshal(message, &cmd); err != nil || cmd.Type == "" {
+		c.replyError("", CodeMalformed, "message must be a JSON object with a type")
+		return
+	}
+
+	spec, ok := commands[cmd.Type]
+	if !ok {
+		if serverOnlyTypes[cmd.Type] {
+			c.replyError(cmd.Type, CodeServerOnly, cmd.Type+" can only be sent by the server")
+		} else {
+			c.replyError(cmd.Type, CodeUnknownCommand, "unknown command "+cmd.Type)
+		}
+		return
+	}
+
+	var payload commandPayload
+	if spec.payload != nil {
+		payload = spec.payload()
+		if err := decodePayload(cmd.Data, payload); err != nil {
+			c.replyError(cmd.Type, CodeInvalidPayload, err.Error())
+			return
+		}
+	}
+
+	room := s.rooms.GetRoom(c.RoomID)
+	if room == nil {
+		c.replyError(cmd.Type, CodeNotFound, "Room not found")
+		return
+	}
+
+	if err := spec.run(s, c, room, payload); err != nil {
+		c.replyError(cmd.Type, errorCode(err), err.Error())
+		return
+	}
+	room.Touch(time.Now())
+
+	log.Printf("Command from %s in room %s: %s", c.PlayerID, c.RoomID, cmd.Type)
+}
+
+func (c *Client) replyError(command string, code string, message string) {
+	c.reply(ErrorPayload{
+		Command: command,
+		Code:    code,
+		Error:   message,
+	})
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/game"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+)
+
+const (
+	// ProtocolVersion is the version of the Envelope the server sends
+	ProtocolVersion = 1
+
+	// SubprotocolV1 is the WebSocket subprotocol a client offers to receive
+	// Envelopes. Clients that offer none get the legacy format, which is
+	// deprecated and will be removed at LegacySunset.
+	SubprotocolV1 = "rmcs.v1"
+)
+
+// LegacySunset is when the legacy format is removed and clients that don't
+// offer SubprotocolV1 are refused. Legacy connections are told the date in
+// a Sunset header until then.
+var LegacySunset = time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC)
+
+// Envelope wraps every message the server sends over WebSocket. Type
+// decides the shape of Payload; see EventPayloads for all of them.
+type Envelope struct {
+	Version int    `json:"v"`
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	// Seq numbers room events in order. It is left out of events that
+	// belong to one connection, such as CONNECTED and ERROR.
+	Seq    uint64 `json:"seq,omitempty"`
+	RoomID string `json:"roomId"`
+	// Timestamp is in Unix milliseconds
+	Timestamp int64        `json:"timestamp"`
+	Payload   EventPayload `json:"payload"`
+}
+
+// EventPayload is the payload of one type of server event
+type EventPayload interface {
+	EventType() string
+}
+
+type ConnectedPayload struct {
+	Message string `json:"message"`
+}
+
+// PlayerConnectedPayload is sent when a player's first WebSocket connection
+// opens, and PlayerDisconnectedPayload when their last one closes. It is
+// about connections, not seats: see PLAYER_JOINED.
+type PlayerConnectedPayload struct {
+	PlayerID string `json:"playerId"`
+	// PlayerCount is how many players have a connection open
+	PlayerCount int `json:"playerCount"`
+}
+
+type PlayerDisconnectedPayload PlayerConnectedPayload
+
+// ErrorPayload answers a command that was refused
+type ErrorPayload struct {
+	Command string `json:"command"`
+	Code    string `json:"code"`
+	Error   string `json:"error"`
+}
+
+type PlayerJoinedPayload struct {
+	Name     string `json:"name"`
+	PlayerID string `json:"playerId"`
+}
+
+// PlayerLeftPayload describes a departure and the roster left behind
+type PlayerLeftPayload struct {
+	Name         string             `json:"name"`
+	PlayerID     string             `json:"playerId"`
+	RoundAborted bool               `json:"roundAborted"`
+	Players      []PlayerInfoPublic `json:"players"`
+}
+
+type PlayerKickedPayload PlayerLeftPayload
+
+type HostChangedPayload struct {
+	PreviousHostID string `json:"previousHostId"`
+	HostID         string `json:"hostId"`
+}
+
+type RoomLockedPayload struct {
+	Locked bool `json:"locked"`
+}
+
+type RoomUnlockedPayload RoomLockedPayload
+
+type RoomClosedPayload struct {
+	Reason string `json:"reason"`
+}
+
+// RoomExpiringPayload warns that a room will be closed for Reason ("idle"
+// or "finished") at ExpiresAt, in Unix milliseconds. Any activity in an
+// idle room pushes its expiry back.
+type RoomExpiringPayload struct {
+	Reason    string `json:"reason"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+type PlayerReadyPayload struct {
+	PlayerID string `json:"playerId"`
+	Ready    bool   `json:"ready"`
+}
+
+type ChatPayload struct {
+	PlayerID string `json:"playerId"`
+	Name     string `json:"name"`
+	Message  string `json:"message"`
+}
+
+type PhaseChangedPayload struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// PresenceChangedPayload is sent when a seated player comes online, goes
+// away or disconnects. LastSeen is in Unix milliseconds.
+type PresenceChangedPayload struct {
+	PlayerID string `json:"playerId"`
+	Presence string `json:"presence"`
+	LastSeen int64  `json:"lastSeen"`
+}
+
+// DisconnectResolvedPayload is sent when a round was left waiting on a
+// player whose grace period ran out, and the room's disconnect policy
+// settled it. A forfeited or bot-guessed round is followed by the usual
+// GUESS_RESULT.
+type DisconnectResolvedPayload struct {
+	PlayerID     string `json:"playerId"`
+	Policy       string `json:"policy"`
+	RoundAborted bool   `json:"roundAborted"`
+}
+
+type GameStartPayload struct {
+	Message string `json:"message"`
+}
+
+type YourRolePayload struct {
+	Role string `json:"role"`
+	Name string `json:"name"`
+}
+
+// GuessResultPayload reports a scored round. RoundScores and Scores are
+// keyed by player ID.
+type GuessResultPayload struct {
+	Mantri      string         `json:"mantri"`
+	Correct     bool           `json:"correct"`
+	Round       int            `json:"round"`
+	TotalRounds int            `json:"totalRounds"`
+	RoundScores map[string]int `json:"roundScores"`
+	Scores      map[string]int `json:"scores"`
+}
+
+type RoundEndPayload struct {
+	Round       int             `json:"round"`
+	TotalRounds int             `json:"totalRounds"`
+	Standings   []game.Standing `json:"standings"`
+}
+
+type NextRoundPayload struct {
+	Round       int `json:"round"`
+	TotalRounds int `json:"totalRounds"`
+}
+
+// GameEndPayload carries the final result. Scores is keyed by player name.
+type GameEndPayload struct {
+	Message   string          `json:"message"`
+	Scores    map[string]int  `json:"scores"`
+	Standings []game.Standing `json:"standings"`
+}
+
+// SnapshotPayload is the public room plus the recipient's own seat, which
+// is left out if they no longer have one
+type SnapshotPayload struct {
+	Room RoomDetailsResponse `json:"room"`
+	You  *PlayerView         `json:"you,omitempty"`
+}
+
+func (ConnectedPayload) EventType() string          { return "CONNECTED" }
+func (PlayerConnectedPayload) EventType() string    { return "PLAYER_CONNECTED" }
+func (PlayerDisconnectedPayload) EventType() string { return "PLAYER_DISCONNECTED" }
+func (ErrorPayload) EventType() string              { return "ERROR" }
+func (PlayerJoinedPayload) EventType() string       { return "PLAYER_JOINED" }
+func (PlayerLeftPayload) EventType() string         { return "PLAYER_LEFT" }
+func (PlayerKickedPayload) EventType() string       { return "PLAYER_KICKED" }
+func (HostChangedPayload) EventType() string        { return "HOST_CHANGED" }
+func (RoomLockedPayload) EventType() string         { return "ROOM_LOCKED" }
+func (RoomUnlockedPayload) EventType() string       { return "ROOM_UNLOCKED" }
+func (RoomClosedPayload) EventType() string         { return "ROOM_CLOSED" }
+func (RoomExpiringPayload) EventType() string       { return "ROOM_EXPIRING" }
+func (PlayerReadyPayload) EventType() string        { return "PLAYER_READY" }
+func (ChatPayload) EventType() string               { return "CHAT" }
+func (PhaseChangedPayload) EventType() string       { return "PHASE_CHANGED" }
+func (PresenceChangedPayload) EventType() string    { return "PRESENCE_CHANGED" }
+func (DisconnectResolvedPayload) EventType() string { return "DISCONNECT_RESOLVED" }
+func (GameStartPayload) EventType() string          { return "GAME_START" }
+func (YourRolePayload) EventType() string           { return "YOUR_ROLE" }
+func (GuessResultPayload) EventType() string        { return "GUESS_RESULT" }
+func (RoundEndPayload) EventType() string           { return "ROUND_END" }
+func (NextRoundPayload) EventType() string          { return "NEXT_ROUND" }
+func (GameEndPayload) EventType() string            { return "GAME_END" }
+func (SnapshotPayload) EventType() string           { return "SNAPSHOT" }
+
+// EventPayloads returns a zero value of every event payload the server sends
+func EventPayloads() []EventPayload {
+	return []EventPayload{
+		ConnectedPayload{},
+		PlayerConnectedPayload{},
+		PlayerDisconnectedPayload{},
+		ErrorPayload{},
+		PlayerJoinedPayload{},
+		PlayerLeftPayload{},
+		PlayerKickedPayload{},
+		HostChangedPayload{},
+		RoomLockedPayload{},
+		RoomUnlockedPayload{},
+		RoomClosedPayload{},
+		RoomExpiringPayload{},
+		PlayerReadyPayload{},
+		ChatPayload{},
+		PhaseChangedPayload{},
+		PresenceChangedPayload{},
+		DisconnectResolvedPayload{},
+		GameStartPayload{},
+		YourRolePayload{},
+		GuessResultPayload{},
+		RoundEndPayload{},
+		NextRoundPayload{},
+		GameEndPayload{},
+		SnapshotPayload{},
+	}
+}
+
+// legacyConnectionTypes maps the events the legacy protocol sent as a
+// WSMessage to their old lower-case names. Every other event was sent as a
+// GameMessage under its own type.
+var legacyConnectionTypes = map[string]string{
+	"CONNECTED":           "connected",
+	"PLAYER_CONNECTED":    "player_joined",
+	"PLAYER_DISCONNECTED": "player_left",
+	"ERROR":               "error",
+}
+
+// GameMessage is a room event in the legacy format
+//
+// Deprecated: clients should negotiate SubprotocolV1 and read Envelopes.
+// The legacy format is removed at LegacySunset.
+type GameMessage struct {
+	Type    string       `json:"type"`
+	Seq     uint64       `json:"seq,omitempty"`
+	Payload EventPayload `json:"payload"`
+}
+
+// WSMessage is a connection event in the legacy format
+//
+// Deprecated: clients should negotiate SubprotocolV1 and read Envelopes.
+// The legacy format is removed at LegacySunset.
+type WSMessage struct {
+	Type      string       `json:"type"`
+	PlayerID  string       `json:"playerId"`
+	RoomID    string       `json:"roomId"`
+	Data      EventPayload `json:"data"`
+	Timestamp int64        `json:"timestamp"`
+}
+
+// encodeEvent fills in event.Data with payload as an Envelope and
+// event.Legacy with it in the legacy format, using event.Seq. playerID is
+// the player a legacy connection event is about.
+func encodeEvent(event *store.Event, roomID string, playerID string, payload EventPayload) error {
+	now := time.Now()
+
+	data, err := json.Marshal(Envelope{
+		Version:   ProtocolVersion,
+		ID:        generateEventID(),
+		Type:      payload.EventType(),
+		Seq:       event.Seq,
+		RoomID:    roomID,
+		Timestamp: now.UnixMilli(),
+		Payload:   payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	var legacy interface{} = GameMessage{Type: payload.EventType(), Seq: event.Seq, Payload: payload}
+	if legacyType, ok := legacyConnectionTypes[payload.EventType()]; ok {
+		legacy = WSMessage{
+			Type:      legacyType,
+			PlayerID:  playerID,
+			RoomID:    roomID,
+			Data:      payload,
+			Timestamp: now.Unix(),
+		}
+	}
+	legacyData, err := json.Marshal(legacy)
+	if err != nil {
+		return err
+	}
+
+	event.Data = data
+	event.Legacy = legacyData
+	return nil
+}
+
+func generateEventID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Failed to generate event ID: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
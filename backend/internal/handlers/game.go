@@ -2,16 +2,101 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/bit2swaz/codechef-recruit/backend/internal/game"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
 )
 
+// errorStatus maps errors from the game layer to HTTP status codes. A room
+// in the wrong phase for the request is a 409 Conflict.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, store.ErrPhaseConflict):
+		return http.StatusConflict
+	case errors.Is(err, store.ErrNotHost), errors.Is(err, store.ErrRoomLocked):
+		return http.StatusForbidden
+	case errors.Is(err, store.ErrPlayerNotFound), errors.Is(err, store.ErrRoomNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, store.ErrRoomFull):
+		return http.StatusBadRequest
+	case errors.Is(err, store.ErrNotSaved):
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+func writeGameError(w http.ResponseWriter, err error) {
+	w.WriteHeader(errorStatus(err))
+	json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+}
+
+// Game actions shared by the HTTP handlers and the WebSocket commands.
+// actorID is the verified player making the request.
+
+func (s *Server) startGame(room *store.Room, actorID string) error {
+	dealt, err := game.StartGame(room, actorID)
+	if err != nil {
+		return err
+	}
+
+	s.BroadcastRolesAssigned(room.ID, dealt.Players)
+	return nil
+}
+
+func (s *Server) submitGuess(room *store.Room, mantriID string, guessedChorID string) (*game.GuessResult, error) {
+	result, err := game.ProcessGuess(room, mantriID, guessedChorID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.announceGuess(room, result)
+	return result, nil
+}
+
+// announceGuess broadcasts a scored round, followed by the standings or,
+// if it was the last round, the end of the match, all as the round was
+// scored
+func (s *Server) announceGuess(room *store.Room, result *game.GuessResult) {
+	players := result.Players
+	var mantriName string
+	for _, p := range players {
+		if p.ID == result.MantriID {
+			mantriName = p.Name
+			break
+		}
+	}
+	s.BroadcastGuessResult(room.ID, mantriName, result)
+
+	standings := game.Standings(players)
+	if result.MatchOver {
+		finalScores := make(map[string]int)
+		for _, player := range players {
+			finalScores[player.Name] = player.Score
+		}
+		s.BroadcastGameEnd(room.ID, finalScores, standings)
+	} else {
+		s.BroadcastRoundEnd(room.ID, result.Round, result.TotalRounds, standings)
+	}
+}
+
+// nextRound starts the next round and returns its number
+func (s *Server) nextRound(room *store.Room, actorID string) (int, error) {
+	dealt, err := game.NextRound(room, actorID)
+	if err != nil {
+		return 0, err
+	}
+
+	s.BroadcastNextRound(room.ID, dealt.Round, dealt.TotalRounds, dealt.Players)
+	return dealt.Round, nil
+}
+
 type StartGameRequest struct {
 	RoomID string `json:"roomId"`
 }
 
-func StartGame(w http.ResponseWriter, r *http.Request) {
+func (s *Server) StartGame(w http.ResponseWriter, r *http.Request) {
 	var req StartGameRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -25,24 +110,22 @@ func StartGame(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room := roomManager.GetRoom(req.RoomID)
+	room := s.rooms.GetRoom(req.RoomID)
 	if room == nil {
 		w.WriteHeader(http.StatusNotFound)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "Room not found"})
 		return
 	}
 
-	players := room.GetPlayers()
-	if len(players) != 4 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Need exactly 4 players to start game"})
+	session, ok := s.requireSession(w, r, room.ID)
+	if !ok {
 		return
 	}
 
-	game.AssignRoles(room)
-
-	players = room.GetPlayers()
-	BroadcastRolesAssigned(room.ID, players)
+	if err := s.startGame(room, session.PlayerID); err != nil {
+		writeGameError(w, err)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -55,7 +138,7 @@ type SubmitGuessRequest struct {
 	GuessedChorPlayerID string `json:"guessedChorPlayerId"`
 }
 
-func SubmitGuess(w http.ResponseWriter, r *http.Request) {
+func (s *Server) SubmitGuess(w http.ResponseWriter, r *http.Request) {
 	var req SubmitGuessRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -69,37 +152,73 @@ func SubmitGuess(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room := roomManager.GetRoom(req.RoomID)
+	room := s.rooms.GetRoom(req.RoomID)
 	if room == nil {
 		w.WriteHeader(http.StatusNotFound)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "Room not found"})
 		return
 	}
 
-	result, err := game.ProcessGuess(room, req.MantriPlayerID, req.GuessedChorPlayerID)
+	session, ok := s.requireSession(w, r, room.ID)
+	if !ok {
+		return
+	}
+	if session.PlayerID != req.MantriPlayerID {
+		writeSessionError(w, http.StatusForbidden, errors.New("session does not belong to mantriPlayerId"))
+		return
+	}
+
+	result, err := s.submitGuess(room, req.MantriPlayerID, req.GuessedChorPlayerID)
 	if err != nil {
+		writeGameError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+type NextRoundRequest struct {
+	RoomID string `json:"roomId"`
+}
+
+func (s *Server) NextRound(w http.ResponseWriter, r *http.Request) {
+	var req NextRoundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
 		return
 	}
 
-	players := room.GetPlayers()
-	var mantriName string
-	for _, p := range players {
-		if p.ID == req.MantriPlayerID {
-			mantriName = p.Name
-			break
-		}
+	if req.RoomID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "roomId is required"})
+		return
+	}
+
+	room := s.rooms.GetRoom(req.RoomID)
+	if room == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Room not found"})
+		return
 	}
-	BroadcastGuessResult(room.ID, mantriName, result.Correct, result.UpdatedScores)
 
-	finalScores := make(map[string]interface{})
-	for _, player := range players {
-		finalScores[player.Name] = player.Score
+	session, ok := s.requireSession(w, r, room.ID)
+	if !ok {
+		return
+	}
+
+	round, err := s.nextRound(room, session.PlayerID)
+	if err != nil {
+		writeGameError(w, err)
+		return
 	}
-	BroadcastGameEnd(room.ID, finalScores)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(result)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Next round started",
+		"round":   round,
+	})
 }
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Metrics is the server's counters: rooms and what the reaper has done to
+// them, and what the hub has had to shed
+type Metrics struct {
+	Rooms RoomStats `json:"rooms"`
+	Hub   HubStats  `json:"hub"`
+}
+
+// ServeMetrics writes the server's Metrics as JSON. It reports nothing
+// about the process itself, but is still meant for an internal listener
+// rather than the public router.
+func (s *Server) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Metrics{
+		Rooms: s.GetRoomStats(),
+		Hub:   s.hub.Stats(),
+	})
+}
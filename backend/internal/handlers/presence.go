@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/game"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+)
+
+// DefaultDisconnectGrace is how long a player's seat is held after their
+// last connection drops before the room's disconnect policy applies
+const DefaultDisconnectGrace = 30 * time.Second
+
+// seatKey identifies a seat held for a reconnecting player
+type seatKey struct {
+	roomID   string
+	playerID string
+}
+
+// seatHold is a running grace period. since is when the player's last
+// connection dropped.
+type seatHold struct {
+	timer *time.Timer
+	since time.Time
+}
+
+// roomSeats are the seats held in one room and the presence changes
+// waiting to be applied to it. One worker goroutine per room applies the
+// changes in the order they were seen, started when the first is queued and
+// gone once none are left. The hub's actors only queue changes, so they
+// never wait on a room's store writes or broadcasts, and no room waits on
+// another's.
+type roomSeats struct {
+	// holds are the seats held by player ID. Only the worker touches them.
+	holds map[string]*seatHold
+	// pending and running are guarded by Server.seatsMu
+	pending []func(rs *roomSeats)
+	running bool
+}
+
+// SetDisconnectGrace sets how long seats are held for reconnecting players.
+// Zero or less marks players disconnected straight away and never applies
+// a disconnect policy.
+func (s *Server) SetDisconnectGrace(grace time.Duration) {
+	s.disconnectGrace.Store(int64(grace))
+}
+
+// queueSeats queues fn to run on roomID's seat worker, starting the worker
+// if it isn't running. It never blocks on the room.
+func (s *Server) queueSeats(roomID string, fn func(rs *roomSeats)) {
+	s.seatsMu.Lock()
+	defer s.seatsMu.Unlock()
+
+	rs := s.seats[roomID]
+	if rs == nil {
+		rs = &roomSeats{holds: make(map[string]*seatHold)}
+		s.seats[roomID] = rs
+	}
+	rs.pending = append(rs.pending, fn)
+	if !rs.running {
+		rs.running = true
+		go s.runSeats(roomID, rs)
+	}
+}
+
+// runSeats is roomID's seat worker. It runs the queued changes until there
+// are none left, and drops the room's entry if no seats are held either.
+func (s *Server) runSeats(roomID string, rs *roomSeats) {
+	for {
+		s.seatsMu.Lock()
+		if len(rs.pending) == 0 {
+			rs.running = false
+			if len(rs.holds) == 0 {
+				delete(s.seats, roomID)
+			}
+			s.seatsMu.Unlock()
+			return
+		}
+		fn := rs.pending[0]
+		rs.pending = rs.pending[1:]
+		s.seatsMu.Unlock()
+
+		fn(rs)
+	}
+}
+
+// syncPresence queues a presence change seen by the hub for the room's seat
+// worker. It is the hub's presence listener, so it must not block.
+func (s *Server) syncPresence(roomID string, playerID string, presence Presence) {
+	now := time.Now()
+	s.queueSeats(roomID, func(rs *roomSeats) {
+		s.applyPresence(rs, roomID, playerID, presence.Status, now)
+	})
+}
+
+// applyPresence copies a presence change into the player's seat and tells
+// the room. A player who loses their last connection is shown as
+// reconnecting while their seat is held; coming back before the grace
+// period ends cancels it. Changes for players who have already left are
+// dropped; their connections are on their way out. It runs on the room's
+// seat worker.
+func (s *Server) applyPresence(rs *roomSeats, roomID string, playerID string, status store.PresenceStatus, now time.Time) {
+	if hold := rs.holds[playerID]; hold != nil {
+		hold.timer.Stop()
+		delete(rs.holds, playerID)
+	}
+
+	room := s.rooms.GetRoom(roomID)
+	if room == nil {
+		return
+	}
+
+	grace := time.Duration(s.disconnectGrace.Load())
+	holdSeat := status == store.PresenceDisconnected && grace > 0
+	if holdSeat {
+		status = store.PresenceReconnecting
+	}
+
+	if err := room.SetPresence(playerID, status, now); err != nil {
+		return
+	}
+	s.BroadcastPresenceChanged(roomID, playerID, status, now)
+
+	if holdSeat {
+		key := seatKey{roomID: roomID, playerID: playerID}
+		hold := &seatHold{since: now}
+		hold.timer = time.AfterFunc(grace, func() {
+			s.queueSeats(roomID, func(rs *roomSeats) {
+				s.expireSeat(rs, key, hold)
+			})
+		})
+		rs.holds[playerID] = hold
+	}
+}
+
+// expireSeat ends a grace period that ran out: the player is marked
+// disconnected and the room's disconnect policy decides what happens to a
+// round that was waiting on them. It runs on the room's seat worker.
+func (s *Server) expireSeat(rs *roomSeats, key seatKey, hold *seatHold) {
+	if rs.holds[key.playerID] != hold {
+		// The player came back, or left, just as the timer fired
+		return
+	}
+	delete(rs.holds, key.playerID)
+
+	room := s.rooms.GetRoom(key.roomID)
+	if room == nil {
+		return
+	}
+	if err := room.SetPresence(key.playerID, store.PresenceDisconnected, hold.since); err != nil {
+		return
+	}
+	s.BroadcastPresenceChanged(room.ID, key.playerID, store.PresenceDisconnected, hold.since)
+
+	outcome, err := game.ResolveDisconnect(room, key.playerID)
+	if err != nil {
+		log.Printf("Error applying disconnect policy in room %s: %v", room.ID, err)
+		return
+	}
+	if outcome == nil {
+		return
+	}
+
+	log.Printf("Player %s in room %s did not reconnect; applied disconnect policy %s", key.playerID, room.ID, outcome.Policy)
+	s.BroadcastDisconnectResolved(room.ID, key.playerID, outcome)
+	if outcome.Result != nil {
+		s.announceGuess(room, outcome.Result)
+	}
+}
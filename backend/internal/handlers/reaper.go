@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+)
+
+const (
+	// DefaultIdleTTL is how long a room is kept without any activity
+	DefaultIdleTTL = 30 * time.Minute
+	// DefaultFinishedTTL is how long a room is kept after its match ends
+	DefaultFinishedTTL = 10 * time.Minute
+	// DefaultExpiryWarning is how long before a room expires its clients
+	// are sent ROOM_EXPIRING
+	DefaultExpiryWarning = 2 * time.Minute
+	// DefaultSweepInterval is how often the reaper looks for expired rooms
+	DefaultSweepInterval = 30 * time.Second
+)
+
+// ReaperConfig decides when rooms are reaped and how often the reaper
+// looks for them
+type ReaperConfig struct {
+	store.ExpiryPolicy
+	// Warning is how long before a room expires it is sent ROOM_EXPIRING.
+	// Zero sends no warning.
+	Warning time.Duration
+	// SweepInterval is how often rooms are checked. Zero or less never
+	// starts the reaper.
+	SweepInterval time.Duration
+}
+
+// DefaultReaperConfig returns the defaults above
+func DefaultReaperConfig() ReaperConfig {
+	return ReaperConfig{
+		ExpiryPolicy: store.ExpiryPolicy{
+			IdleTTL:     DefaultIdleTTL,
+			FinishedTTL: DefaultFinishedTTL,
+		},
+		Warning:       DefaultExpiryWarning,
+		SweepInterval: DefaultSweepInterval,
+	}
+}
+
+// RoomStats counts live rooms and what the reaper has done since the server
+// started
+type RoomStats struct {
+	Live           int
+	Sweeps         int64
+	Warned         int64
+	ReapedIdle     int64
+	ReapedFinished int64
+}
+
+func (s *Server) GetRoomStats() RoomStats {
+	return RoomStats{
+		Live:           s.rooms.Count(),
+		Sweeps:         s.sweeps.Load(),
+		Warned:         s.warned.Load(),
+		ReapedIdle:     s.reapedIdle.Load(),
+		ReapedFinished: s.reapedFinished.Load(),
+	}
+}
+
+// StartReaper sweeps rooms every config.SweepInterval until stop is called
+func (s *Server) StartReaper(config ReaperConfig) (stop func()) {
+	if config.SweepInterval <= 0 {
+		return func() {}
+	}
+
+	ticker := time.NewTicker(config.SweepInterval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case now := <-ticker.C:
+				s.SweepRooms(config, now)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+}
+
+// SweepRooms reaps every room that has expired as of now, and warns the
+// ones about to. A reaped room is sent ROOM_CLOSED and its connections are
+// closed, as if the host had closed it.
+func (s *Server) SweepRooms(config ReaperConfig, now time.Time) {
+	s.sweepMu.Lock()
+	defer s.sweepMu.Unlock()
+
+	s.sweeps.Add(1)
+	live := make(map[string]bool)
+	s.rooms.Range(func(room *store.Room) bool {
+		at, reason, ok := room.Expiry(config.ExpiryPolicy)
+		if !ok {
+			return true
+		}
+
+		if !now.Before(at) {
+			if s.reapRoom(room.ID, config.ExpiryPolicy, now) {
+				return true
+			}
+		} else if config.Warning > 0 && !now.Before(at.Add(-config.Warning)) && !s.expiryWarnings[room.ID].Equal(at) {
+			s.expiryWarnings[room.ID] = at
+			s.warned.Add(1)
+			s.BroadcastRoomExpiring(room.ID, reason, at)
+		}
+		live[room.ID] = true
+		return true
+	})
+
+	for roomID := range s.expiryWarnings {
+		if !live[roomID] {
+			delete(s.expiryWarnings, roomID)
+		}
+	}
+}
+
+// reapRoom deletes a room that has expired and closes it, or returns false
+// if it saw activity in the meantime or couldn't be deleted, in which case
+// the next sweep tries again
+func (s *Server) reapRoom(roomID string, policy store.ExpiryPolicy, now time.Time) bool {
+	room, reason, err := s.rooms.ExpireRoom(roomID, policy, now)
+	if err != nil {
+		log.Printf("Error removing expired room %s: %v", roomID, err)
+		return false
+	}
+	if room == nil {
+		return false
+	}
+
+	switch reason {
+	case store.ExpiryIdle:
+		s.reapedIdle.Add(1)
+	case store.ExpiryFinished:
+		s.reapedFinished.Add(1)
+	}
+	log.Printf("Room %s expired (%s) and was removed", roomID, reason)
+	s.releaseRoom(roomID)
+
+	s.BroadcastRoomClosed(roomID, "room expired ("+string(reason)+")")
+	s.hub.DisconnectRoom(roomID, CloseRoomClosed, "room expired")
+	return true
+}
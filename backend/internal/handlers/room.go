@@ -2,25 +2,30 @@ package handlers
 
 import (
 	"encoding/json"
-	"math/rand"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"time"
 
+	"github.com/bit2swaz/codechef-recruit/backend/internal/game"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/roomcode"
 	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
 	"github.com/gorilla/mux"
 )
 
-var (
-	roomManager = store.NewRoomManager()
-	random      = rand.New(rand.NewSource(time.Now().UnixNano()))
-)
-
 type CreateRoomRequest struct {
-	PlayerName string `json:"playerName"`
+	PlayerName   string `json:"playerName"`
+	Rounds       int    `json:"rounds,omitempty"`
+	Scoring      string `json:"scoring,omitempty"`
+	Players      int    `json:"players,omitempty"`
+	OnDisconnect string `json:"onDisconnect,omitempty"`
 }
 
 type CreateRoomResponse struct {
-	RoomID string `json:"roomId"`
+	RoomID   string `json:"roomId"`
+	PlayerID string `json:"playerId"`
+	Token    string `json:"token"`
 }
 
 type JoinRoomRequest struct {
@@ -29,40 +34,172 @@ type JoinRoomRequest struct {
 }
 
 type JoinRoomResponse struct {
-	Message string `json:"message"`
-	RoomID  string `json:"roomId"`
+	Message  string `json:"message"`
+	RoomID   string `json:"roomId"`
+	PlayerID string `json:"playerId"`
+	Token    string `json:"token"`
+}
+
+type LeaveRoomRequest struct {
+	RoomID string `json:"roomId"`
 }
 
 type RoomDetailsResponse struct {
-	RoomID  string             `json:"roomId"`
-	Status  string             `json:"status"`
-	Players []PlayerInfoPublic `json:"players"`
+	RoomID       string             `json:"roomId"`
+	Status       string             `json:"status"`
+	RoundsPlayed int                `json:"roundsPlayed"`
+	TotalRounds  int                `json:"totalRounds"`
+	Scoring      string             `json:"scoring"`
+	OnDisconnect string             `json:"onDisconnect"`
+	MaxPlayers   int                `json:"maxPlayers"`
+	HostID       string             `json:"hostId"`
+	Locked       bool               `json:"locked"`
+	Players      []PlayerInfoPublic `json:"players"`
 }
 
+// PlayerInfoPublic is what everyone in a room can see about a player.
+// LastSeen is in Unix milliseconds and left out if they never connected.
 type PlayerInfoPublic struct {
-	ID    string `json:"id"`
-	Name  string `json:"name"`
-	Score int    `json:"score"`
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Permission string `json:"permission"`
+	Score      int    `json:"score"`
+	Ready      bool   `json:"ready"`
+	Presence   string `json:"presence"`
+	LastSeen   int64  `json:"lastSeen,omitempty"`
 }
 
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
-func generateRoomID() string {
-	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	result := make([]byte, 4)
-	for i := range result {
-		result[i] = charset[random.Intn(len(charset))]
+// SetRoomCodes sets the codes new rooms are given. A code is never given
+// to a new room while a live room has it, whichever space it came from.
+func (s *Server) SetRoomCodes(space roomcode.Space) {
+	s.codesMu.Lock()
+	defer s.codesMu.Unlock()
+
+	s.codes = space
+}
+
+// allocateRoom creates a room under a code no live room has, on this or any
+// instance in the cluster it has joined, or fails with
+// roomcode.ErrExhausted if there are none left. setup sets up the room's
+// initial state, as with store.RoomStore.CreateRoomWith.
+func (s *Server) allocateRoom(setup func(state *store.RoomState) error) (*store.Room, error) {
+	s.codesMu.RLock()
+	space := s.codes
+	s.codesMu.RUnlock()
+
+	var room *store.Room
+	_, err := roomcode.Allocate(space, func(code string) error {
+		created, err := s.rooms.CreateRoomWith(code, setup)
+		if errors.Is(err, store.ErrRoomExists) {
+			return roomcode.ErrTaken
+		} else if err != nil {
+			return err
+		}
+
+		if err := s.claimRoom(code); err != nil {
+			// Nobody has seen the room yet, so nothing is announced
+			if _, deleteErr := s.rooms.DeleteRoom(code); deleteErr != nil {
+				log.Printf("Error deleting unclaimed room %s: %v", code, deleteErr)
+			}
+			return err
+		}
+		room = created
+		return nil
+	})
+	return room, err
+}
+
+func hasPlayer(players []store.Player, playerID string) bool {
+	for _, p := range players {
+		if p.ID == playerID {
+			return true
+		}
 	}
-	return string(result)
+	return false
 }
 
-func generatePlayerID() string {
-	return time.Now().Format("20060102150405") + "-" + string(rune(random.Intn(10000)))
+func publicPlayers(players []store.Player) []PlayerInfoPublic {
+	public := make([]PlayerInfoPublic, len(players))
+	for i, p := range players {
+		public[i] = PlayerInfoPublic{
+			ID:         p.ID,
+			Name:       p.Name,
+			Permission: string(p.Permission),
+			Score:      p.Score,
+			Ready:      p.Ready,
+			Presence:   string(p.Presence.Status),
+			LastSeen:   unixMilli(p.Presence.LastSeen),
+		}
+	}
+	return public
 }
 
-func CreateRoom(w http.ResponseWriter, r *http.Request) {
+// unixMilli converts t to Unix milliseconds, keeping the zero time as 0
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// roomDetails is the public view of a room's state
+func roomDetails(roomID string, state store.RoomState) RoomDetailsResponse {
+	return RoomDetailsResponse{
+		RoomID:       roomID,
+		Status:       string(state.Status),
+		RoundsPlayed: state.RoundsPlayed,
+		TotalRounds:  state.TotalRounds,
+		Scoring:      state.Scoring,
+		OnDisconnect: state.DisconnectPolicy,
+		MaxPlayers:   state.PlayerCount,
+		HostID:       state.HostID(),
+		Locked:       state.Locked,
+		Players:      publicPlayers(state.Players),
+	}
+}
+
+// removePlayer unseats playerID because they left, and tells the room.
+func (s *Server) removePlayer(room *store.Room, playerID string) error {
+	departure, err := room.RemovePlayer(playerID)
+	if err != nil {
+		return err
+	}
+
+	s.announceDeparture(room, departure, false)
+	return nil
+}
+
+// announceDeparture tells the room a player left or was kicked. The player's
+// connections are closed once the event has been flushed to them, and the
+// room is deleted when its last player goes.
+func (s *Server) announceDeparture(room *store.Room, departure store.Departure, kicked bool) {
+	playerID := departure.Player.ID
+	if kicked {
+		s.BroadcastPlayerKicked(room.ID, departure)
+		s.hub.DisconnectPlayer(room.ID, playerID, CloseKicked, "kicked from room")
+	} else {
+		s.BroadcastPlayerLeft(room.ID, departure)
+		s.hub.DisconnectPlayer(room.ID, playerID, CloseLeft, "left room")
+	}
+
+	if departure.NewHostID != "" {
+		s.BroadcastHostChanged(room.ID, playerID, departure.NewHostID)
+	}
+	if len(departure.Players) == 0 {
+		// An empty room that can't be deleted now is left to the reaper
+		if deleted, err := s.rooms.DeleteRoom(room.ID); err != nil {
+			log.Printf("Error deleting empty room %s: %v", room.ID, err)
+		} else if deleted != nil {
+			s.releaseRoom(room.ID)
+		}
+	}
+}
+
+func (s *Server) CreateRoom(w http.ResponseWriter, r *http.Request) {
 	var req CreateRoomRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -76,26 +213,71 @@ func CreateRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	roomID := generateRoomID()
+	if req.Rounds < 0 || req.Rounds > store.MaxRounds {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("rounds must be between 1 and %d", store.MaxRounds)})
+		return
+	}
+
+	policy, err := game.LookupScoringPolicy(req.Scoring)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	onDisconnect, err := game.ParseDisconnectPolicy(req.OnDisconnect)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
 
-	room := roomManager.CreateRoom(roomID)
+	playerCount := store.DefaultPlayerCount
+	if req.Players != 0 {
+		playerCount = req.Players
+	}
+	if _, err := game.RoleTableFor(playerCount); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
 
-	admin := store.Player{
-		ID:    generatePlayerID(),
-		Name:  req.PlayerName,
-		Role:  "Admin",
-		Score: 0,
+	host := store.Player{
+		Identity: store.Identity{ID: generatePlayerID(), Name: req.PlayerName},
+	}
+	room, err := s.allocateRoom(func(state *store.RoomState) error {
+		state.PlayerCount = playerCount
+		if req.Rounds > 0 {
+			state.TotalRounds = req.Rounds
+		}
+		state.Scoring = policy.Name()
+		state.DisconnectPolicy = string(onDisconnect)
+		return state.AddPlayer(host)
+	})
+	if errors.Is(err, roomcode.ErrExhausted) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "No room codes are free, try again later"})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to create room"})
+		return
 	}
-	room.AddPlayer(admin)
 
-	BroadcastPlayerJoined(roomID, admin.Name, admin.ID)
+	s.BroadcastPlayerJoined(room.ID, host.Name, host.ID)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(CreateRoomResponse{RoomID: roomID})
+	json.NewEncoder(w).Encode(CreateRoomResponse{
+		RoomID:   room.ID,
+		PlayerID: host.ID,
+		Token:    IssueSessionToken(room.ID, host.ID),
+	})
 }
 
-func JoinRoom(w http.ResponseWriter, r *http.Request) {
+func (s *Server) JoinRoom(w http.ResponseWriter, r *http.Request) {
 	var req JoinRoomRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -109,40 +291,60 @@ func JoinRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room := roomManager.GetRoom(req.RoomID)
+	room := s.rooms.GetRoom(req.RoomID)
 	if room == nil {
 		w.WriteHeader(http.StatusNotFound)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "Room not found"})
 		return
 	}
 
-	players := room.GetPlayers()
-	if len(players) >= 4 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Room is full (max 4 players)"})
-		return
-	}
-
 	player := store.Player{
-		ID:    generatePlayerID(),
-		Name:  req.PlayerName,
-		Role:  "Player",
-		Score: 0,
+		Identity: store.Identity{ID: generatePlayerID(), Name: req.PlayerName},
+	}
+	if err := room.AddPlayer(player); err != nil {
+		writeGameError(w, err)
+		return
 	}
-	room.AddPlayer(player)
 
 	// Broadcast to all connected clients that a player joined
-	BroadcastPlayerJoined(req.RoomID, player.Name, player.ID)
+	s.BroadcastPlayerJoined(req.RoomID, player.Name, player.ID)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(JoinRoomResponse{
-		Message: "Successfully joined room",
-		RoomID:  req.RoomID,
+		Message:  "Successfully joined room",
+		RoomID:   req.RoomID,
+		PlayerID: player.ID,
+		Token:    IssueSessionToken(req.RoomID, player.ID),
 	})
 }
 
-func GetRoom(w http.ResponseWriter, r *http.Request) {
+// LeaveRoom gives up the caller's seat. See store.Room.RemovePlayer for what
+// happens to a match in progress.
+func (s *Server) LeaveRoom(w http.ResponseWriter, r *http.Request) {
+	var req LeaveRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	room, session, ok := s.sessionRoom(w, r, req.RoomID)
+	if !ok {
+		return
+	}
+
+	if err := s.removePlayer(room, session.PlayerID); err != nil {
+		writeGameError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Left room"})
+}
+
+func (s *Server) GetRoom(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	roomID := vars["roomId"]
 
@@ -152,28 +354,14 @@ func GetRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room := roomManager.GetRoom(roomID)
+	room := s.rooms.GetRoom(roomID)
 	if room == nil {
 		w.WriteHeader(http.StatusNotFound)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "Room not found"})
 		return
 	}
 
-	players := room.GetPlayers()
-	publicPlayers := make([]PlayerInfoPublic, len(players))
-	for i, p := range players {
-		publicPlayers[i] = PlayerInfoPublic{
-			ID:    p.ID,
-			Name:  p.Name,
-			Score: p.Score,
-		}
-	}
-
-	response := RoomDetailsResponse{
-		RoomID:  room.ID,
-		Status:  room.GetStatus(),
-		Players: publicPlayers,
-	}
+	response := roomDetails(room.ID, room.Snapshot())
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/broker"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/roomcode"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+)
+
+// Server serves the game over HTTP, WebSocket and SSE. It only reaches the
+// rooms through the store.RoomStore it is given, and their clients through
+// its Hub.
+type Server struct {
+	rooms store.RoomStore
+	hub   *Hub
+
+	// codes are the codes new rooms are given
+	codes   roomcode.Space
+	codesMu sync.RWMutex
+
+	disconnectGrace atomic.Int64
+	// seats are the held seats and queued presence changes by room ID.
+	// seatsMu guards the map and each entry's queue.
+	seats   map[string]*roomSeats
+	seatsMu sync.Mutex
+
+	sweeps         atomic.Int64
+	warned         atomic.Int64
+	reapedIdle     atomic.Int64
+	reapedFinished atomic.Int64
+	// expiryWarnings is the expiry each room was last warned about, so a
+	// room is warned once per deadline. sweepMu keeps sweeps from
+	// overlapping.
+	expiryWarnings map[string]time.Time
+	sweepMu        sync.Mutex
+
+	// directory is where room owners are recorded once JoinCluster is
+	// called, under addr for this instance. proxies are the proxies to the
+	// other instances, by address.
+	directory broker.Directory
+	addr      string
+	proxies   sync.Map
+}
+
+// NewServer returns a Server for the rooms in rooms, whose events are
+// delivered through hub. It must be created before the server starts taking
+// requests. Nobody is connected to rooms the store already holds, such as
+// ones restored after a restart, so players who were connected get their
+// seats held for the disconnect grace period, as if they had all just
+// dropped.
+func NewServer(rooms store.RoomStore, hub *Hub) *Server {
+	s := &Server{
+		rooms:          rooms,
+		hub:            hub,
+		codes:          roomcode.Default(),
+		seats:          make(map[string]*roomSeats),
+		expiryWarnings: make(map[string]time.Time),
+	}
+	s.disconnectGrace.Store(int64(DefaultDisconnectGrace))
+	hub.OnPresenceChange(s.syncPresence)
+	rooms.OnPhaseChange(s.BroadcastPhaseChanged)
+
+	rooms.Range(func(room *store.Room) bool {
+		for _, p := range room.Snapshot().Players {
+			if p.Presence.Status != store.PresenceDisconnected {
+				s.syncPresence(room.ID, p.ID, Presence{Status: store.PresenceDisconnected})
+			}
+		}
+		return true
+	})
+	return s
+}
+
+// Hub returns the hub the server delivers room events through
+func (s *Server) Hub() *Hub {
+	return s.hub
+}
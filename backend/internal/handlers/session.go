@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+)
+
+const sessionTTL = 24 * time.Hour
+
+var (
+	ErrMissingSession = errors.New("session token is required")
+	ErrInvalidSession = errors.New("invalid session token")
+	ErrExpiredSession = errors.New("session token has expired")
+)
+
+// sessionSecret signs session tokens. Set SESSION_SECRET to keep tokens valid
+// across restarts or to share them between server instances.
+var sessionSecret = loadSessionSecret()
+
+// Session is the identity carried by a verified session token
+type Session struct {
+	RoomID    string
+	PlayerID  string
+	ExpiresAt time.Time
+}
+
+func loadSessionSecret() []byte {
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("Failed to generate session secret: %v", err)
+	}
+	return secret
+}
+
+func signSession(payload string) string {
+	mac := hmac.New(sha256.New, sessionSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// IssueSessionToken returns a signed token that identifies playerID in roomID
+func IssueSessionToken(roomID string, playerID string) string {
+	expiresAt := time.Now().Add(sessionTTL).Unix()
+	payload := roomID + "|" + playerID + "|" + strconv.FormatInt(expiresAt, 10)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + signSession(payload)
+}
+
+// VerifySessionToken checks a token's signature and expiry and returns the
+// session it carries
+func VerifySessionToken(token string) (*Session, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidSession
+	}
+
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	payload := string(payloadBytes)
+
+	if !hmac.Equal([]byte(signature), []byte(signSession(payload))) {
+		return nil, ErrInvalidSession
+	}
+
+	parts := strings.Split(payload, "|")
+	if len(parts) != 3 {
+		return nil, ErrInvalidSession
+	}
+
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	if time.Now().Unix() > expiresAt {
+		return nil, ErrExpiredSession
+	}
+
+	return &Session{
+		RoomID:    parts[0],
+		PlayerID:  parts[1],
+		ExpiresAt: time.Unix(expiresAt, 0),
+	}, nil
+}
+
+// sessionToken reads the token from the Authorization header, falling back
+// to the token query parameter for WebSocket clients that can't set headers
+func sessionToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return token
+		}
+	}
+	return r.URL.Query().Get("token")
+}
+
+// requireSession verifies the request's session token and checks that it
+// belongs to a player who is still seated in roomID. On failure it writes a
+// 401 or 403 response and returns false.
+func (s *Server) requireSession(w http.ResponseWriter, r *http.Request, roomID string) (*Session, bool) {
+	token := sessionToken(r)
+	if token == "" {
+		writeSessionError(w, http.StatusUnauthorized, ErrMissingSession)
+		return nil, false
+	}
+
+	session, err := VerifySessionToken(token)
+	if err != nil {
+		writeSessionError(w, http.StatusUnauthorized, err)
+		return nil, false
+	}
+
+	if session.RoomID != roomID {
+		writeSessionError(w, http.StatusForbidden, errors.New("session token is for a different room"))
+		return nil, false
+	}
+
+	room := s.rooms.GetRoom(roomID)
+	if room == nil || !hasPlayer(room.GetPlayers(), session.PlayerID) {
+		writeSessionError(w, http.StatusForbidden, errors.New("player is not in this room"))
+		return nil, false
+	}
+
+	return session, true
+}
+
+// sessionRoom looks up roomID and verifies the caller's session for it. On
+// failure it writes the error response and returns false.
+func (s *Server) sessionRoom(w http.ResponseWriter, r *http.Request, roomID string) (*store.Room, *Session, bool) {
+	if roomID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "roomId is required"})
+		return nil, nil, false
+	}
+
+	room := s.rooms.GetRoom(roomID)
+	if room == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Room not found"})
+		return nil, nil, false
+	}
+
+	session, ok := s.requireSession(w, r, room.ID)
+	if !ok {
+		return nil, nil, false
+	}
+	return room, session, true
+}
+
+func writeSessionError(w http.ResponseWriter, status int, err error) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+}
+
+func generatePlayerID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Failed to generate player ID: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
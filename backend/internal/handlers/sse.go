@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// HandleEvents streams a room's events to the session's player as
+// Server-Sent Events, for clients whose network breaks WebSocket upgrades.
+// It is the read side only; those clients act through the HTTP API. Each
+// event is the same envelope a v1 WebSocket client gets, sent as the data
+// of an unnamed SSE event with the room event's seq as its id, so a client
+// that reconnects resumes through Last-Event-ID. When the server ends the
+// stream on purpose it first sends a "close" event carrying the WebSocket
+// close code and reason.
+func (s *Server) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	roomID := mux.Vars(r)["roomId"]
+	if roomID == "" {
+		http.Error(w, "roomId is required", http.StatusBadRequest)
+		return
+	}
+
+	// EventSource sends Last-Event-ID when it reconnects by itself; since
+	// lets a client that starts a new EventSource resume too
+	lastEventID := r.Header.Get("Last-Event-ID")
+	present := lastEventID != ""
+	if !present {
+		lastEventID, present = r.URL.Query().Get("since"), r.URL.Query().Has("since")
+	}
+	since, resume, err := parseSince(lastEventID, present)
+	if err != nil {
+		http.Error(w, "Last-Event-ID must be an event sequence number", http.StatusBadRequest)
+		return
+	}
+
+	room := s.rooms.GetRoom(roomID)
+	if room == nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	session, ok := s.requireSession(w, r, roomID)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Stop proxies such as nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	client := &Client{
+		server:   s,
+		RoomID:   roomID,
+		PlayerID: session.PlayerID,
+		Send:     make(chan []byte, 256),
+		closing:  make(chan []byte, 1),
+	}
+	if !client.attach(room, since, resume) {
+		writeSSEClose(w, CloseNotSeated, "player is not in this room")
+		flusher.Flush()
+		return
+	}
+	defer client.detach()
+	log.Printf("Player %s streaming room %s over SSE", session.PlayerID, roomID)
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message := <-client.Send:
+			if err := writeSSEMessage(w, message); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case frame := <-client.closing:
+			for len(client.Send) > 0 {
+				if err := writeSSEMessage(w, <-client.Send); err != nil {
+					return
+				}
+			}
+			code, reason := parseCloseFrame(frame)
+			writeSSEClose(w, code, reason)
+			flusher.Flush()
+			return
+
+		case <-ticker.C:
+			// A comment keeps idle proxies from dropping the stream
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSEMessage writes one encoded envelope as an SSE event, with the
+// room event's seq as its id if it has one
+func writeSSEMessage(w http.ResponseWriter, message []byte) error {
+	var header struct {
+		Seq uint64 `json:"seq"`
+	}
+	if err := json.Unmarshal(message, &header); err != nil {
+		return err
+	}
+
+	if header.Seq != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", header.Seq); err != nil {
+			return err
+		}
+	}
+	// Encoded envelopes never contain a raw newline
+	_, err := fmt.Fprintf(w, "data: %s\n\n", message)
+	return err
+}
+
+// writeSSEClose tells the client the server is ending the stream, and why
+func writeSSEClose(w http.ResponseWriter, code int, reason string) {
+	data, _ := json.Marshal(map[string]interface{}{"code": code, "reason": reason})
+	fmt.Fprintf(w, "event: close\ndata: %s\n\n", data)
+}
+
+// parseCloseFrame reads the code and reason out of a WebSocket close frame
+// built with websocket.FormatCloseMessage
+func parseCloseFrame(frame []byte) (int, string) {
+	if len(frame) < 2 {
+		return 0, ""
+	}
+	return int(binary.BigEndian.Uint16(frame)), string(frame[2:])
+}
@@ -1,11 +1,13 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
@@ -13,6 +15,7 @@ import (
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	Subprotocols:    []string{SubprotocolV1},
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
@@ -25,15 +28,15 @@ const (
 	maxMessageSize = 512
 )
 
-type WSMessage struct {
-	Type      string                 `json:"type"`
-	PlayerID  string                 `json:"playerId"`
-	RoomID    string                 `json:"roomId"`
-	Data      map[string]interface{} `json:"data"`
-	Timestamp int64                  `json:"timestamp"`
-}
+// Close codes sent when the server ends a connection on purpose
+const (
+	CloseKicked     = 4001
+	CloseRoomClosed = 4002
+	CloseLeft       = 4003
+	CloseNotSeated  = 4005
+)
 
-func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	roomID := vars["roomId"]
 
@@ -48,65 +51,144 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room := roomManager.GetRoom(roomID)
+	since, resume, err := parseSince(r.URL.Query().Get("since"), r.URL.Query().Has("since"))
+	if err != nil {
+		http.Error(w, "since must be an event sequence number", http.StatusBadRequest)
+		return
+	}
+
+	room := s.rooms.GetRoom(roomID)
 	if room == nil {
 		http.Error(w, "Room not found", http.StatusNotFound)
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	session, ok := s.requireSession(w, r, roomID)
+	if !ok {
+		return
+	}
+	if session.PlayerID != playerID {
+		writeSessionError(w, http.StatusForbidden, errors.New("session does not belong to playerId"))
+		return
+	}
+
+	// Clients that don't offer SubprotocolV1 get the legacy format, and are
+	// told it is deprecated and when it goes away
+	legacy := !offersSubprotocol(r, SubprotocolV1)
+	var header http.Header
+	if legacy {
+		header = http.Header{
+			"Deprecation": {"true"},
+			"Sunset":      {LegacySunset.Format(http.TimeFormat)},
+		}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, header)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
+	if legacy {
+		log.Printf("Player %s connected to room %s with the legacy protocol", playerID, roomID)
+	}
 
 	client := &Client{
+		server:   s,
 		Conn:     conn,
 		RoomID:   roomID,
 		PlayerID: playerID,
 		Send:     make(chan []byte, 256),
+		Legacy:   legacy,
+		closing:  make(chan []byte, 1),
 	}
 
-	hub.register <- client
+	if !client.attach(room, since, resume) {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(CloseNotSeated, "player is not in this room"),
+			time.Now().Add(writeWait))
+		conn.Close()
+		return
+	}
+
+	go client.writePump()
+	go client.readPump()
+}
 
-	welcomeMsg := WSMessage{
-		Type:      "connected",
-		PlayerID:  playerID,
-		RoomID:    roomID,
-		Data:      map[string]interface{}{"message": "Connected to room"},
-		Timestamp: time.Now().Unix(),
+// parseSince reads the seq of the last event a client processed before it
+// lost its connection. resume is false if the client didn't send one.
+func parseSince(value string, present bool) (since uint64, resume bool, err error) {
+	if !present {
+		return 0, false, nil
 	}
-	welcomeJSON, _ := json.Marshal(welcomeMsg)
-	client.Send <- welcomeJSON
+	since, err = strconv.ParseUint(value, 10, 64)
+	return since, true, err
+}
+
+// attach registers a new connection, which is sent its backlog first, and
+// announces the player if it is their first. The player may have left
+// between the session check and registering, in which case the connection
+// is unregistered again and attach returns false. Once registered, a later
+// departure closes the connection like any other, so checking again here
+// leaves no gap.
+func (c *Client) attach(room *store.Room, since uint64, resume bool) bool {
+	presence := c.server.hub.RegisterWithBacklog(c, func() []store.Event {
+		return c.backlog(room, since, resume)
+	})
 
-	joinMsg := WSMessage{
-		Type:      "player_joined",
-		PlayerID:  playerID,
-		RoomID:    roomID,
-		Data:      map[string]interface{}{"playerCount": hub.GetClientCount(roomID)},
-		Timestamp: time.Now().Unix(),
+	if !hasPlayer(room.GetPlayers(), c.PlayerID) {
+		c.server.hub.Unregister(c)
+		return false
 	}
-	joinJSON, _ := json.Marshal(joinMsg)
-	hub.BroadcastToRoom(roomID, joinJSON)
 
-	go client.writePump()
-	go client.readPump(hub)
+	// A player with several tabs or devices open is announced once, when the
+	// first of them connects
+	if presence.Connections == 1 {
+		c.server.publishConnectionEvent(c.RoomID, c.PlayerID, PlayerConnectedPayload{
+			PlayerID:    c.PlayerID,
+			PlayerCount: presence.Players,
+		})
+	}
+	return true
 }
 
-func (c *Client) readPump(hub *Hub) {
-	defer func() {
-		hub.unregister <- c
-		c.Conn.Close()
+// detach unregisters a connection and announces the player's departure if
+// it was their last
+func (c *Client) detach() {
+	presence := c.server.hub.Unregister(c)
+	if presence.Connections == 0 {
+		c.server.publishConnectionEvent(c.RoomID, c.PlayerID, PlayerDisconnectedPayload{
+			PlayerID:    c.PlayerID,
+			PlayerCount: presence.Players,
+		})
+	}
+}
+
+// backlog is what a client is sent before live events: the welcome, then
+// either the events it missed since the seq it resumed from, or a SNAPSHOT
+// if it didn't ask to resume or those events are no longer buffered
+func (c *Client) backlog(room *store.Room, since uint64, resume bool) []store.Event {
+	welcome := store.Event{PlayerID: c.PlayerID}
+	encodeEvent(&welcome, c.RoomID, c.PlayerID, ConnectedPayload{Message: "Connected to room"})
+	events := []store.Event{welcome}
 
-		leaveMsg := WSMessage{
-			Type:      "player_left",
-			PlayerID:  c.PlayerID,
-			RoomID:    c.RoomID,
-			Data:      map[string]interface{}{"playerCount": hub.GetClientCount(c.RoomID)},
-			Timestamp: time.Now().Unix(),
+	if resume {
+		if missed, ok := room.Events().Since(since, c.PlayerID); ok {
+			return append(events, missed...)
 		}
-		leaveJSON, _ := json.Marshal(leaveMsg)
-		hub.BroadcastToRoom(c.RoomID, leaveJSON)
+	}
+
+	snapshot, err := snapshotEvent(room, c.PlayerID)
+	if err != nil {
+		log.Printf("Error marshaling snapshot: %v", err)
+		return events
+	}
+	return append(events, snapshot)
+}
+
+func (c *Client) readPump() {
+	defer func() {
+		c.detach()
+		c.Conn.Close()
 	}()
 
 	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
@@ -125,20 +207,7 @@ func (c *Client) readPump(hub *Hub) {
 			break
 		}
 
-		var wsMsg WSMessage
-		if err := json.Unmarshal(message, &wsMsg); err != nil {
-			log.Printf("Error parsing WebSocket message: %v", err)
-			continue
-		}
-
-		wsMsg.PlayerID = c.PlayerID
-		wsMsg.RoomID = c.RoomID
-		wsMsg.Timestamp = time.Now().Unix()
-
-		msgJSON, _ := json.Marshal(wsMsg)
-		hub.BroadcastToRoom(c.RoomID, msgJSON)
-
-		log.Printf("Message from %s in room %s: %s", c.PlayerID, c.RoomID, wsMsg.Type)
+		c.server.dispatchCommand(c, message)
 	}
 }
 
@@ -162,6 +231,12 @@ func (c *Client) writePump() {
 				return
 			}
 
+		case frame := <-c.closing:
+			c.flush()
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.Conn.WriteMessage(websocket.CloseMessage, frame)
+			return
+
 		case <-ticker.C:
 			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -170,3 +245,59 @@ func (c *Client) writePump() {
 		}
 	}
 }
+
+// flush writes out whatever is already queued on the Send channel
+func (c *Client) flush() {
+	for len(c.Send) > 0 {
+		message, ok := <-c.Send
+		if !ok {
+			return
+		}
+		c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			return
+		}
+	}
+}
+
+// offersSubprotocol reports whether the client listed protocol in its
+// Sec-WebSocket-Protocol header
+func offersSubprotocol(r *http.Request, protocol string) bool {
+	for _, offered := range websocket.Subprotocols(r) {
+		if offered == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// publishConnectionEvent tells the room about one of playerID's connections.
+// Connection events aren't numbered, since a resuming client learns who is
+// connected from newer ones.
+func (s *Server) publishConnectionEvent(roomID string, playerID string, payload EventPayload) {
+	event := store.Event{}
+	if err := encodeEvent(&event, roomID, playerID, payload); err != nil {
+		log.Printf("Error marshaling connection event: %v", err)
+		return
+	}
+	s.hub.PublishEvent(roomID, event)
+}
+
+// reply sends an event to this connection only
+func (c *Client) reply(payload EventPayload) {
+	event := store.Event{PlayerID: c.PlayerID}
+	if err := encodeEvent(&event, c.RoomID, c.PlayerID, payload); err != nil {
+		log.Printf("Error marshaling reply: %v", err)
+		return
+	}
+	message := event.Data
+	if c.Legacy {
+		message = event.Legacy
+	}
+
+	select {
+	case c.Send <- message:
+	default:
+		log.Printf("Failed to reply to player %s (channel full)", c.PlayerID)
+	}
+}
@@ -3,108 +3,325 @@ package handlers
 import (
 	"log"
 	"sync"
+	"sync/atomic"
 
+	"github.com/bit2swaz/codechef-recruit/backend/internal/broker"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// DefaultRoomInboxSize bounds how many events can wait for a room's actor
+	DefaultRoomInboxSize = 1024
+
+	// CloseSlowConsumer is sent to a client evicted for not keeping up
+	CloseSlowConsumer = 4004
+)
+
 type Client struct {
+	// server is the Server whose room the client is connected to
+	server   *Server
 	Conn     *websocket.Conn
 	RoomID   string
 	PlayerID string
 	Send     chan []byte
+	// Legacy clients didn't negotiate a protocol version and are sent the
+	// deprecated wire format
+	Legacy  bool
+	closing chan []byte
+	// lastSeq is the highest room event sequence number sent to the client.
+	// Only the room's actor touches it.
+	lastSeq uint64
+	// away is set while the client has told us its player isn't looking at
+	// it. Only the room's actor touches it.
+	away bool
 }
 
+// Hub routes WebSocket traffic to one actor per room. Each actor is a
+// goroutine that owns its room's clients and works through a bounded inbox,
+// so rooms never wait on each other and a caller never waits on a room.
+//
+// Delivery policy:
+//   - Broadcasts never block. If a room's inbox is full the message is
+//     dropped and counted; clients recover from the SNAPSHOT they get when
+//     they reconnect.
+//   - A client whose Send buffer is full is evicted: it gets no further
+//     messages and its connection is closed with CloseSlowConsumer once the
+//     queued ones are written. Only the actor ever stops sending to a client,
+//     and Send is never closed, so there is no send-on-closed-channel race.
+//   - An actor exits when its last registered client unregisters and is
+//     started again on the next Register.
+//   - Room events carry a sequence number and reach each client in order,
+//     at most once. A client that registers with a backlog gets it first,
+//     and live events it already covered are skipped.
+//
+// Outgoing events go through a broker.Broker rather than straight to the
+// actors, so with a shared broker every server instance delivers a room's
+// events to the clients connected to it.
 type Hub struct {
-	rooms      map[string]map[*Client]bool
-	register   chan *Client
-	unregister chan *Client
-	broadcast  chan *BroadcastMessage
-	mu         sync.RWMutex
+	broker           broker.Broker
+	rooms            map[string]*roomActor
+	inboxSize        int
+	presenceListener PresenceListener
+	dropped          atomic.Int64
+	evicted          atomic.Int64
+	mu               sync.RWMutex
+}
+
+// Presence describes a room's connections right after one of a player's
+// connections registered, unregistered or changed whether it is away
+type Presence struct {
+	// Status is online if any of the player's connections isn't away, away
+	// if all of them are, and disconnected if they have none
+	Status store.PresenceStatus
+	// Connections is how many connections the player has open in the room:
+	// 1 after their first registers, 0 after their last unregisters
+	Connections int
+	// Players is how many players have at least one connection open
+	Players int
+}
+
+// PresenceListener is called when a player's presence status in a room
+// changes
+type PresenceListener func(roomID string, playerID string, presence Presence)
+
+// HubStats counts what the hub has had to shed since it started
+type HubStats struct {
+	Rooms   int
+	Clients int
+	Dropped int64
+	Evicted int64
 }
 
-type BroadcastMessage struct {
-	RoomID  string
-	Message []byte
+type roomEventKind int
+
+const (
+	eventRegister roomEventKind = iota
+	eventUnregister
+	eventBroadcast
+	eventDisconnect
+	eventAway
+)
+
+// roomEvent is one item in a room actor's inbox. PlayerID narrows a
+// broadcast or disconnect to one player's connections.
+type roomEvent struct {
+	kind     roomEventKind
+	client   *Client
+	playerID string
+	seq      uint64
+	message  []byte
+	legacy   []byte
+	backlog  func() []store.Event
+	away     bool
+	done     chan Presence
+}
+
+type roomActor struct {
+	id    string
+	hub   *Hub
+	inbox chan roomEvent
+	// clients are the connections being delivered to, by player
+	clients map[string]map[*Client]bool
+	count   atomic.Int32
+	// connections counts each player's registered connections, including
+	// ones no longer delivered to that haven't unregistered yet, and away
+	// how many of those are away
+	connections map[string]int
+	away        map[string]int
+	// refs counts clients registered and not yet unregistered, including
+	// evicted ones. It is guarded by hub.mu and keeps the actor alive.
+	refs int
 }
 
 func NewHub() *Hub {
-	return &Hub{
-		rooms:      make(map[string]map[*Client]bool),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		broadcast:  make(chan *BroadcastMessage),
+	return NewHubWithInbox(DefaultRoomInboxSize)
+}
+
+// NewHubWithInbox creates a single-instance hub whose room actors have
+// inboxes of the given size
+func NewHubWithInbox(inboxSize int) *Hub {
+	h, _ := NewHubWithBroker(broker.NewInProcess(), inboxSize)
+	return h
+}
+
+// NewHubWithBroker creates a hub that publishes and receives room events
+// through b
+func NewHubWithBroker(b broker.Broker, inboxSize int) (*Hub, error) {
+	h := &Hub{
+		broker:    b,
+		rooms:     make(map[string]*roomActor),
+		inboxSize: inboxSize,
+	}
+	if err := b.Subscribe(h.deliver); err != nil {
+		return nil, err
 	}
+	return h, nil
 }
 
-func (h *Hub) Run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.mu.Lock()
-			if h.rooms[client.RoomID] == nil {
-				h.rooms[client.RoomID] = make(map[*Client]bool)
-			}
-			h.rooms[client.RoomID][client] = true
-			h.mu.Unlock()
-			log.Printf("Client registered to room %s (PlayerID: %s). Total clients in room: %d",
-				client.RoomID, client.PlayerID, len(h.rooms[client.RoomID]))
-
-		case client := <-h.unregister:
-			h.mu.Lock()
-			if clients, ok := h.rooms[client.RoomID]; ok {
-				if _, exists := clients[client]; exists {
-					delete(clients, client)
-					close(client.Send)
-					log.Printf("Client unregistered from room %s (PlayerID: %s). Remaining clients: %d",
-						client.RoomID, client.PlayerID, len(clients))
-
-					// Clean up empty rooms
-					if len(clients) == 0 {
-						delete(h.rooms, client.RoomID)
-						log.Printf("Room %s is now empty and removed", client.RoomID)
-					}
-				}
-			}
-			h.mu.Unlock()
-
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			clients := h.rooms[message.RoomID]
-			clientList := make([]*Client, 0, len(clients))
-			for client := range clients {
-				clientList = append(clientList, client)
-			}
-			h.mu.RUnlock()
-
-			for _, client := range clientList {
-				select {
-				case client.Send <- message.Message:
-				default:
-					close(client.Send)
-					h.mu.Lock()
-					if roomClients, ok := h.rooms[message.RoomID]; ok {
-						delete(roomClients, client)
-					}
-					h.mu.Unlock()
-				}
-			}
+// Close detaches the hub from its broker
+func (h *Hub) Close() error {
+	return h.broker.Close()
+}
+
+// Register adds a client to its room, starting the room's actor if needed.
+// It returns once the client is registered, so every broadcast made after
+// it reaches the client.
+func (h *Hub) Register(client *Client) Presence {
+	return h.RegisterWithBacklog(client, nil)
+}
+
+// RegisterWithBacklog registers client and sends it the events backlog
+// returns before anything else. backlog runs on the room's actor, so no
+// event can slip in between it and live delivery; live events numbered no
+// higher than the last one it returned are not sent again.
+func (h *Hub) RegisterWithBacklog(client *Client, backlog func() []store.Event) Presence {
+	h.mu.Lock()
+	actor := h.rooms[client.RoomID]
+	if actor == nil {
+		actor = &roomActor{
+			id:          client.RoomID,
+			hub:         h,
+			inbox:       make(chan roomEvent, h.inboxSize),
+			clients:     make(map[string]map[*Client]bool),
+			connections: make(map[string]int),
+			away:        make(map[string]int),
 		}
+		h.rooms[client.RoomID] = actor
+		go actor.run()
 	}
+	actor.refs++
+	h.mu.Unlock()
+
+	done := make(chan Presence, 1)
+	actor.inbox <- roomEvent{kind: eventRegister, client: client, backlog: backlog, done: done}
+	return <-done
 }
 
-func (h *Hub) BroadcastToRoom(roomID string, message []byte) {
-	h.broadcast <- &BroadcastMessage{
-		RoomID:  roomID,
-		Message: message,
+// Unregister removes a client from its room. Every Register must be paired
+// with exactly one Unregister.
+func (h *Hub) Unregister(client *Client) Presence {
+	h.mu.RLock()
+	actor := h.rooms[client.RoomID]
+	h.mu.RUnlock()
+
+	if actor == nil {
+		return Presence{}
+	}
+	// The client's own reference keeps the actor alive until this is handled
+	done := make(chan Presence, 1)
+	actor.inbox <- roomEvent{kind: eventUnregister, client: client, done: done}
+	return <-done
+}
+
+// SetAway marks one of a player's connections as away, or back. A player is
+// only away once all of their connections are.
+func (h *Hub) SetAway(client *Client, away bool) Presence {
+	h.mu.RLock()
+	actor := h.rooms[client.RoomID]
+	h.mu.RUnlock()
+
+	if actor == nil {
+		return Presence{Status: store.PresenceDisconnected}
+	}
+	done := make(chan Presence, 1)
+	actor.inbox <- roomEvent{kind: eventAway, client: client, away: away, done: done}
+	return <-done
+}
+
+// OnPresenceChange registers a listener that is called whenever a player's
+// presence status in a room changes. It is called from the room's actor, in
+// the order the changes happened and before the Register, Unregister or
+// SetAway call that caused the change returns. It must not block.
+func (h *Hub) OnPresenceChange(listener PresenceListener) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.presenceListener = listener
+}
+
+// offer queues an event for a room without blocking. Events for rooms with
+// no clients are dropped, since nobody could receive them.
+func (h *Hub) offer(roomID string, event roomEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	actor := h.rooms[roomID]
+	if actor == nil {
+		return
 	}
+
+	select {
+	case actor.inbox <- event:
+	default:
+		h.dropped.Add(1)
+		log.Printf("Room %s inbox full, dropping event", roomID)
+	}
+}
+
+// publish hands an event to the broker. A broker that can't keep up sheds
+// the event like a full inbox would.
+func (h *Hub) publish(msg broker.Message) {
+	if err := h.broker.Publish(msg); err != nil {
+		h.dropped.Add(1)
+		log.Printf("Dropping event for room %s: %v", msg.RoomID, err)
+	}
+}
+
+// deliver receives events from the broker and queues them for the room's
+// actor on this instance
+func (h *Hub) deliver(msg broker.Message) {
+	kind := eventBroadcast
+	if msg.Close {
+		kind = eventDisconnect
+	}
+	h.offer(msg.RoomID, roomEvent{kind: kind, playerID: msg.PlayerID, seq: msg.Seq, message: msg.Data, legacy: msg.Legacy})
+}
+
+// PublishEvent sends a numbered room event to its room, or to one player
+// if it is private
+func (h *Hub) PublishEvent(roomID string, event store.Event) {
+	h.publish(broker.Message{
+		RoomID:   roomID,
+		PlayerID: event.PlayerID,
+		Seq:      event.Seq,
+		Data:     event.Data,
+		Legacy:   event.Legacy,
+	})
+}
+
+func (h *Hub) BroadcastToRoom(roomID string, message []byte) {
+	h.publish(broker.Message{RoomID: roomID, Data: message})
+}
+
+// SendToPlayer delivers a message to every connection playerID has in roomID
+func (h *Hub) SendToPlayer(roomID string, playerID string, message []byte) {
+	h.publish(broker.Message{RoomID: roomID, PlayerID: playerID, Data: message})
+}
+
+// DisconnectPlayer closes every connection playerID has open in roomID
+// with the given close code and reason, after anything already queued for
+// them has been written
+func (h *Hub) DisconnectPlayer(roomID string, playerID string, code int, reason string) {
+	h.publish(broker.Message{
+		RoomID:   roomID,
+		PlayerID: playerID,
+		Data:     websocket.FormatCloseMessage(code, reason),
+		Close:    true,
+	})
+}
+
+// DisconnectRoom closes every connection in roomID
+func (h *Hub) DisconnectRoom(roomID string, code int, reason string) {
+	h.DisconnectPlayer(roomID, "", code, reason)
 }
 
 func (h *Hub) GetClientCount(roomID string) int {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	if clients, ok := h.rooms[roomID]; ok {
-		return len(clients)
+	if actor := h.rooms[roomID]; actor != nil {
+		return int(actor.count.Load())
 	}
 	return 0
 }
@@ -120,13 +337,198 @@ func (h *Hub) GetAllRoomIDs() []string {
 	return roomIDs
 }
 
-var hub = NewHub()
+func (h *Hub) Stats() HubStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	stats := HubStats{
+		Rooms:   len(h.rooms),
+		Dropped: h.dropped.Load(),
+		Evicted: h.evicted.Load(),
+	}
+	for _, actor := range h.rooms {
+		stats.Clients += int(actor.count.Load())
+	}
+	return stats
+}
+
+func (a *roomActor) run() {
+	for event := range a.inbox {
+		switch event.kind {
+		case eventRegister:
+			client := event.client
+			before := a.presence(client.PlayerID).Status
+			if a.clients[client.PlayerID] == nil {
+				a.clients[client.PlayerID] = make(map[*Client]bool)
+			}
+			a.clients[client.PlayerID][client] = true
+			a.count.Add(1)
+			a.connections[client.PlayerID]++
+
+			if event.backlog != nil {
+				for _, e := range event.backlog() {
+					a.send(client, e.Seq, e.Data, e.Legacy)
+				}
+			}
+			a.reply(event, before)
+			log.Printf("Client registered to room %s (PlayerID: %s). Total clients in room: %d",
+				a.id, client.PlayerID, a.count.Load())
+
+		case eventUnregister:
+			client := event.client
+			before := a.presence(client.PlayerID).Status
+			if a.clients[client.PlayerID][client] {
+				a.remove(client)
+				log.Printf("Client unregistered from room %s (PlayerID: %s). Remaining clients: %d",
+					a.id, client.PlayerID, a.count.Load())
+			}
+			if a.connections[client.PlayerID]--; a.connections[client.PlayerID] == 0 {
+				delete(a.connections, client.PlayerID)
+			}
+			a.setAway(client, false)
+			a.reply(event, before)
+
+			if a.release() {
+				log.Printf("Room %s has no connections left", a.id)
+				return
+			}
+
+		case eventBroadcast:
+			a.each(event.playerID, func(client *Client) {
+				a.send(client, event.seq, event.message, event.legacy)
+			})
+
+		case eventDisconnect:
+			a.each(event.playerID, func(client *Client) {
+				a.close(client, event.message)
+			})
+
+		case eventAway:
+			before := a.presence(event.client.PlayerID).Status
+			a.setAway(event.client, event.away)
+			a.reply(event, before)
+		}
+	}
+}
+
+// each calls fn for every client of playerID, or of the whole room if
+// playerID is empty. fn may remove the client it is given.
+func (a *roomActor) each(playerID string, fn func(client *Client)) {
+	if playerID != "" {
+		for client := range a.clients[playerID] {
+			fn(client)
+		}
+		return
+	}
+	for _, clients := range a.clients {
+		for client := range clients {
+			fn(client)
+		}
+	}
+}
+
+func (a *roomActor) presence(playerID string) Presence {
+	p := Presence{Connections: a.connections[playerID], Players: len(a.connections)}
+	switch {
+	case p.Connections == 0:
+		p.Status = store.PresenceDisconnected
+	case a.away[playerID] == p.Connections:
+		p.Status = store.PresenceAway
+	default:
+		p.Status = store.PresenceOnline
+	}
+	return p
+}
+
+func (a *roomActor) setAway(client *Client, away bool) {
+	if client.away == away {
+		return
+	}
+	client.away = away
+	if away {
+		a.away[client.PlayerID]++
+	} else if a.away[client.PlayerID]--; a.away[client.PlayerID] == 0 {
+		delete(a.away, client.PlayerID)
+	}
+}
+
+// reply answers a register, unregister or away event with the player's
+// presence, telling the presence listener first if its status changed
+func (a *roomActor) reply(event roomEvent, before store.PresenceStatus) {
+	p := a.presence(event.client.PlayerID)
+	if p.Status != before {
+		a.hub.mu.RLock()
+		listener := a.hub.presenceListener
+		a.hub.mu.RUnlock()
+
+		if listener != nil {
+			listener(a.id, event.client.PlayerID, p)
+		}
+	}
+	event.done <- p
+}
 
-func GetHub() *Hub {
-	return hub
+// remove stops delivering to a client
+func (a *roomActor) remove(client *Client) {
+	delete(a.clients[client.PlayerID], client)
+	if len(a.clients[client.PlayerID]) == 0 {
+		delete(a.clients, client.PlayerID)
+	}
+	a.count.Add(-1)
 }
 
-func InitHub() {
-	go hub.Run()
-	log.Println("WebSocket Hub initialized and running")
+// release drops one client reference and reports whether the actor has
+// retired. Broadcasts still in the inbox of a retired actor had nobody to
+// go to.
+func (a *roomActor) release() bool {
+	a.hub.mu.Lock()
+	defer a.hub.mu.Unlock()
+
+	a.refs--
+	if a.refs > 0 {
+		return false
+	}
+	delete(a.hub.rooms, a.id)
+	return true
+}
+
+// send queues a message for a client, in the legacy format if it has one
+// and the client needs it. Room events the client has already been sent are
+// skipped, and a client whose buffer is full is evicted.
+func (a *roomActor) send(client *Client, seq uint64, message []byte, legacy []byte) {
+	if seq != 0 {
+		if seq <= client.lastSeq {
+			return
+		}
+		client.lastSeq = seq
+	}
+
+	if client.Legacy && legacy != nil {
+		message = legacy
+	}
+
+	select {
+	case client.Send <- message:
+	default:
+		a.evict(client)
+	}
+}
+
+// evict stops delivering to a client that can't keep up and asks its
+// writePump to close the connection
+func (a *roomActor) evict(client *Client) {
+	a.hub.evicted.Add(1)
+	log.Printf("Evicting slow client from room %s (PlayerID: %s)", a.id, client.PlayerID)
+	a.close(client, websocket.FormatCloseMessage(CloseSlowConsumer, "too slow"))
+}
+
+// close removes a client from delivery and hands its writePump a close
+// frame. The client still unregisters itself when its readPump ends.
+func (a *roomActor) close(client *Client, frame []byte) {
+	a.remove(client)
+
+	select {
+	case client.closing <- frame:
+	default:
+	}
 }
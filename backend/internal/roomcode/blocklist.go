@@ -0,0 +1,27 @@
+package roomcode
+
+import "strings"
+
+// blockedWords are never spelled by a code, anywhere in it
+var blockedWords = []string{
+	"ASS", "BUTT", "COCK", "CUM", "CUNT", "DICK", "DIK", "FAG", "FUC", "FUK",
+	"JIZ", "KKK", "NAZI", "NIG", "PISS", "POO", "PORN", "RAPE", "SEX", "SHIT",
+	"SLUT", "TIT", "TWAT", "WANK", "WHORE", "XXX",
+}
+
+// lookalikes reads digits as the letters they are often used for
+var lookalikes = strings.NewReplacer(
+	"0", "O", "1", "I", "3", "E", "4", "A", "5", "S", "6", "G", "7", "T", "8", "B",
+)
+
+// blocked reports whether code spells a blocked word, ignoring case and
+// reading digits as letters
+func blocked(code string) bool {
+	code = lookalikes.Replace(strings.ToUpper(code))
+	for _, word := range blockedWords {
+		if strings.Contains(code, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package roomcode
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// DefaultAlphabet is the letters and digits without the ones that are easy
+// to mix up: 0 and O, 1, I and L
+const DefaultAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// DefaultLength is how many characters a code from the default space has
+const DefaultLength = 4
+
+// Charset is the codes of a fixed length drawn from an alphabet, leaving
+// out any that spell a blocked word
+type Charset struct {
+	alphabet string
+	length   int
+	size     uint64
+}
+
+// NewCharset returns the codes of length characters from alphabet, which
+// must be at least two distinct ASCII letters or digits
+func NewCharset(alphabet string, length int) (*Charset, error) {
+	if len(alphabet) < 2 {
+		return nil, errors.New("alphabet must have at least two characters")
+	}
+	for i, c := range []byte(alphabet) {
+		if !isAlphanumeric(c) {
+			return nil, fmt.Errorf("alphabet may only have letters and digits, not %q", c)
+		}
+		if strings.IndexByte(alphabet[:i], c) >= 0 {
+			return nil, fmt.Errorf("alphabet has %q more than once", c)
+		}
+	}
+	if length < 1 {
+		return nil, errors.New("length must be at least 1")
+	}
+
+	size, err := power(uint64(len(alphabet)), length)
+	if err != nil {
+		return nil, err
+	}
+	return &Charset{alphabet: alphabet, length: length, size: size}, nil
+}
+
+// Default returns the DefaultLength-character codes from DefaultAlphabet
+func Default() *Charset {
+	c, err := NewCharset(DefaultAlphabet, DefaultLength)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func (c *Charset) Size() uint64 {
+	return c.size
+}
+
+// Code spells n in base len(alphabet), most significant character first
+func (c *Charset) Code(n uint64) (string, bool) {
+	base := uint64(len(c.alphabet))
+	code := make([]byte, c.length)
+	for i := c.length - 1; i >= 0; i-- {
+		code[i] = c.alphabet[n%base]
+		n /= base
+	}
+	return string(code), !blocked(string(code))
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+// power returns base**exp, or an error if there are too many codes to
+// count
+func power(base uint64, exp int) (uint64, error) {
+	result := uint64(1)
+	for range exp {
+		hi, lo := bits.Mul64(result, base)
+		if hi != 0 {
+			return 0, errors.New("too many possible codes; use fewer characters or words")
+		}
+		result = lo
+	}
+	return result, nil
+}
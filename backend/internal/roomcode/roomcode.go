@@ -0,0 +1,76 @@
+// Package roomcode hands out the short codes rooms are known by, so that no
+// two live rooms ever share one
+package roomcode
+
+import (
+	"errors"
+	"math/rand/v2"
+)
+
+var (
+	// ErrExhausted is returned when every code in a space is in use
+	ErrExhausted = errors.New("every room code is in use")
+	// ErrTaken is what a claim returns for a code that is already in use
+	ErrTaken = errors.New("room code is taken")
+)
+
+const (
+	// randomAttempts is how many codes Allocate draws at random before it
+	// falls back to scanning the space
+	randomAttempts = 32
+	// scanLimit is how many codes the scan tries before Allocate gives up,
+	// so that a nearly full space that is too big to scan fails fast
+	scanLimit = 4096
+)
+
+// Space is a set of codes, numbered from 0 to Size()-1
+type Space interface {
+	Size() uint64
+	// Code returns code n, or false if it must never be handed out, for
+	// example because it spells a blocked word
+	Code(n uint64) (string, bool)
+}
+
+// Allocate hands claim codes from space until it accepts one, and returns
+// that code. claim returns ErrTaken for a code that is already in use; any
+// other error ends the allocation. Codes are drawn at random, and once the
+// space is too crowded for that up to scanLimit codes are scanned from a
+// random start. A space no bigger than that only fails with ErrExhausted
+// once every code has been tried; a bigger one may fail while a few codes
+// are still free, rather than claim its way through all of them.
+func Allocate(space Space, claim func(code string) error) (string, error) {
+	size := space.Size()
+	if size == 0 {
+		return "", ErrExhausted
+	}
+
+	try := func(n uint64) (string, bool, error) {
+		code, ok := space.Code(n)
+		if !ok {
+			return "", false, nil
+		}
+		if err := claim(code); errors.Is(err, ErrTaken) {
+			return "", false, nil
+		} else if err != nil {
+			return "", false, err
+		}
+		return code, true, nil
+	}
+
+	for range min(randomAttempts, size) {
+		if code, ok, err := try(rand.Uint64N(size)); ok || err != nil {
+			return code, err
+		}
+	}
+
+	n := rand.Uint64N(size)
+	for range min(scanLimit, size) {
+		if code, ok, err := try(n); ok || err != nil {
+			return code, err
+		}
+		if n++; n == size {
+			n = 0
+		}
+	}
+	return "", ErrExhausted
+}
@@ -0,0 +1,179 @@
+package roomcode
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// claimed is a set of live codes, claimed the way a RoomManager would
+type claimed struct {
+	codes map[string]bool
+	mu    sync.Mutex
+}
+
+func (c *claimed) claim(code string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.codes[code] {
+		return ErrTaken
+	}
+	c.codes[code] = true
+	return nil
+}
+
+func TestCharset(t *testing.T) {
+	space := Default()
+	if want := uint64(len(DefaultAlphabet) * len(DefaultAlphabet) * len(DefaultAlphabet) * len(DefaultAlphabet)); space.Size() != want {
+		t.Errorf("Expected %d codes, got %d", want, space.Size())
+	}
+
+	first, _ := space.Code(0)
+	last, _ := space.Code(space.Size() - 1)
+	if first != "AAAA" || last != "9999" {
+		t.Errorf("Expected codes AAAA to 9999, got %s to %s", first, last)
+	}
+
+	for _, c := range "0O1IL" {
+		if strings.ContainsRune(DefaultAlphabet, c) {
+			t.Errorf("Expected the default alphabet to leave out %q", c)
+		}
+	}
+
+	for _, tc := range []struct {
+		alphabet string
+		length   int
+	}{
+		{"A", 4},
+		{"ABCA", 4},
+		{"AB-", 4},
+		{DefaultAlphabet, 0},
+		{DefaultAlphabet, 20},
+	} {
+		if _, err := NewCharset(tc.alphabet, tc.length); err == nil {
+			t.Errorf("Expected NewCharset(%q, %d) to fail", tc.alphabet, tc.length)
+		}
+	}
+}
+
+func TestBlockedCodes(t *testing.T) {
+	space, err := NewCharset("ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockedCodes := 0
+	for n := range space.Size() {
+		code, ok := space.Code(n)
+		if !ok {
+			blockedCodes++
+		}
+		if ok != !blocked(code) {
+			t.Fatalf("Code %s: usable = %v", code, ok)
+		}
+	}
+	if blockedCodes == 0 {
+		t.Error("Expected some codes to be blocked")
+	}
+
+	for _, code := range []string{"SH1T", "XASS", "fuck"} {
+		if !blocked(code) {
+			t.Errorf("Expected %s to be blocked", code)
+		}
+	}
+	if blocked("ABCD") {
+		t.Error("Expected ABCD to be allowed")
+	}
+}
+
+func TestWords(t *testing.T) {
+	space, err := NewWords(DefaultWords, DefaultWordCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := uint64(len(DefaultWords))
+	if space.Size() != n*n*n {
+		t.Errorf("Expected every default word to be usable, got %d codes", space.Size())
+	}
+	code, _ := space.Code(space.Size() - 1)
+	if words := strings.Split(code, WordSeparator); len(words) != DefaultWordCount {
+		t.Errorf("Expected %d words, got %s", DefaultWordCount, code)
+	}
+
+	// Blocked words are left out of custom lists
+	space, err = NewWords([]string{"otter", "shitake", "panda"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if space.Size() != 4 {
+		t.Errorf("Expected 4 codes from two usable words, got %d", space.Size())
+	}
+	if code, _ := space.Code(1); code != "OTTER-PANDA" {
+		t.Errorf("Expected OTTER-PANDA, got %s", code)
+	}
+
+	for _, words := range [][]string{{"OTTER"}, {"OTTER", "OTTER"}, {"OTTER", "PAN DA"}} {
+		if _, err := NewWords(words, 2); err == nil {
+			t.Errorf("Expected NewWords(%q) to fail", words)
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	space, err := NewCharset("ABC", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := &claimed{codes: make(map[string]bool)}
+
+	// Every code is handed out once, however crowded the space gets
+	var wg sync.WaitGroup
+	for range space.Size() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := Allocate(space, live.claim); err != nil {
+				t.Errorf("Allocate failed with codes left: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(live.codes) != int(space.Size()) {
+		t.Errorf("Expected all %d codes to be live, got %d", space.Size(), len(live.codes))
+	}
+
+	if _, err := Allocate(space, live.claim); !errors.Is(err, ErrExhausted) {
+		t.Errorf("Expected ErrExhausted, got %v", err)
+	}
+
+	// A freed code is found again
+	delete(live.codes, "CB")
+	if code, err := Allocate(space, live.claim); err != nil || code != "CB" {
+		t.Errorf("Expected the freed code CB, got %q %v", code, err)
+	}
+
+	// A full space too big to scan gives up after a bounded number of claims
+	big, err := NewCharset(DefaultAlphabet, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := 0
+	_, err = Allocate(big, func(string) error {
+		claims++
+		return ErrTaken
+	})
+	if !errors.Is(err, ErrExhausted) {
+		t.Errorf("Expected ErrExhausted, got %v", err)
+	}
+	if claims > randomAttempts+scanLimit {
+		t.Errorf("Expected at most %d claims, got %d", randomAttempts+scanLimit, claims)
+	}
+
+	failure := errors.New("could not save")
+	_, err = Allocate(space, func(string) error { return failure })
+	if !errors.Is(err, failure) {
+		t.Errorf("Expected the claim's error, got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package roomcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// WordSeparator joins the words of a word code
+const WordSeparator = "-"
+
+// DefaultWordCount is how many words a code from DefaultWords has
+const DefaultWordCount = 3
+
+// DefaultWords are short, common words that are hard to misspell or mishear
+// as one another
+var DefaultWords = []string{
+	"ACORN", "ALPACA", "AMBER", "ANCHOR", "APPLE", "ARROW", "ASPEN", "ATLAS",
+	"BADGER", "BAGEL", "BAMBOO", "BANJO", "BASIL", "BEACON", "BISON", "BREEZE",
+	"BRICK", "BUCKET", "BUTLER", "CABIN", "CACTUS", "CAMEL", "CAMERA", "CANDLE",
+	"CANOE", "CANYON", "CARPET", "CARROT", "CASTLE", "CEDAR", "CHALK", "CHERRY",
+	"CHESS", "CIRCUS", "CLOUD", "CLOVER", "COBALT", "COCOA", "COMET", "COOKIE",
+	"CORAL", "COTTON", "COYOTE", "CRANE", "CRAYON", "DAISY", "DELTA", "DESERT",
+	"DINGO", "DONUT", "DRAGON", "EAGLE", "ECHO", "EMBER", "FALCON", "FERN",
+	"FIDDLE", "FJORD", "FLINT", "FLUTE", "FOREST", "FOSSIL", "FROST", "GALAXY",
+	"GARDEN", "GARLIC", "GECKO", "GINGER", "GOBLIN", "GROVE", "GRAVEL", "GUITAR",
+	"HAMMER", "HARBOR", "HAZEL", "HELMET", "HERON", "HIPPO", "HOCKEY", "HONEY",
+	"ICICLE", "IGLOO", "INDIGO", "IRIS", "ISLAND", "IVORY", "JACKAL", "JACKET",
+	"JADE", "JELLY", "JIGSAW", "JUICE", "JUNGLE", "KAYAK", "KETTLE", "KITE",
+	"KIWI", "KOALA", "LADDER", "LAGOON", "LAVA", "LEMON", "LEMUR", "LILAC",
+	"LINEN", "LION", "LLAMA", "LOCKET", "LOTUS", "MAGNET", "MAGPIE", "MANGO",
+	"MAPLE", "MARBLE", "MEADOW", "MELON", "MINT", "MIRROR", "MITTEN", "MOOSE",
+	"MOSAIC", "MUFFIN", "NECTAR", "NICKEL", "NOODLE", "NUTMEG", "OASIS", "OCEAN",
+	"OLIVE", "ONION", "ORBIT", "ORCHID", "OSPREY", "OTTER", "OYSTER", "PADDLE",
+	"PANDA", "PAPAYA", "PAPER", "PARCEL", "PARROT", "PEACH", "PEANUT", "PEBBLE",
+	"PEPPER", "PIANO", "PICKLE", "PILLOW", "PILOT", "PIRATE", "PLANET", "PLUM",
+	"POCKET", "PONY", "POPPY", "POTATO", "PRISM", "PUFFIN", "PUZZLE", "QUAIL",
+	"QUARTZ", "QUILL", "RABBIT", "RADISH", "RAVEN", "RIDDLE", "RIVER", "ROBIN",
+	"ROCKET", "SADDLE", "SAFARI", "SALMON", "SALSA", "SANDAL", "SATURN", "SCARF",
+	"SHADOW", "SHELL", "SIGNAL", "SILVER", "SKETCH", "SLOTH", "SOCKET", "SPHINX",
+	"SPIDER", "SPONGE", "SPADE", "SPRUCE", "SQUID", "STONE", "STORM", "SUGAR",
+	"SUMMER", "SUMMIT", "SUNSET", "TABLET", "TANGO", "TEAPOT", "TEMPLE", "TENNIS",
+	"TICKET", "TIGER", "TIMBER", "TOAST", "TOFU", "TOMATO", "TOPAZ", "TORCH",
+	"TOUCAN", "TRAIN", "TULIP", "TUNDRA", "TUNNEL", "TURTLE", "TWIG", "VALLEY",
+	"VELVET", "VIOLET", "VIOLIN", "WAFFLE", "WAGON", "WALNUT", "WALRUS", "WHALE",
+	"WILLOW", "WINTER", "WIZARD", "WOMBAT", "YOGURT", "ZEBRA", "ZENITH", "ZIGZAG",
+}
+
+// Words is the codes made of a fixed number of words from a list, joined
+// with WordSeparator
+type Words struct {
+	words []string
+	count int
+	size  uint64
+}
+
+// NewWords returns the codes of count words from words. The words are
+// upper-cased, must be letters only and distinct, and any that spell a
+// blocked word are left out.
+func NewWords(words []string, count int) (*Words, error) {
+	seen := make(map[string]bool, len(words))
+	list := make([]string, 0, len(words))
+	for _, word := range words {
+		word = strings.ToUpper(word)
+		if word == "" || strings.IndexFunc(word, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+			return nil, fmt.Errorf("word %q may only have letters", word)
+		}
+		if seen[word] {
+			return nil, fmt.Errorf("word %q is listed more than once", word)
+		}
+		seen[word] = true
+		if !blocked(word) {
+			list = append(list, word)
+		}
+	}
+	if len(list) < 2 {
+		return nil, errors.New("word list must have at least two usable words")
+	}
+	if count < 1 {
+		return nil, errors.New("word count must be at least 1")
+	}
+
+	size, err := power(uint64(len(list)), count)
+	if err != nil {
+		return nil, err
+	}
+	return &Words{words: list, count: count, size: size}, nil
+}
+
+func (w *Words) Size() uint64 {
+	return w.size
+}
+
+// Code spells n in base len(words), most significant word first
+func (w *Words) Code(n uint64) (string, bool) {
+	base := uint64(len(w.words))
+	code := make([]string, w.count)
+	for i := w.count - 1; i >= 0; i-- {
+		code[i] = w.words[n%base]
+		n /= base
+	}
+	return strings.Join(code, WordSeparator), true
+}
@@ -0,0 +1,117 @@
+package store
+
+import "sync"
+
+// DefaultEventBufferSize is how many recent events a room keeps for clients
+// that reconnect
+const DefaultEventBufferSize = 128
+
+// Event is one message sent to a room's clients, numbered in the order it
+// was sent. Sequence numbers start at 1 and are shared by the whole room,
+// so a player's stream skips the numbers of other players' private events.
+type Event struct {
+	Seq uint64
+	// PlayerID is set for a private event meant for one player only
+	PlayerID string
+	Data     []byte
+	// Legacy is Data in the deprecated wire format, for clients that
+	// haven't moved to the current one
+	Legacy []byte
+}
+
+// EventLog numbers a room's events and keeps the most recent ones in a
+// ring buffer so missed events can be replayed
+type EventLog struct {
+	buffer  []Event
+	start   int
+	count   int
+	lastSeq uint64
+	// reserved is the highest seq the log may hand out before reserve is
+	// asked for more. reserve is nil for a log that isn't persisted.
+	reserved uint64
+	reserve  func(upTo uint64) error
+	mu       sync.Mutex
+}
+
+func NewEventLog(size int) *EventLog {
+	return &EventLog{buffer: make([]Event, size)}
+}
+
+// Append gives the next event its sequence number, has encode fill in the
+// message carrying it, and stores the result. emit is called with the event
+// before the lock is released, so events are emitted in sequence order; it
+// must not block or touch the log.
+func (l *EventLog) Append(playerID string, encode func(event *Event) error, emit func(Event)) (Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	event := Event{Seq: l.lastSeq + 1, PlayerID: playerID}
+	if err := encode(&event); err != nil {
+		return Event{}, err
+	}
+	if l.reserve != nil && event.Seq > l.reserved {
+		if err := l.reserve(l.reserved + eventSeqLease); err != nil {
+			return Event{}, err
+		}
+		l.reserved += eventSeqLease
+	}
+
+	l.lastSeq++
+	if len(l.buffer) > 0 {
+		if l.count < len(l.buffer) {
+			l.buffer[(l.start+l.count)%len(l.buffer)] = event
+			l.count++
+		} else {
+			l.buffer[l.start] = event
+			l.start = (l.start + 1) % len(l.buffer)
+		}
+	}
+
+	if emit != nil {
+		emit(event)
+	}
+	return event, nil
+}
+
+// Since returns the events after seq that playerID is allowed to see. ok is
+// false if some of them have already left the buffer, or seq is ahead of
+// the log, in which case the caller has to start over from a snapshot.
+func (l *EventLog) Since(seq uint64, playerID string) (events []Event, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if seq > l.lastSeq {
+		return nil, false
+	}
+	if oldest := l.lastSeq - uint64(l.count) + 1; seq+1 < oldest {
+		return nil, false
+	}
+
+	for i := 0; i < l.count; i++ {
+		event := l.buffer[(l.start+i)%len(l.buffer)]
+		if event.Seq <= seq {
+			continue
+		}
+		if event.PlayerID == "" || event.PlayerID == playerID {
+			events = append(events, event)
+		}
+	}
+	return events, true
+}
+
+// LastSeq returns the sequence number of the most recent event, or 0
+func (l *EventLog) LastSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.lastSeq
+}
+
+// Reserved returns the highest seq the log has reserved, which no event
+// numbered before a restart can be above
+func (l *EventLog) Reserved() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.reserved
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+func appendEvent(t *testing.T, log *EventLog, playerID string) Event {
+	t.Helper()
+
+	event, err := log.Append(playerID, func(event *Event) error {
+		event.Data = []byte(fmt.Sprintf("event %d", event.Seq))
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	return event
+}
+
+func TestEventLogSince(t *testing.T) {
+	log := NewEventLog(4)
+
+	appendEvent(t, log, "")
+	appendEvent(t, log, "alice")
+	appendEvent(t, log, "bob")
+	if last := appendEvent(t, log, ""); last.Seq != 4 || string(last.Data) != "event 4" {
+		t.Fatalf("Expected event 4 to be numbered in its data, got %+v", last)
+	}
+
+	tests := []struct {
+		name     string
+		since    uint64
+		playerID string
+		wantSeqs []uint64
+		wantOK   bool
+	}{
+		{"everything", 0, "alice", []uint64{1, 2, 4}, true},
+		{"other player's private events", 0, "bob", []uint64{1, 3, 4}, true},
+		{"up to date", 4, "alice", nil, true},
+		{"ahead of the log", 5, "alice", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, ok := log.Since(tt.since, tt.playerID)
+			if ok != tt.wantOK {
+				t.Fatalf("Expected ok=%v, got %v", tt.wantOK, ok)
+			}
+			if len(events) != len(tt.wantSeqs) {
+				t.Fatalf("Expected seqs %v, got %+v", tt.wantSeqs, events)
+			}
+			for i, event := range events {
+				if event.Seq != tt.wantSeqs[i] {
+					t.Errorf("Expected seqs %v, got %+v", tt.wantSeqs, events)
+				}
+			}
+		})
+	}
+}
+
+func TestEventLogOverflow(t *testing.T) {
+	log := NewEventLog(3)
+	for i := 0; i < 5; i++ {
+		appendEvent(t, log, "")
+	}
+
+	// Events 1 and 2 have been pushed out
+	if _, ok := log.Since(1, "alice"); ok {
+		t.Error("Expected a client that last saw event 1 to have to resync")
+	}
+	events, ok := log.Since(2, "alice")
+	if !ok || len(events) != 3 || events[0].Seq != 3 || events[2].Seq != 5 {
+		t.Errorf("Expected events 3 to 5, got %+v (ok=%v)", events, ok)
+	}
+	if log.LastSeq() != 5 {
+		t.Errorf("Expected last seq 5, got %d", log.LastSeq())
+	}
+}
+
+func TestEventLogEmitsInOrder(t *testing.T) {
+	log := NewEventLog(DefaultEventBufferSize)
+	var emitted []uint64
+
+	failed := func(*Event) error { return fmt.Errorf("unencodable") }
+	if _, err := log.Append("", failed, func(e Event) { emitted = append(emitted, e.Seq) }); err == nil {
+		t.Fatal("Expected encode error to be returned")
+	}
+	for i := 0; i < 3; i++ {
+		log.Append("", func(*Event) error { return nil }, func(e Event) { emitted = append(emitted, e.Seq) })
+	}
+
+	// A failed append uses up no number
+	if fmt.Sprint(emitted) != "[1 2 3]" {
+		t.Errorf("Expected events 1 to 3 emitted, got %v", emitted)
+	}
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// ExpiryReason is why a room expired
+type ExpiryReason string
+
+const (
+	// ExpiryIdle is a room that went IdleTTL without any activity
+	ExpiryIdle ExpiryReason = "idle"
+	// ExpiryFinished is a room whose match finished FinishedTTL ago
+	ExpiryFinished ExpiryReason = "finished"
+)
+
+// ExpiryPolicy decides how long rooms are kept. A zero TTL never expires
+// rooms for that reason.
+type ExpiryPolicy struct {
+	// IdleTTL is how long a room is kept without any activity: no change to
+	// its state and nothing touching it
+	IdleTTL time.Duration
+	// FinishedTTL is how long a room is kept once its match has finished,
+	// however active it is
+	FinishedTTL time.Duration
+}
+
+// Touch records activity that doesn't change the room's state, such as a
+// chat message. Every committed Update counts as activity too.
+func (r *Room) Touch(at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if at.After(r.lastActive) {
+		r.lastActive = at
+	}
+}
+
+// Expiry returns when the room expires under policy and why. ok is false if
+// the policy never expires it as things stand.
+func (r *Room) Expiry(policy ExpiryPolicy) (at time.Time, reason ExpiryReason, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.expiry(policy)
+}
+
+// expiry is Expiry with r.mu held. The activity clocks are not saved, so
+// they start over when a room is restored.
+func (r *Room) expiry(policy ExpiryPolicy) (at time.Time, reason ExpiryReason, ok bool) {
+	state, err := r.current()
+	if err != nil {
+		state = r.state
+	}
+	if policy.IdleTTL > 0 {
+		at, reason, ok = r.lastActive.Add(policy.IdleTTL), ExpiryIdle, true
+	}
+	if policy.FinishedTTL > 0 && state.Status == PhaseFinished {
+		if finished := r.phaseSince.Add(policy.FinishedTTL); !ok || finished.Before(at) {
+			at, reason, ok = finished, ExpiryFinished, true
+		}
+	}
+	return at, reason, ok
+}
+
+// ExpireRoom deletes a room if it has expired under policy as of now, and
+// returns it and why. The check and the delete are atomic, so a room that
+// sees activity just as it is about to expire is kept. A room that has
+// expired but can't be deleted fails with ErrNotSaved and is kept.
+func (s *roomSet) ExpireRoom(id string, policy ExpiryPolicy, now time.Time) (*Room, ExpiryReason, error) {
+	var reason ExpiryReason
+	room, err := s.deleteIf(id, func(room *Room) error {
+		at, why, ok := room.expiry(policy)
+		if !ok || now.Before(at) {
+			return errNotExpired
+		}
+		reason = why
+		return nil
+	})
+	if errors.Is(err, errNotExpired) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return room, reason, nil
+}
+
+var errNotExpired = errors.New("room has not expired")
@@ -0,0 +1,345 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// DefaultSnapshotEvery is how many records the journal appends between
+	// snapshots
+	DefaultSnapshotEvery = 1000
+
+	// eventSeqLease is how many event seqs a room's event log reserves at a
+	// time. A restored room's events are numbered from past its reservation,
+	// so a client resuming from before the restart is never handed a seq
+	// that meant something else.
+	eventSeqLease = 1024
+)
+
+// ErrNotSaved is returned when a room change could not be written to, or
+// the room read back from, the room's Repository. The change is not
+// applied.
+var ErrNotSaved = errors.New("room change could not be saved")
+
+var errJournalClosed = errors.New("journal is closed")
+
+// JournalOptions tune a Journal
+type JournalOptions struct {
+	// SnapshotEvery is how many records are appended between snapshots.
+	// Zero means DefaultSnapshotEvery.
+	SnapshotEvery int
+	// NoSync stops each record being fsynced before its change is applied.
+	// A record then survives the process being killed, but the changes of
+	// the last few seconds may be lost if the machine loses power.
+	NoSync bool
+}
+
+// Recovery describes what OpenJournal restored
+type Recovery struct {
+	Rooms int
+	// Replayed is how many log records were applied on top of the snapshot
+	Replayed int
+	// Discarded is how many bytes of log were thrown away because they
+	// were torn or corrupt. Everything from the first bad record on is
+	// discarded, so rooms come back as they were when the last intact
+	// record was written.
+	Discarded int64
+}
+
+// Journal is a Repository that keeps rooms in memory and on disk. Every
+// change is appended to a write-ahead log before it is made, and every so
+// often all rooms are written to a snapshot so the log can start over.
+type Journal struct {
+	dir  string
+	opts JournalOptions
+	// rooms is the latest state of every room. Its lock is held while a
+	// change is logged, and taken before mu.
+	rooms *MemoryRepository
+
+	// mu guards the open segment
+	mu           sync.Mutex
+	file         *os.File
+	size         int64
+	segmentFirst uint64
+	lastSeq      uint64
+	appended     int
+	// broken is set once the log can no longer be appended to
+	broken error
+
+	snapshotMu sync.Mutex
+	trigger    chan struct{}
+	done       chan struct{}
+	stopped    chan struct{}
+	closeOnce  sync.Once
+}
+
+// OpenJournal opens the journal in dir, creating dir if it doesn't exist,
+// and restores the rooms saved there. A torn or corrupt log tail is set
+// aside with a .corrupt suffix instead of failing the open.
+func OpenJournal(dir string, opts JournalOptions) (*Journal, Recovery, error) {
+	if opts.SnapshotEvery <= 0 {
+		opts.SnapshotEvery = DefaultSnapshotEvery
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, Recovery{}, err
+	}
+
+	snap, err := readSnapshot(dir)
+	if err != nil {
+		return nil, Recovery{}, fmt.Errorf("reading snapshot: %w", err)
+	}
+	rooms := make(roomTable, len(snap.Rooms))
+	for i := range snap.Rooms {
+		rooms[snap.Rooms[i].ID] = &snap.Rooms[i]
+	}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, Recovery{}, err
+	}
+
+	var recovery Recovery
+	lastSeq := snap.Seq
+	corrupt := len(segments)
+	for i, segment := range segments {
+		intact, size, err := readSegment(segment, func(record journalRecord) bool {
+			if record.Seq <= snap.Seq {
+				// Left behind by a crash between a snapshot and its cleanup
+				return true
+			}
+			if record.Seq != lastSeq+1 || !rooms.replay(record) {
+				return false
+			}
+			lastSeq = record.Seq
+			recovery.Replayed++
+			return true
+		})
+		if err != nil {
+			return nil, Recovery{}, err
+		}
+		if intact < size {
+			corrupt = i
+			recovery.Discarded += size - intact
+			break
+		}
+	}
+	for _, segment := range segments[min(corrupt+1, len(segments)):] {
+		if info, err := os.Stat(segment); err == nil {
+			recovery.Discarded += info.Size()
+		}
+	}
+
+	// Start over from a snapshot of what was recovered, so the old segments
+	// can go
+	if err := writeSnapshot(dir, snapshot{Seq: lastSeq, Rooms: rooms.sorted()}); err != nil {
+		return nil, Recovery{}, fmt.Errorf("writing snapshot: %w", err)
+	}
+	for i, segment := range segments {
+		if i >= corrupt {
+			err = os.Rename(segment, segment+corruptSuffix)
+		} else {
+			err = os.Remove(segment)
+		}
+		if err != nil {
+			return nil, Recovery{}, err
+		}
+	}
+
+	j := &Journal{
+		dir:     dir,
+		opts:    opts,
+		rooms:   &MemoryRepository{rooms: rooms},
+		lastSeq: lastSeq,
+		trigger: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err := j.openSegment(lastSeq + 1); err != nil {
+		return nil, Recovery{}, err
+	}
+	recovery.Rooms = len(rooms)
+
+	go j.run()
+	return j, recovery, nil
+}
+
+// openSegment starts a new log segment whose first record will be firstSeq.
+// Must be called with j.mu held, or before the journal is in use.
+func (j *Journal) openSegment(firstSeq uint64) error {
+	file, err := os.OpenFile(filepath.Join(j.dir, segmentName(firstSeq)), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(j.dir); err != nil {
+		file.Close()
+		return err
+	}
+
+	j.file = file
+	j.size = 0
+	j.segmentFirst = firstSeq
+	return nil
+}
+
+// append writes a record to the log, numbering it
+func (j *Journal) append(record journalRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.broken != nil {
+		return j.broken
+	}
+
+	record.Seq = j.lastSeq + 1
+	frame, err := encodeFrame(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.file.Write(frame); err != nil {
+		// Cut off whatever part of the frame made it out, so the next
+		// record follows the last intact one
+		if truncErr := j.file.Truncate(j.size); truncErr != nil {
+			j.broken = truncErr
+		}
+		return err
+	}
+	if !j.opts.NoSync {
+		if err := j.file.Sync(); err != nil {
+			// The record may or may not be on disk now, so nothing more
+			// can safely be written after it
+			j.broken = err
+			return err
+		}
+	}
+
+	j.size += int64(len(frame))
+	j.lastSeq = record.Seq
+	j.appended++
+	if j.appended >= j.opts.SnapshotEvery {
+		select {
+		case j.trigger <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (j *Journal) LoadRooms() ([]StoredRoom, error) {
+	return j.rooms.LoadRooms()
+}
+
+func (j *Journal) LoadRoom(id string) (StoredRoom, error) {
+	return j.rooms.LoadRoom(id)
+}
+
+func (j *Journal) CreateRoom(id string, state RoomState) error {
+	return j.rooms.apply(journalRecord{Op: opCreate, RoomID: id, State: &state}, j.append)
+}
+
+func (j *Journal) SaveRoom(id string, state RoomState) error {
+	return j.rooms.apply(journalRecord{Op: opPut, RoomID: id, State: &state}, j.append)
+}
+
+func (j *Journal) DeleteRoom(id string) error {
+	return j.rooms.apply(journalRecord{Op: opDelete, RoomID: id}, j.append)
+}
+
+func (j *Journal) ReserveEvents(id string, upTo uint64) error {
+	return j.rooms.apply(journalRecord{Op: opEvents, RoomID: id, Events: upTo}, j.append)
+}
+
+// Snapshot writes every room to the snapshot and starts a new log segment,
+// removing the segments the snapshot covers. The journal takes one by
+// itself every SnapshotEvery records.
+func (j *Journal) Snapshot() error {
+	j.snapshotMu.Lock()
+	defer j.snapshotMu.Unlock()
+
+	// Holding the rooms' lock keeps the rooms and the log in step while
+	// the segment is switched
+	j.rooms.mu.Lock()
+	j.mu.Lock()
+	if j.broken != nil {
+		j.mu.Unlock()
+		j.rooms.mu.Unlock()
+		return j.broken
+	}
+	if j.lastSeq < j.segmentFirst {
+		// Nothing has been logged since the last snapshot
+		j.mu.Unlock()
+		j.rooms.mu.Unlock()
+		return nil
+	}
+	boundary := j.lastSeq
+	old := j.file
+	if err := j.openSegment(boundary + 1); err != nil {
+		j.mu.Unlock()
+		j.rooms.mu.Unlock()
+		return err
+	}
+	j.appended = 0
+	j.mu.Unlock()
+	snap := snapshot{Seq: boundary, Rooms: j.rooms.rooms.sorted()}
+	j.rooms.mu.Unlock()
+
+	old.Close()
+	if err := writeSnapshot(j.dir, snap); err != nil {
+		return err
+	}
+
+	segments, err := listSegments(j.dir)
+	if err != nil {
+		return err
+	}
+	current := filepath.Join(j.dir, segmentName(boundary+1))
+	for _, segment := range segments {
+		if segment < current {
+			if err := os.Remove(segment); err != nil {
+				return err
+			}
+		}
+	}
+	return syncDir(j.dir)
+}
+
+func (j *Journal) run() {
+	defer close(j.stopped)
+
+	for {
+		select {
+		case <-j.trigger:
+			if err := j.Snapshot(); err != nil {
+				log.Printf("Error taking room snapshot: %v", err)
+			}
+		case <-j.done:
+			return
+		}
+	}
+}
+
+// Close takes a final snapshot and closes the log. Changes after Close
+// fail.
+func (j *Journal) Close() error {
+	var err error
+	j.closeOnce.Do(func() {
+		close(j.done)
+		<-j.stopped
+
+		err = j.Snapshot()
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+
+		j.broken = errJournalClosed
+		if closeErr := j.file.Close(); err == nil {
+			err = closeErr
+		}
+	})
+	return err
+}
@@ -0,0 +1,341 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// crash stops a journal the way a killed process would: no final snapshot
+// and nothing flushed beyond what was already written
+func crash(j *Journal) {
+	j.closeOnce.Do(func() {
+		close(j.done)
+		<-j.stopped
+		j.file.Close()
+	})
+}
+
+// openJournal opens a journal in dir into a new RepositoryStore
+func openJournal(t *testing.T, dir string, opts JournalOptions) (*RepositoryStore, *Journal, Recovery) {
+	t.Helper()
+
+	journal, recovery, err := OpenJournal(dir, opts)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	rm, err := NewRepositoryStore(journal)
+	if err != nil {
+		t.Fatalf("NewRepositoryStore failed: %v", err)
+	}
+	return rm, journal, recovery
+}
+
+// roomStates encodes every room's state, keyed by room ID, so stores can
+// be compared
+func roomStates(t *testing.T, rm *RepositoryStore) string {
+	t.Helper()
+
+	states := make(map[string]RoomState)
+	for _, room := range rm.Rooms() {
+		states[room.ID] = room.Snapshot()
+	}
+	data, err := json.Marshal(states)
+	if err != nil {
+		t.Fatalf("Failed to encode rooms: %v", err)
+	}
+	return string(data)
+}
+
+// playMidRound builds a room that is partway through a two-round match:
+// one round scored, the second dealt and waiting on the Mantri's guess
+func playMidRound(t *testing.T, rm RoomStore, roomID string) {
+	t.Helper()
+
+	room, _ := rm.CreateRoom(roomID)
+	room.SetTotalRounds(2)
+	room.SetScoringPolicy("classic")
+	for _, id := range []string{"p1", "p2", "p3", "p4"} {
+		if err := room.AddPlayer(Player{Identity: Identity{ID: id, Name: "Player " + id}}); err != nil {
+			t.Fatalf("AddPlayer failed: %v", err)
+		}
+		if err := room.SetPresence(id, PresenceOnline, time.Now()); err != nil {
+			t.Fatalf("SetPresence failed: %v", err)
+		}
+	}
+
+	roles := []string{"Raja", "Mantri", "Chor", "Sipahi"}
+	deal := func() {
+		players := room.GetPlayers()
+		for i := range players {
+			players[i].Role = roles[i]
+		}
+		if err := room.Update(func(state *RoomState) error {
+			state.Players = players
+			return state.Transition(PhaseGuessing)
+		}); err != nil {
+			t.Fatalf("Failed to deal roles: %v", err)
+		}
+		roles = append(roles[1:], roles[0])
+	}
+
+	deal()
+	err := room.Update(func(state *RoomState) error {
+		for i := range state.Players {
+			state.Players[i].Score += 100 * (i + 1)
+		}
+		return state.CompleteRound()
+	})
+	if err != nil {
+		t.Fatalf("Failed to score round: %v", err)
+	}
+	deal()
+}
+
+func TestJournalRecoversRooms(t *testing.T) {
+	dir := t.TempDir()
+
+	rm, journal, _ := openJournal(t, dir, JournalOptions{})
+	playMidRound(t, rm, "GAME")
+	lobby, _ := rm.CreateRoom("LBBY")
+	lobby.AddPlayer(Player{Identity: Identity{ID: "host", Name: "Host"}})
+	lobby.SetLocked(true)
+	rm.CreateRoom("GONE")
+	rm.DeleteRoom("GONE")
+	want := roomStates(t, rm)
+	crash(journal)
+
+	restored, journal, recovery := openJournal(t, dir, JournalOptions{})
+	defer journal.Close()
+
+	if got := roomStates(t, restored); got != want {
+		t.Errorf("Recovered rooms differ\nwant %s\ngot  %s", want, got)
+	}
+	if recovery.Rooms != 2 || recovery.Discarded != 0 {
+		t.Errorf("Expected 2 rooms and nothing discarded, got %+v", recovery)
+	}
+	if restored.GetRoom("GONE") != nil {
+		t.Error("Expected the deleted room to stay deleted")
+	}
+
+	// The restored rooms keep being journaled
+	if err := restored.GetRoom("LBBY").SetReady("host", true); err != nil {
+		t.Fatalf("SetReady failed: %v", err)
+	}
+	want = roomStates(t, restored)
+	crash(journal)
+
+	again, journal, _ := openJournal(t, dir, JournalOptions{})
+	defer journal.Close()
+	if got := roomStates(t, again); got != want {
+		t.Errorf("Rooms changed after a restore were not recovered\nwant %s\ngot  %s", want, got)
+	}
+}
+
+func TestJournalSnapshots(t *testing.T) {
+	dir := t.TempDir()
+
+	rm, journal, _ := openJournal(t, dir, JournalOptions{SnapshotEvery: 5})
+	for _, id := range []string{"AAAA", "BBBB", "CCCC"} {
+		playMidRound(t, rm, id)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if snap, _ := readSnapshot(dir); snap.Seq > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the journal to snapshot by itself")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rm.DeleteRoom("BBBB")
+	want := roomStates(t, rm)
+	crash(journal)
+
+	restored, journal, _ := openJournal(t, dir, JournalOptions{})
+	defer journal.Close()
+	if got := roomStates(t, restored); got != want {
+		t.Errorf("Recovered rooms differ\nwant %s\ngot  %s", want, got)
+	}
+
+	if err := journal.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	restored.GetRoom("AAAA").SetLocked(true)
+	want = roomStates(t, restored)
+	crash(journal)
+
+	if segments, _ := listSegments(dir); len(segments) != 1 {
+		t.Errorf("Expected the snapshot to leave one segment, got %v", segments)
+	}
+
+	again, journal, recovery := openJournal(t, dir, JournalOptions{})
+	defer journal.Close()
+	if got := roomStates(t, again); got != want {
+		t.Errorf("Recovered rooms differ\nwant %s\ngot  %s", want, got)
+	}
+	if recovery.Replayed != 1 {
+		t.Errorf("Expected only the change after the snapshot to be replayed, got %d", recovery.Replayed)
+	}
+}
+
+func TestJournalCorruption(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Corrupt func(data []byte) []byte
+	}{
+		{"Torn final record", func(data []byte) []byte { return data[:len(data)-3] }},
+		{"Garbage after the log", func(data []byte) []byte { return append(data, "not a frame"...) }},
+		{"Flipped byte in the final record", func(data []byte) []byte {
+			data[len(data)-2] ^= 0xff
+			return data
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			rm, journal, _ := openJournal(t, dir, JournalOptions{})
+			room, _ := rm.CreateRoom("ROOM")
+			room.AddPlayer(Player{Identity: Identity{ID: "p1", Name: "One"}})
+			want := roomStates(t, rm)
+			room.AddPlayer(Player{Identity: Identity{ID: "p2", Name: "Two"}})
+			crash(journal)
+
+			segments, _ := listSegments(dir)
+			data, _ := os.ReadFile(segments[0])
+			os.WriteFile(segments[0], tc.Corrupt(data), 0o644)
+
+			restored, journal, recovery := openJournal(t, dir, JournalOptions{})
+			if recovery.Discarded == 0 {
+				t.Error("Expected the bad tail to be discarded")
+			}
+			if tc.Name != "Garbage after the log" {
+				if got := roomStates(t, restored); got != want {
+					t.Errorf("Expected rooms as of the last intact record\nwant %s\ngot  %s", want, got)
+				}
+			}
+			if _, err := os.Stat(segments[0] + corruptSuffix); err != nil {
+				t.Errorf("Expected the corrupt segment to be set aside: %v", err)
+			}
+
+			// New records land after the intact ones and are recovered
+			restored.GetRoom("ROOM").SetLocked(true)
+			want = roomStates(t, restored)
+			crash(journal)
+
+			again, journal, recovery := openJournal(t, dir, JournalOptions{})
+			defer journal.Close()
+			if got := roomStates(t, again); got != want {
+				t.Errorf("Changes after recovering from corruption were lost\nwant %s\ngot  %s", want, got)
+			}
+			if recovery.Discarded != 0 {
+				t.Errorf("Expected a clean log after recovery, discarded %d bytes", recovery.Discarded)
+			}
+		})
+	}
+}
+
+func TestJournalEventSeqsSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	rm, journal, _ := openJournal(t, dir, JournalOptions{})
+	room, _ := rm.CreateRoom("ROOM")
+	for i := 0; i < 3; i++ {
+		room.Events().Append("", func(*Event) error { return nil }, nil)
+	}
+	crash(journal)
+
+	restored, journal, _ := openJournal(t, dir, JournalOptions{})
+	defer journal.Close()
+
+	event, err := restored.GetRoom("ROOM").Events().Append("", func(*Event) error { return nil }, nil)
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if event.Seq <= 3 {
+		t.Errorf("Expected events after a restart to be numbered past the old ones, got %d", event.Seq)
+	}
+	if _, ok := restored.GetRoom("ROOM").Events().Since(2, ""); ok {
+		t.Error("Expected a resume from before the restart to need a snapshot")
+	}
+}
+
+func TestJournalRefusesChangesItCannotSave(t *testing.T) {
+	rm, journal, _ := openJournal(t, t.TempDir(), JournalOptions{})
+	room, _ := rm.CreateRoom("ROOM")
+	journal.Close()
+
+	err := room.AddPlayer(Player{Identity: Identity{ID: "p1", Name: "One"}})
+	if !errors.Is(err, ErrNotSaved) {
+		t.Fatalf("Expected ErrNotSaved, got %v", err)
+	}
+	if len(room.GetPlayers()) != 0 {
+		t.Error("Expected the unsaved change not to be applied")
+	}
+	if err := room.SetLocked(true); !errors.Is(err, ErrNotSaved) {
+		t.Errorf("Expected ErrNotSaved from SetLocked, got %v", err)
+	}
+
+	if _, err := rm.DeleteRoom("ROOM"); !errors.Is(err, ErrNotSaved) {
+		t.Fatalf("Expected ErrNotSaved from DeleteRoom, got %v", err)
+	}
+	if rm.GetRoom("ROOM") != room || rm.Count() != 1 {
+		t.Error("Expected a room that couldn't be deleted to be kept")
+	}
+}
+
+// TestJournalKillMidRound kills a process holding a room mid-round and
+// checks that restarting restores the room exactly. The test runs itself
+// as the process to kill.
+func TestJournalKillMidRound(t *testing.T) {
+	if dir := os.Getenv("JOURNAL_CRASH_DIR"); dir != "" {
+		journal, _, err := OpenJournal(dir, JournalOptions{SnapshotEvery: 7})
+		if err != nil {
+			os.Exit(1)
+		}
+		rm, err := NewRepositoryStore(journal)
+		if err != nil {
+			os.Exit(1)
+		}
+		playMidRound(t, rm, "KILL")
+		os.Stdout.WriteString(roomStates(t, rm) + "\n")
+		select {}
+	}
+
+	dir := filepath.Join(t.TempDir(), "rooms")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestJournalKillMidRound$")
+	cmd.Env = append(os.Environ(), "JOURNAL_CRASH_DIR="+dir)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start the process to kill: %v", err)
+	}
+
+	want, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		cmd.Process.Kill()
+		t.Fatalf("The process to kill never reached mid-round: %v", err)
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	rm, journal, _ := openJournal(t, dir, JournalOptions{})
+	defer journal.Close()
+
+	if got := roomStates(t, rm); got+"\n" != want {
+		t.Errorf("Room after the restart differs\nwant %s\ngot  %s", want, got)
+	}
+	if status := rm.GetRoom("KILL").GetStatus(); status != PhaseGuessing {
+		t.Errorf("Expected the room to still be mid-round, got %s", status)
+	}
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"sort"
+	"sync"
+)
+
+// roomTable is rooms by ID, as the in-memory repository keeps them
+type roomTable map[string]*StoredRoom
+
+// replay makes the change a record describes. The record has already been
+// checked, or comes from the log where it was checked before it was
+// written. It returns false for a record that makes no sense, which the
+// log treats like a corrupt one.
+func (t roomTable) replay(record journalRecord) bool {
+	room := t[record.RoomID]
+	switch record.Op {
+	case opCreate:
+		if record.State == nil {
+			return false
+		}
+		t[record.RoomID] = &StoredRoom{ID: record.RoomID, State: record.State.clone()}
+	case opPut:
+		if record.State == nil || room == nil {
+			return false
+		}
+		room.State = record.State.clone()
+	case opDelete:
+		delete(t, record.RoomID)
+	case opEvents:
+		// A room's old event log can still reserve after the room is gone
+		if room != nil && record.Events > room.EventsReserved {
+			room.EventsReserved = record.Events
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// sorted returns a copy of every room, ordered by ID
+func (t roomTable) sorted() []StoredRoom {
+	rooms := make([]StoredRoom, 0, len(t))
+	for _, room := range t {
+		stored := *room
+		stored.State = room.State.clone()
+		rooms = append(rooms, stored)
+	}
+	sort.Slice(rooms, func(i, k int) bool {
+		return rooms[i].ID < rooms[k].ID
+	})
+	return rooms
+}
+
+// MemoryRepository keeps rooms in a map, so they last as long as the
+// process. A Journal keeps its rooms in one between snapshots.
+type MemoryRepository struct {
+	rooms roomTable
+	mu    sync.Mutex
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{rooms: make(roomTable)}
+}
+
+// apply checks a change against the stored rooms, has commit make it
+// durable if commit isn't nil, then makes it. The lock is held throughout,
+// so changes are committed in the order they are made.
+func (m *MemoryRepository) apply(record journalRecord, commit func(record journalRecord) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room := m.rooms[record.RoomID]
+	switch record.Op {
+	case opCreate:
+		if room != nil {
+			return ErrRoomExists
+		}
+	case opPut:
+		if room == nil {
+			return ErrRoomNotFound
+		}
+		if record.State.Version != room.State.Version+1 {
+			return ErrVersionConflict
+		}
+	case opDelete:
+		if room == nil {
+			return ErrRoomNotFound
+		}
+	case opEvents:
+		if room == nil || record.Events <= room.EventsReserved {
+			return nil
+		}
+	}
+
+	if commit != nil {
+		if err := commit(record); err != nil {
+			return err
+		}
+	}
+	m.rooms.replay(record)
+	return nil
+}
+
+func (m *MemoryRepository) LoadRooms() ([]StoredRoom, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.rooms.sorted(), nil
+}
+
+func (m *MemoryRepository) LoadRoom(id string) (StoredRoom, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room := m.rooms[id]
+	if room == nil {
+		return StoredRoom{}, ErrRoomNotFound
+	}
+	stored := *room
+	stored.State = room.State.clone()
+	return stored, nil
+}
+
+func (m *MemoryRepository) CreateRoom(id string, state RoomState) error {
+	return m.apply(journalRecord{Op: opCreate, RoomID: id, State: &state}, nil)
+}
+
+func (m *MemoryRepository) SaveRoom(id string, state RoomState) error {
+	return m.apply(journalRecord{Op: opPut, RoomID: id, State: &state}, nil)
+}
+
+func (m *MemoryRepository) DeleteRoom(id string) error {
+	return m.apply(journalRecord{Op: opDelete, RoomID: id}, nil)
+}
+
+func (m *MemoryRepository) ReserveEvents(id string, upTo uint64) error {
+	return m.apply(journalRecord{Op: opEvents, RoomID: id, Events: upTo}, nil)
+}
+
+func (m *MemoryRepository) Close() error {
+	return nil
+}
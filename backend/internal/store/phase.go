@@ -0,0 +1,73 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Phase is the stage of the game a room is in
+type Phase string
+
+const (
+	PhaseWaiting   Phase = "WAITING"
+	PhaseGuessing  Phase = "GUESSING"
+	PhaseRoundOver Phase = "ROUND_OVER"
+	PhaseFinished  Phase = "FINISHED"
+)
+
+// transitions lists the phases reachable from each phase. A match returns
+// to WAITING when a player leaves mid-match, so their seat can be refilled.
+var transitions = map[Phase][]Phase{
+	PhaseWaiting:   {PhaseGuessing},
+	PhaseGuessing:  {PhaseRoundOver, PhaseFinished, PhaseWaiting},
+	PhaseRoundOver: {PhaseGuessing, PhaseWaiting},
+	PhaseFinished:  {},
+}
+
+// ErrPhaseConflict is matched by every error caused by a room being in the
+// wrong phase for the requested operation
+var ErrPhaseConflict = errors.New("room is in the wrong phase")
+
+// TransitionError is returned when a room is asked to move to a phase that
+// is not reachable from its current one
+type TransitionError struct {
+	From Phase
+	To   Phase
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot move room from %s to %s", e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return ErrPhaseConflict
+}
+
+// PhaseError is returned when an operation is not allowed in the room's
+// current phase
+type PhaseError struct {
+	Op    string
+	Phase Phase
+}
+
+func (e *PhaseError) Error() string {
+	return fmt.Sprintf("cannot %s while room is %s", e.Op, e.Phase)
+}
+
+func (e *PhaseError) Unwrap() error {
+	return ErrPhaseConflict
+}
+
+// CanTransition reports whether the transition table allows moving from one
+// phase to another
+func CanTransition(from Phase, to Phase) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// PhaseListener is notified after a room has changed phase
+type PhaseListener func(roomID string, from Phase, to Phase)
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	testCases := []struct {
+		From    Phase
+		To      Phase
+		Allowed bool
+	}{
+		{PhaseWaiting, PhaseGuessing, true},
+		{PhaseGuessing, PhaseRoundOver, true},
+		{PhaseGuessing, PhaseFinished, true},
+		{PhaseRoundOver, PhaseGuessing, true},
+		{PhaseGuessing, PhaseWaiting, true},
+		{PhaseRoundOver, PhaseWaiting, true},
+		{PhaseWaiting, PhaseFinished, false},
+		{PhaseGuessing, PhaseGuessing, false},
+		{PhaseRoundOver, PhaseFinished, false},
+		{PhaseFinished, PhaseGuessing, false},
+		{PhaseFinished, PhaseWaiting, false},
+		{Phase("PLAYING"), PhaseGuessing, false},
+	}
+
+	for _, tc := range testCases {
+		if got := CanTransition(tc.From, tc.To); got != tc.Allowed {
+			t.Errorf("%s -> %s: expected %v, got %v", tc.From, tc.To, tc.Allowed, got)
+		}
+	}
+}
+
+// transition moves room to phase to through Update, as the game does
+func transition(room *Room, to Phase) error {
+	return room.Update(func(state *RoomState) error {
+		return state.Transition(to)
+	})
+}
+
+// hostID returns the ID of room's host
+func hostID(room *Room) string {
+	state := room.Snapshot()
+	return state.HostID()
+}
+
+func TestIllegalTransitionError(t *testing.T) {
+	rm := NewRoomManager()
+	room, _ := rm.CreateRoom("ILLEGAL")
+
+	err := transition(room, PhaseFinished)
+	if err == nil {
+		t.Fatal("Expected error for WAITING -> FINISHED")
+	}
+
+	var transitionErr *TransitionError
+	if !errors.As(err, &transitionErr) {
+		t.Fatalf("Expected *TransitionError, got %T", err)
+	}
+	if transitionErr.From != PhaseWaiting || transitionErr.To != PhaseFinished {
+		t.Errorf("Unexpected transition in error: %s -> %s", transitionErr.From, transitionErr.To)
+	}
+	if !errors.Is(err, ErrPhaseConflict) {
+		t.Error("Expected error to match ErrPhaseConflict")
+	}
+
+	if room.GetStatus() != PhaseWaiting {
+		t.Errorf("Expected status to remain WAITING, got %s", room.GetStatus())
+	}
+}
+
+func TestPhaseListener(t *testing.T) {
+	rm := NewRoomManager()
+
+	type change struct {
+		RoomID string
+		From   Phase
+		To     Phase
+	}
+	var changes []change
+	rm.OnPhaseChange(func(roomID string, from Phase, to Phase) {
+		changes = append(changes, change{roomID, from, to})
+	})
+
+	room, _ := rm.CreateRoom("LISTEN")
+	room.AddPlayer(Player{Identity: Identity{ID: "p1"}})
+
+	transition(room, PhaseGuessing)
+	transition(room, PhaseGuessing) // illegal, must not be reported
+	room.Update(func(state *RoomState) error {
+		return state.CompleteRound()
+	})
+
+	expected := []change{
+		{"LISTEN", PhaseWaiting, PhaseGuessing},
+		{"LISTEN", PhaseGuessing, PhaseFinished},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d phase changes, got %d: %v", len(expected), len(changes), changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Change %d: expected %v, got %v", i, expected[i], changes[i])
+		}
+	}
+}
+
+func TestAddPlayerAfterStart(t *testing.T) {
+	rm := NewRoomManager()
+	room, _ := rm.CreateRoom("LATE")
+	room.AddPlayer(Player{Identity: Identity{ID: "p1"}})
+	transition(room, PhaseGuessing)
+
+	err := room.AddPlayer(Player{Identity: Identity{ID: "p2"}})
+	if !errors.Is(err, ErrPhaseConflict) {
+		t.Fatalf("Expected phase conflict, got %v", err)
+	}
+	if len(room.GetPlayers()) != 1 {
+		t.Errorf("Expected late player to be rejected, got %d players", len(room.GetPlayers()))
+	}
+}
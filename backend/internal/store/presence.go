@@ -0,0 +1,37 @@
+package store
+
+import "time"
+
+// PresenceStatus is whether a seated player is connected to the room
+type PresenceStatus string
+
+const (
+	PresenceOnline PresenceStatus = "online"
+	// PresenceAway is a player who is connected but has told us they
+	// aren't looking, for example because their tab is in the background
+	PresenceAway PresenceStatus = "away"
+	// PresenceReconnecting is a player who lost their last connection and
+	// whose seat is held for a grace period while they come back
+	PresenceReconnecting PresenceStatus = "reconnecting"
+	PresenceDisconnected PresenceStatus = "disconnected"
+)
+
+// Presence is a seated player's connection state. LastSeen is when it last
+// changed, so for a disconnected player it is when they were last connected;
+// it is zero for a player who has never connected.
+type Presence struct {
+	Status   PresenceStatus
+	LastSeen time.Time
+}
+
+// SetPresence records a seated player's presence as of at
+func (r *Room) SetPresence(playerID string, status PresenceStatus, at time.Time) error {
+	return r.Update(func(state *RoomState) error {
+		i := state.playerIndex(playerID)
+		if i < 0 {
+			return ErrPlayerNotFound
+		}
+		state.Players[i].Presence = Presence{Status: status, LastSeen: at}
+		return nil
+	})
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrRoomExists   = errors.New("room already exists")
+	ErrRoomNotFound = errors.New("room not found")
+	// ErrVersionConflict is returned when a room is saved from a copy that
+	// is no longer the latest
+	ErrVersionConflict = errors.New("room was changed concurrently")
+)
+
+// StoredRoom is a room as a Repository keeps it
+type StoredRoom struct {
+	ID    string    `json:"id"`
+	State RoomState `json:"state"`
+	// EventsReserved is the highest seq the room's event log has reserved.
+	// Events sent after a restart are numbered from past it.
+	EventsReserved uint64 `json:"events"`
+}
+
+// Repository is storage for rooms, such as a database, that a
+// RepositoryStore keeps its rooms in. With a durable Repository rooms
+// outlive the process.
+type Repository interface {
+	// LoadRooms returns every stored room, ordered by ID
+	LoadRooms() ([]StoredRoom, error)
+	// LoadRoom returns one stored room, or fails with ErrRoomNotFound
+	LoadRoom(id string) (StoredRoom, error)
+	// CreateRoom stores a new room, or fails with ErrRoomExists if the ID
+	// is taken
+	CreateRoom(id string, state RoomState) error
+	// SaveRoom replaces a room's state. state.Version must be one more than
+	// the stored version, or it fails with ErrVersionConflict, so a change
+	// made to a stale copy is never saved.
+	SaveRoom(id string, state RoomState) error
+	// DeleteRoom removes a room, or fails with ErrRoomNotFound
+	DeleteRoom(id string) error
+	// ReserveEvents raises the seq the room's event log has reserved to
+	// upTo. It never lowers it, and does nothing for a room that no longer
+	// exists.
+	ReserveEvents(id string, upTo uint64) error
+	Close() error
+}
+
+// RepositoryStore is a RoomStore that keeps its rooms in a Repository. It
+// reads a room's state from the Repository whenever the room is read or
+// changed, and saves every change to it before the change is committed, so
+// the Repository always holds the rooms as they are.
+type RepositoryStore struct {
+	roomSet
+}
+
+// NewRepositoryStore returns a RepositoryStore over repo, holding the rooms
+// repo already has
+func NewRepositoryStore(repo Repository) (*RepositoryStore, error) {
+	stored, err := repo.LoadRooms()
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &RepositoryStore{}
+	rs.init(DefaultShards, repo)
+	for _, room := range stored {
+		rs.shard(room.ID).rooms[room.ID] = rs.newRoom(room)
+	}
+	rs.count.Store(int64(len(stored)))
+	return rs, nil
+}
+
+// Close closes the store's Repository
+func (rs *RepositoryStore) Close() error {
+	return rs.repo.Close()
+}
+
+// notSaved marks an error from a Repository as a change that wasn't saved
+func notSaved(err error) error {
+	return fmt.Errorf("%w: %w", ErrNotSaved, err)
+}
@@ -0,0 +1,143 @@
+package store_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store/storetest"
+)
+
+func TestRoomManager(t *testing.T) {
+	storetest.RunRoomStore(t, func(t *testing.T) store.RoomStore {
+		return store.NewRoomManager()
+	})
+}
+
+func TestMemoryRepository(t *testing.T) {
+	storetest.Run(t, storetest.Backend{
+		Open: func(t *testing.T, dir string) store.Repository {
+			return store.NewMemoryRepository()
+		},
+	})
+}
+
+func TestJournalRepository(t *testing.T) {
+	storetest.Run(t, storetest.Backend{
+		Open: func(t *testing.T, dir string) store.Repository {
+			journal, _, err := store.OpenJournal(dir, store.JournalOptions{SnapshotEvery: 3})
+			if err != nil {
+				t.Fatalf("OpenJournal failed: %v", err)
+			}
+			return journal
+		},
+		Durable: true,
+	})
+}
+
+// TestRepositoryStore tests that a RepositoryStore saves every change
+// through to its repository, reads rooms back from it, and that another
+// store picks the rooms up
+func TestRepositoryStore(t *testing.T) {
+	repo := store.NewMemoryRepository()
+	rm, err := store.NewRepositoryStore(repo)
+	if err != nil {
+		t.Fatalf("NewRepositoryStore failed: %v", err)
+	}
+
+	room, err := rm.CreateRoom("ROOM")
+	if err != nil {
+		t.Fatalf("CreateRoom failed: %v", err)
+	}
+	if _, err := rm.CreateRoom("ROOM"); !errors.Is(err, store.ErrRoomExists) {
+		t.Errorf("Expected a taken ID to be refused, got %v", err)
+	}
+	room.AddPlayer(store.Player{Identity: store.Identity{ID: "p1", Name: "One"}})
+	room.SetLocked(true)
+
+	// A room is stored set up, or not at all
+	if _, err := rm.CreateRoomWith("HALF", func(state *store.RoomState) error {
+		state.PlayerCount = 0
+		return state.AddPlayer(store.Player{Identity: store.Identity{ID: "h", Name: "Host"}})
+	}); !errors.Is(err, store.ErrRoomFull) {
+		t.Errorf("Expected setup's error, got %v", err)
+	}
+	if rm.GetRoom("HALF") != nil {
+		t.Error("Expected a room whose setup failed not to be created")
+	}
+	if _, err := repo.LoadRoom("HALF"); !errors.Is(err, store.ErrRoomNotFound) {
+		t.Errorf("Expected a room whose setup failed not to be stored, got %v", err)
+	}
+	if _, err := rm.CreateRoomWith("FULL", func(state *store.RoomState) error {
+		state.TotalRounds = 5
+		return state.AddPlayer(store.Player{Identity: store.Identity{ID: "h", Name: "Host"}})
+	}); err != nil {
+		t.Fatalf("CreateRoomWith failed: %v", err)
+	}
+	if stored, err := repo.LoadRoom("FULL"); err != nil || stored.State.TotalRounds != 5 || stored.State.HostID() != "h" {
+		t.Errorf("Expected the room to be stored with its setup, got %+v (%v)", stored.State, err)
+	}
+	if _, err := rm.DeleteRoom("FULL"); err != nil {
+		t.Errorf("DeleteRoom failed: %v", err)
+	}
+
+	rm.CreateRoom("GONE")
+	rm.DeleteRoom("GONE")
+
+	stored, err := repo.LoadRoom("ROOM")
+	if err != nil {
+		t.Fatalf("LoadRoom failed: %v", err)
+	}
+	if stored.State.Version != room.Snapshot().Version || !stored.State.Locked || len(stored.State.Players) != 1 {
+		t.Errorf("Expected the repository to hold the room's latest state, got %+v", stored.State)
+	}
+
+	other, err := store.NewRepositoryStore(repo)
+	if err != nil {
+		t.Fatalf("NewRepositoryStore failed: %v", err)
+	}
+	if other.GetRoom("GONE") != nil || other.Count() != 1 {
+		t.Fatalf("Expected only ROOM to be loaded, got %d rooms", other.Count())
+	}
+	loaded := other.GetRoom("ROOM")
+	if players := loaded.GetPlayers(); len(players) != 1 || players[0].Permission != store.PermissionHost {
+		t.Errorf("Expected the loaded room to keep its host, got %+v", players)
+	}
+
+	// Both stores read the room from the repository, so each sees the
+	// other's changes and neither saves over them
+	if err := loaded.SetLocked(false); err != nil {
+		t.Fatalf("SetLocked failed: %v", err)
+	}
+	if state := room.Snapshot(); state.Locked || state.Version != loaded.Snapshot().Version {
+		t.Errorf("Expected the first store to read the change back, got %+v", state)
+	}
+	if err := room.SetReady("p1", true); err != nil {
+		t.Errorf("Expected a change on top of the other store's to be saved, got %v", err)
+	}
+	if players := loaded.GetPlayers(); !players[0].Ready {
+		t.Errorf("Expected the second store to read the change back, got %+v", players)
+	}
+}
+
+// TestRoomManagerKeepsNoRepository tests that the in-memory RoomManager
+// works without one
+func TestRoomManagerKeepsNoRepository(t *testing.T) {
+	rm := store.NewRoomManager()
+	room, err := rm.CreateRoom("ROOM")
+	if err != nil {
+		t.Fatalf("CreateRoom failed: %v", err)
+	}
+	if err := room.AddPlayer(store.Player{Identity: store.Identity{ID: "p1", Name: "One"}}); err != nil {
+		t.Fatalf("AddPlayer failed: %v", err)
+	}
+	if state := room.Snapshot(); state.Version != 1 || state.HostID() != "p1" {
+		t.Errorf("Expected the change to be committed in memory, got %+v", state)
+	}
+	if event, err := room.Events().Append("", func(*store.Event) error { return nil }, nil); err != nil || event.Seq != 1 {
+		t.Errorf("Expected events to be numbered without reserving them, got %d (%v)", event.Seq, err)
+	}
+	if _, err := rm.DeleteRoom("ROOM"); err != nil {
+		t.Errorf("DeleteRoom failed: %v", err)
+	}
+}
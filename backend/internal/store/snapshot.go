@@ -0,0 +1,69 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+const snapshotFileName = "snapshot.json"
+
+// snapshot is every room at some point in the write-ahead log. Seq is the
+// last record it includes; recovery replays the records after it.
+type snapshot struct {
+	Seq   uint64       `json:"seq"`
+	Rooms []StoredRoom `json:"rooms"`
+}
+
+// readSnapshot loads the snapshot in dir, or an empty one if there isn't
+// one yet. Snapshots are replaced atomically, so unlike the log a snapshot
+// that doesn't decode is an error rather than something to skip.
+func readSnapshot(dir string) (snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return snapshot{}, nil
+	}
+	if err != nil {
+		return snapshot{}, err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snapshot{}, err
+	}
+	return snap, nil
+}
+
+// writeSnapshot replaces the snapshot in dir. It is written to a temporary
+// file and renamed into place, so a crash leaves either the old snapshot or
+// the new one.
+func writeSnapshot(dir string, snap snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, snapshotFileName)
+	file, err := os.CreateTemp(dir, snapshotFileName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
@@ -0,0 +1,294 @@
+// Package sqlite is a store.Repository backed by an embedded SQLite
+// database, using a pure-Go driver so the server still builds without cgo.
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS rooms (
+	id                TEXT PRIMARY KEY,
+	status            TEXT NOT NULL,
+	total_rounds      INTEGER NOT NULL,
+	rounds_played     INTEGER NOT NULL,
+	scoring           TEXT NOT NULL,
+	player_count      INTEGER NOT NULL,
+	locked            INTEGER NOT NULL,
+	disconnect_policy TEXT NOT NULL,
+	version           INTEGER NOT NULL,
+	events_reserved   INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS players (
+	room_id    TEXT NOT NULL,
+	seat       INTEGER NOT NULL,
+	id         TEXT NOT NULL,
+	name       TEXT NOT NULL,
+	permission TEXT NOT NULL,
+	score      INTEGER NOT NULL,
+	ready      INTEGER NOT NULL,
+	role       TEXT NOT NULL,
+	presence   TEXT NOT NULL,
+	last_seen  INTEGER NOT NULL,
+	PRIMARY KEY (room_id, seat)
+);
+`
+
+const roomColumns = `id, status, total_rounds, rounds_played, scoring, player_count, locked, disconnect_policy, version, events_reserved`
+
+const playerColumns = `room_id, id, name, permission, score, ready, role, presence, last_seen`
+
+// Repository keeps rooms in a SQLite database, one row per room and one
+// per seated player
+type Repository struct {
+	db *sql.DB
+}
+
+// Open opens the database at path, creating it and its tables if needed.
+// ":memory:" opens a database that lasts as long as the Repository.
+func Open(path string) (*Repository, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time, and an in-memory database only
+	// exists on the connection that opened it
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Repository{db: db}, nil
+}
+
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+// scanner is a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRoom(row scanner) (store.StoredRoom, error) {
+	var room store.StoredRoom
+	var status string
+	err := row.Scan(
+		&room.ID,
+		&status,
+		&room.State.TotalRounds,
+		&room.State.RoundsPlayed,
+		&room.State.Scoring,
+		&room.State.PlayerCount,
+		&room.State.Locked,
+		&room.State.DisconnectPolicy,
+		&room.State.Version,
+		&room.EventsReserved,
+	)
+	room.State.Status = store.Phase(status)
+	room.State.Players = make([]store.Player, 0)
+	return room, err
+}
+
+func scanPlayer(row scanner) (string, store.Player, error) {
+	var roomID string
+	var p store.Player
+	var permission, presence string
+	var lastSeen int64
+	err := row.Scan(&roomID, &p.ID, &p.Name, &permission, &p.Score, &p.Ready, &p.Role, &presence, &lastSeen)
+	p.Permission = store.Permission(permission)
+	p.Presence = store.Presence{Status: store.PresenceStatus(presence), LastSeen: fromUnixNano(lastSeen)}
+	return roomID, p, err
+}
+
+// Times are stored as Unix nanoseconds, with 0 for the zero time
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+func (r *Repository) LoadRooms() ([]store.StoredRoom, error) {
+	rows, err := r.db.Query(`SELECT ` + roomColumns + ` FROM rooms ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []store.StoredRoom
+	byID := make(map[string]int)
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		byID[room.ID] = len(rooms)
+		rooms = append(rooms, room)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	players, err := r.db.Query(`SELECT ` + playerColumns + ` FROM players ORDER BY room_id, seat`)
+	if err != nil {
+		return nil, err
+	}
+	defer players.Close()
+
+	for players.Next() {
+		roomID, p, err := scanPlayer(players)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := byID[roomID]; ok {
+			rooms[i].State.Players = append(rooms[i].State.Players, p)
+		}
+	}
+	return rooms, players.Err()
+}
+
+func (r *Repository) LoadRoom(id string) (store.StoredRoom, error) {
+	room, err := scanRoom(r.db.QueryRow(`SELECT `+roomColumns+` FROM rooms WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return store.StoredRoom{}, store.ErrRoomNotFound
+	}
+	if err != nil {
+		return store.StoredRoom{}, err
+	}
+
+	players, err := r.db.Query(`SELECT `+playerColumns+` FROM players WHERE room_id = ? ORDER BY seat`, id)
+	if err != nil {
+		return store.StoredRoom{}, err
+	}
+	defer players.Close()
+
+	for players.Next() {
+		_, p, err := scanPlayer(players)
+		if err != nil {
+			return store.StoredRoom{}, err
+		}
+		room.State.Players = append(room.State.Players, p)
+	}
+	return room, players.Err()
+}
+
+// inTx runs fn in a transaction, committing it if fn returns nil
+func (r *Repository) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// storedVersion returns a room's version, or ErrRoomNotFound
+func storedVersion(tx *sql.Tx, id string) (uint64, error) {
+	var version uint64
+	err := tx.QueryRow(`SELECT version FROM rooms WHERE id = ?`, id).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, store.ErrRoomNotFound
+	}
+	return version, err
+}
+
+// writePlayers replaces a room's seated players
+func writePlayers(tx *sql.Tx, id string, players []store.Player) error {
+	if _, err := tx.Exec(`DELETE FROM players WHERE room_id = ?`, id); err != nil {
+		return err
+	}
+	for seat, p := range players {
+		_, err := tx.Exec(
+			`INSERT INTO players (room_id, seat, id, name, permission, score, ready, role, presence, last_seen)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, seat, p.ID, p.Name, string(p.Permission), p.Score, p.Ready, p.Role,
+			string(p.Presence.Status), unixNano(p.Presence.LastSeen),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) CreateRoom(id string, state store.RoomState) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		if _, err := storedVersion(tx, id); err == nil {
+			return store.ErrRoomExists
+		} else if !errors.Is(err, store.ErrRoomNotFound) {
+			return err
+		}
+
+		_, err := tx.Exec(
+			`INSERT INTO rooms (`+roomColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 0)`,
+			id, string(state.Status), state.TotalRounds, state.RoundsPlayed, state.Scoring,
+			state.PlayerCount, state.Locked, state.DisconnectPolicy, state.Version,
+		)
+		if err != nil {
+			return err
+		}
+		return writePlayers(tx, id, state.Players)
+	})
+}
+
+func (r *Repository) SaveRoom(id string, state store.RoomState) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		version, err := storedVersion(tx, id)
+		if err != nil {
+			return err
+		}
+		if state.Version != version+1 {
+			return store.ErrVersionConflict
+		}
+
+		_, err = tx.Exec(
+			`UPDATE rooms SET status = ?, total_rounds = ?, rounds_played = ?, scoring = ?,
+			player_count = ?, locked = ?, disconnect_policy = ?, version = ? WHERE id = ?`,
+			string(state.Status), state.TotalRounds, state.RoundsPlayed, state.Scoring,
+			state.PlayerCount, state.Locked, state.DisconnectPolicy, state.Version, id,
+		)
+		if err != nil {
+			return err
+		}
+		return writePlayers(tx, id, state.Players)
+	})
+}
+
+func (r *Repository) DeleteRoom(id string) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`DELETE FROM rooms WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return store.ErrRoomNotFound
+		}
+		_, err = tx.Exec(`DELETE FROM players WHERE room_id = ?`, id)
+		return err
+	})
+}
+
+func (r *Repository) ReserveEvents(id string, upTo uint64) error {
+	_, err := r.db.Exec(`UPDATE rooms SET events_reserved = ? WHERE id = ? AND events_reserved < ?`, upTo, id, upTo)
+	return err
+}
@@ -0,0 +1,34 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store/storetest"
+)
+
+func TestRepository(t *testing.T) {
+	storetest.Run(t, storetest.Backend{
+		Open: func(t *testing.T, dir string) store.Repository {
+			repo, err := Open(filepath.Join(dir, "rooms.db"))
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			return repo
+		},
+		Durable: true,
+	})
+}
+
+func TestInMemoryDatabase(t *testing.T) {
+	storetest.Run(t, storetest.Backend{
+		Open: func(t *testing.T, dir string) store.Repository {
+			repo, err := Open(":memory:")
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			return repo
+		},
+	})
+}
@@ -1,87 +1,743 @@
 package store
 
-import "sync"
+import (
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
+const (
+	DefaultRounds      = 1
+	MaxRounds          = 20
+	DefaultPlayerCount = 4
+)
+
+var (
+	ErrRoomLocked     = errors.New("room is locked")
+	ErrRoomFull       = errors.New("room is full")
+	ErrPlayerNotFound = errors.New("player is not in this room")
+	ErrNotHost        = errors.New("only the host can do that")
+	ErrCannotKickSelf = errors.New("the host cannot kick themselves")
+)
+
+// Identity is who a player is. It never changes while they're in the room.
+type Identity struct {
+	ID   string
+	Name string
+}
+
+// Permission decides which room actions a seated player may take
+type Permission string
+
+const (
+	PermissionHost   Permission = "host"
+	PermissionPlayer Permission = "player"
+)
+
+// Seat is a player's place in the room: what they're allowed to do and the
+// score they've built up over the match. It survives across rounds.
+type Seat struct {
+	Permission Permission
+	Score      int
+	Ready      bool
+}
+
+// Player is a seated player. Role is the game role (Raja, Mantri, ...) dealt
+// for the current round and is replaced every round; it carries no
+// permissions.
 type Player struct {
-	ID    string
-	Name  string
-	Role  string
-	Score int
+	Identity
+	Seat
+	Role     string
+	Presence Presence
+}
+
+// RoomState is the mutable part of a room. It is only ever modified as a
+// whole through Room.Update.
+type RoomState struct {
+	Players      []Player
+	Status       Phase
+	TotalRounds  int
+	RoundsPlayed int
+	Scoring      string
+	PlayerCount  int
+	Locked       bool
+	Version      uint64
+
+	// DisconnectPolicy names what happens to a round that is left waiting
+	// on a disconnected player
+	DisconnectPolicy string
 }
 
 type Room struct {
-	ID      string
-	Players []Player
-	Status  string
-	mu      sync.Mutex
+	ID string
+	// state is the room's state. For a room kept in a Repository it is the
+	// last state read from or saved to it.
+	state  RoomState
+	events *EventLog
+	owner  *roomSet
+	// repo is the Repository the room is kept in, or nil if it is only kept
+	// in memory. It is nil once the room is deleted.
+	repo Repository
+	// lastActive is when the room last saw activity, and phaseSince when it
+	// entered its current phase
+	lastActive time.Time
+	phaseSince time.Time
+	mu         sync.Mutex
 }
 
+// RoomStore is where the server keeps its rooms: their settings, the
+// players seated in them and the game in progress. The handlers find,
+// create and delete rooms only through it, and read and change a room
+// through the *Room it hands out. RoomManager keeps rooms in memory;
+// RepositoryStore keeps them in a Repository, such as a SQLite database.
+type RoomStore interface {
+	// CreateRoom adds a new room, or fails with ErrRoomExists if the ID is
+	// taken
+	CreateRoom(id string) (*Room, error)
+	// CreateRoomWith adds a new room like CreateRoom, first running setup
+	// on its initial state. If setup fails no room is created.
+	CreateRoomWith(id string, setup func(state *RoomState) error) (*Room, error)
+	// GetRoom returns a room, or nil if there is no such room
+	GetRoom(id string) *Room
+	// DeleteRoom removes a room and returns it, or nil if there was no such
+	// room
+	DeleteRoom(id string) (*Room, error)
+	// CloseRoom deletes a room on behalf of actorID, who must be its host
+	CloseRoom(id string, actorID string) (*Room, error)
+	// ExpireRoom deletes a room if it has expired under policy as of now,
+	// and returns it and why
+	ExpireRoom(id string, policy ExpiryPolicy, now time.Time) (*Room, ExpiryReason, error)
+	// Range calls fn for every room until fn returns false
+	Range(fn func(room *Room) bool)
+	// Count returns how many rooms there are
+	Count() int
+	// OnPhaseChange registers a listener that is called after any room
+	// changes phase
+	OnPhaseChange(listener PhaseListener)
+}
+
+// DefaultShards is how many shards a RoomManager splits its rooms into
+const DefaultShards = 64
+
+// RoomManager is a RoomStore that keeps its rooms in memory, split into
+// shards by a hash of the room ID so that requests for different rooms
+// rarely wait on the same lock. Nothing it does touches a Repository.
 type RoomManager struct {
+	roomSet
+}
+
+// roomSet is the rooms of a RoomManager or RepositoryStore, sharded by a
+// hash of the room ID, and the Repository they are kept in, if any
+type roomSet struct {
+	shards []roomShard
+	seed   maphash.Seed
+	count  atomic.Int64
+	repo   Repository
+
+	// mu guards the phase listener, which is set up before the rooms are
+	// in use
+	phaseListener PhaseListener
+	mu            sync.RWMutex
+}
+
+// roomShard is some of a store's rooms and the lock that guards them. The
+// padding keeps each shard's lock on its own cache line.
+type roomShard struct {
 	rooms map[string]*Room
 	mu    sync.RWMutex
+	_     [32]byte
 }
 
+// NewRoomManager returns a RoomManager with DefaultShards shards
 func NewRoomManager() *RoomManager {
-	return &RoomManager{
-		rooms: make(map[string]*Room),
+	return NewRoomManagerWithShards(DefaultShards)
+}
+
+// NewRoomManagerWithShards returns a RoomManager that splits its rooms into
+// the given number of shards. One shard puts every room behind a single
+// lock.
+func NewRoomManagerWithShards(shards int) *RoomManager {
+	rm := &RoomManager{}
+	rm.init(shards, nil)
+	return rm
+}
+
+// init sets up an empty set of rooms kept in repo, or only in memory if
+// repo is nil
+func (s *roomSet) init(shards int, repo Repository) {
+	s.shards = make([]roomShard, max(shards, 1))
+	s.seed = maphash.MakeSeed()
+	s.repo = repo
+	for i := range s.shards {
+		s.shards[i].rooms = make(map[string]*Room)
+	}
+}
+
+// shard returns the shard a room ID belongs to
+func (s *roomSet) shard(id string) *roomShard {
+	if len(s.shards) == 1 {
+		return &s.shards[0]
+	}
+	return &s.shards[maphash.String(s.seed, id)%uint64(len(s.shards))]
+}
+
+// newRoom returns the Room for a stored room. Its event log only reserves
+// sequence numbers in a Repository the room is kept in.
+func (s *roomSet) newRoom(stored StoredRoom) *Room {
+	events := NewEventLog(DefaultEventBufferSize)
+	events.lastSeq = stored.EventsReserved
+	events.reserved = stored.EventsReserved
+	if repo := s.repo; repo != nil {
+		events.reserve = func(upTo uint64) error {
+			return repo.ReserveEvents(stored.ID, upTo)
+		}
+	}
+
+	now := time.Now()
+	return &Room{
+		ID:         stored.ID,
+		state:      stored.State,
+		events:     events,
+		owner:      s,
+		repo:       s.repo,
+		lastActive: now,
+		phaseSince: now,
+	}
+}
+
+// OnPhaseChange registers a listener that is called after any room in the
+// store changes phase. It is called without any room lock held.
+func (s *roomSet) OnPhaseChange(listener PhaseListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.phaseListener = listener
+}
+
+// CreateRoom adds a new room, or fails with ErrRoomExists if the ID is
+// taken
+func (s *roomSet) CreateRoom(id string) (*Room, error) {
+	return s.CreateRoomWith(id, nil)
+}
+
+// CreateRoomWith adds a new room like CreateRoom, first running setup on
+// its initial state. The room is stored once, with setup's changes, so it
+// is never seen, or saved, half set up; if setup fails no room is created.
+func (s *roomSet) CreateRoomWith(id string, setup func(state *RoomState) error) (*Room, error) {
+	shard := s.shard(id)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if shard.rooms[id] != nil {
+		return nil, ErrRoomExists
+	}
+
+	stored := StoredRoom{
+		ID: id,
+		State: RoomState{
+			Players:     make([]Player, 0),
+			Status:      PhaseWaiting,
+			TotalRounds: DefaultRounds,
+			PlayerCount: DefaultPlayerCount,
+		},
+	}
+	if setup != nil {
+		if err := setup(&stored.State); err != nil {
+			return nil, err
+		}
+	}
+	if s.repo != nil {
+		if err := s.repo.CreateRoom(id, stored.State); err != nil {
+			if errors.Is(err, ErrRoomExists) {
+				return nil, err
+			}
+			return nil, notSaved(err)
+		}
 	}
+
+	room := s.newRoom(stored)
+	shard.rooms[id] = room
+	s.count.Add(1)
+	return room, nil
 }
 
-func (rm *RoomManager) CreateRoom(id string) *Room {
-	rm.mu.Lock()
-	defer rm.mu.Unlock()
+func (s *roomSet) GetRoom(id string) *Room {
+	shard := s.shard(id)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
 
-	room := &Room{
-		ID:      id,
-		Players: make([]Player, 0),
-		Status:  "WAITING",
+	return shard.rooms[id]
+}
+
+// DeleteRoom removes a room and returns it, or nil if there was no such
+// room. If the room can't be deleted from its Repository it is kept and the
+// delete fails with ErrNotSaved.
+func (s *roomSet) DeleteRoom(id string) (*Room, error) {
+	return s.deleteIf(id, nil)
+}
+
+// CloseRoom deletes a room on behalf of actorID, who must be its host
+func (s *roomSet) CloseRoom(id string, actorID string) (*Room, error) {
+	return s.deleteIf(id, func(room *Room) error {
+		return room.state.RequireHost(actorID)
+	})
+}
+
+// deleteIf deletes a room if check, run with the room locked, allows it. A
+// nil check always allows it. It returns nil and no error if there was no
+// such room.
+func (s *roomSet) deleteIf(id string, check func(room *Room) error) (*Room, error) {
+	shard := s.shard(id)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	room := shard.rooms[id]
+	if room == nil {
+		return nil, nil
 	}
 
-	rm.rooms[id] = room
-	return room
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if check != nil {
+		if err := check(room); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.removeLocked(shard, room); err != nil {
+		return nil, err
+	}
+	return room, nil
 }
 
-func (rm *RoomManager) GetRoom(id string) *Room {
-	rm.mu.RLock()
-	defer rm.mu.RUnlock()
+// removeLocked deletes a room, from its Repository first so a room that
+// can't be deleted there stays live. A room the Repository no longer has is
+// deleted anyway. Detaching the repository under the room lock leaves
+// anyone still holding the room with the copy in memory, so nothing is
+// saved after the room is deleted. Must be called with shard.mu and
+// room.mu held.
+func (s *roomSet) removeLocked(shard *roomShard, room *Room) error {
+	if room.repo != nil {
+		if err := room.repo.DeleteRoom(room.ID); err != nil && !errors.Is(err, ErrRoomNotFound) {
+			return notSaved(err)
+		}
+	}
+	delete(shard.rooms, room.ID)
+	s.count.Add(-1)
+	room.repo = nil
+	return nil
+}
+
+// Count returns how many rooms the store holds, without taking any lock
+func (s *roomSet) Count() int {
+	return int(s.count.Load())
+}
+
+// Range calls fn for every room the store holds until fn returns false.
+// It locks one shard at a time, and none while fn runs, so the rest of the
+// store carries on meanwhile and fn may create or delete rooms. A room
+// created or deleted during Range may or may not be visited.
+func (s *roomSet) Range(fn func(room *Room) bool) {
+	var rooms []*Room
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.RLock()
+		rooms = rooms[:0]
+		for _, room := range shard.rooms {
+			rooms = append(rooms, room)
+		}
+		shard.mu.RUnlock()
+
+		for _, room := range rooms {
+			if !fn(room) {
+				return
+			}
+		}
+	}
+}
+
+// Rooms returns every room the store holds, collected as Range does
+func (s *roomSet) Rooms() []*Room {
+	rooms := make([]*Room, 0, s.Count())
+	s.Range(func(room *Room) bool {
+		rooms = append(rooms, room)
+		return true
+	})
+	return rooms
+}
+
+func (r *Room) notifyPhaseChange(from Phase, to Phase) {
+	if r.owner == nil {
+		return
+	}
+
+	r.owner.mu.RLock()
+	listener := r.owner.phaseListener
+	r.owner.mu.RUnlock()
+
+	if listener != nil {
+		listener(r.ID, from, to)
+	}
+}
+
+// sameAs reports whether s and other are the same state
+func (s RoomState) sameAs(other RoomState) bool {
+	return slices.Equal(s.Players, other.Players) &&
+		s.Status == other.Status &&
+		s.TotalRounds == other.TotalRounds &&
+		s.RoundsPlayed == other.RoundsPlayed &&
+		s.Scoring == other.Scoring &&
+		s.PlayerCount == other.PlayerCount &&
+		s.Locked == other.Locked &&
+		s.Version == other.Version &&
+		s.DisconnectPolicy == other.DisconnectPolicy
+}
 
-	return rm.rooms[id]
+func (s RoomState) clone() RoomState {
+	players := make([]Player, len(s.Players))
+	copy(players, s.Players)
+	s.Players = players
+	return s
 }
 
-func (r *Room) AddPlayer(player Player) {
+// Transition moves the state to the given phase if the transition table allows it
+func (s *RoomState) Transition(to Phase) error {
+	if !CanTransition(s.Status, to) {
+		return &TransitionError{From: s.Status, To: to}
+	}
+	s.Status = to
+	return nil
+}
+
+// CompleteRound counts the current round as played and moves the state to
+// ROUND_OVER, or to FINISHED once the match is complete
+func (s *RoomState) CompleteRound() error {
+	to := PhaseRoundOver
+	if s.RoundsPlayed+1 >= s.TotalRounds {
+		to = PhaseFinished
+	}
+	if err := s.Transition(to); err != nil {
+		return err
+	}
+	s.RoundsPlayed++
+	return nil
+}
+
+// current returns the room's state, first reading it back from the
+// Repository the room is kept in, if any. Must be called with r.mu held.
+func (r *Room) current() (RoomState, error) {
+	if r.repo == nil {
+		return r.state, nil
+	}
+	stored, err := r.repo.LoadRoom(r.ID)
+	if err != nil {
+		return RoomState{}, err
+	}
+	r.state = stored.State
+	return r.state, nil
+}
+
+// Update runs fn against a copy of the room's state while holding the room
+// lock, so validation and mutation happen atomically. The changes are only
+// committed if fn returns nil, and a phase change made by fn must be allowed
+// by the transition table. Each commit bumps the state's Version; if fn
+// changed nothing there is nothing to commit, so nothing is saved and the
+// room doesn't count as active. A room
+// kept in a Repository is read from it first, and the new state is saved
+// to it before it is committed; the change fails with ErrNotSaved if either
+// can't be done.
+func (r *Room) Update(fn func(state *RoomState) error) error {
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	current, err := r.current()
+	if err != nil {
+		r.mu.Unlock()
+		return notSaved(err)
+	}
+	state := current.clone()
+	from := state.Status
 
-	r.Players = append(r.Players, player)
+	if err := fn(&state); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	if state.Status != from && !CanTransition(from, state.Status) {
+		r.mu.Unlock()
+		return &TransitionError{From: from, To: state.Status}
+	}
+	if state.sameAs(current) {
+		r.mu.Unlock()
+		return nil
+	}
+
+	state.Version = current.Version + 1
+	if r.repo != nil {
+		if err := r.repo.SaveRoom(r.ID, state); err != nil {
+			r.mu.Unlock()
+			return notSaved(err)
+		}
+	}
+	r.state = state
+	to := state.Status
+	now := time.Now()
+	r.lastActive = now
+	if to != from {
+		r.phaseSince = now
+	}
+	r.mu.Unlock()
+
+	if to != from {
+		r.notifyPhaseChange(from, to)
+	}
+	return nil
 }
 
-func (r *Room) UpdateStatus(status string) {
+// Snapshot returns a copy of the room's current state. If a room kept in a
+// Repository can't be read from it, Snapshot returns the last state it read
+// or saved.
+func (r *Room) Snapshot() RoomState {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.Status = status
+	state, err := r.current()
+	if err != nil {
+		state = r.state
+	}
+	return state.clone()
+}
+
+// Events returns the log of messages sent to the room's clients
+func (r *Room) Events() *EventLog {
+	return r.events
+}
+
+func (s *RoomState) playerIndex(playerID string) int {
+	for i, p := range s.Players {
+		if p.ID == playerID {
+			return i
+		}
+	}
+	return -1
+}
+
+// HostID returns the ID of the player seated as host, or "" if there is none
+func (s *RoomState) HostID() string {
+	for _, p := range s.Players {
+		if p.Permission == PermissionHost {
+			return p.ID
+		}
+	}
+	return ""
+}
+
+// RequireHost fails with ErrNotHost unless actorID is the host. Host-only
+// actions call it from the same Update that makes their change, so the host
+// can't change between the check and the change.
+func (s *RoomState) RequireHost(actorID string) error {
+	if s.HostID() != actorID {
+		return ErrNotHost
+	}
+	return nil
+}
+
+// AddPlayer seats a new player. Players can only join an unlocked room while
+// it is WAITING and has fewer than PlayerCount players; a full room fails
+// with ErrRoomFull. The first player seated becomes the host; the seat's
+// permission is always decided here, not by the caller. A new player is
+// disconnected until their first connection registers.
+func (r *Room) AddPlayer(player Player) error {
+	return r.Update(func(state *RoomState) error {
+		return state.AddPlayer(player)
+	})
+}
+
+// AddPlayer seats a player in s under the same rules as Room.AddPlayer
+func (s *RoomState) AddPlayer(player Player) error {
+	if s.Status != PhaseWaiting {
+		return &PhaseError{Op: "join", Phase: s.Status}
+	}
+	if s.Locked {
+		return ErrRoomLocked
+	}
+	if len(s.Players) >= s.PlayerCount {
+		return fmt.Errorf("%w (max %d players)", ErrRoomFull, s.PlayerCount)
+	}
+	player.Permission = PermissionPlayer
+	player.Presence = Presence{Status: PresenceDisconnected}
+	if s.HostID() == "" {
+		player.Permission = PermissionHost
+	}
+	s.Players = append(s.Players, player)
+	return nil
+}
+
+// Departure describes what removing a player did to the room
+type Departure struct {
+	Player Player
+	// Players is the roster left behind
+	Players []Player
+	// RoundAborted is set when the player left mid-match and the room went
+	// back to WAITING
+	RoundAborted bool
+	// NewHostID is set when the host left and the next seat took over
+	NewHostID string
+}
+
+// RemovePlayer unseats a player. If a match is in progress (GUESSING or
+// ROUND_OVER) the role table no longer adds up, so the current round is
+// abandoned unscored, game roles are cleared and the room goes back to
+// WAITING. Scores and rounds played are kept, so the match carries on once
+// the seat is refilled and the host starts again. If the host leaves, the
+// next player in seat order becomes host.
+func (r *Room) RemovePlayer(playerID string) (Departure, error) {
+	var departure Departure
+	err := r.Update(func(state *RoomState) error {
+		return state.removePlayer(playerID, &departure)
+	})
+	return departure, err
+}
+
+// KickPlayer is RemovePlayer on behalf of actorID, who must be the host
+// and can't kick themselves
+func (r *Room) KickPlayer(actorID string, playerID string) (Departure, error) {
+	var departure Departure
+	err := r.Update(func(state *RoomState) error {
+		if err := state.RequireHost(actorID); err != nil {
+			return err
+		}
+		if playerID == actorID {
+			return ErrCannotKickSelf
+		}
+		return state.removePlayer(playerID, &departure)
+	})
+	return departure, err
+}
+
+func (s *RoomState) removePlayer(playerID string, departure *Departure) error {
+	i := s.playerIndex(playerID)
+	if i < 0 {
+		return ErrPlayerNotFound
+	}
+	departure.Player = s.Players[i]
+	s.Players = append(s.Players[:i], s.Players[i+1:]...)
+
+	if s.Status == PhaseGuessing || s.Status == PhaseRoundOver {
+		if err := s.Transition(PhaseWaiting); err != nil {
+			return err
+		}
+		for j := range s.Players {
+			s.Players[j].Role = ""
+		}
+		departure.RoundAborted = true
+	}
+
+	if departure.Player.Permission == PermissionHost && len(s.Players) > 0 {
+		s.Players[0].Permission = PermissionHost
+		departure.NewHostID = s.Players[0].ID
+	}
+
+	departure.Players = make([]Player, len(s.Players))
+	copy(departure.Players, s.Players)
+	return nil
+}
+
+// TransferHost hands host privileges from actorID, who must be the host, to
+// another seated player
+func (r *Room) TransferHost(actorID string, playerID string) error {
+	return r.Update(func(state *RoomState) error {
+		if err := state.RequireHost(actorID); err != nil {
+			return err
+		}
+		to := state.playerIndex(playerID)
+		if to < 0 {
+			return ErrPlayerNotFound
+		}
+		for i := range state.Players {
+			state.Players[i].Permission = PermissionPlayer
+		}
+		state.Players[to].Permission = PermissionHost
+		return nil
+	})
+}
+
+// SetReady marks a player as ready (or not) to start. It only means
+// something in the lobby, so it is refused once the match has started.
+func (r *Room) SetReady(playerID string, ready bool) error {
+	return r.Update(func(state *RoomState) error {
+		if state.Status != PhaseWaiting {
+			return &PhaseError{Op: "change ready state", Phase: state.Status}
+		}
+		i := state.playerIndex(playerID)
+		if i < 0 {
+			return ErrPlayerNotFound
+		}
+		state.Players[i].Ready = ready
+		return nil
+	})
+}
+
+// SetLocked locks or unlocks the room. A locked room refuses new players.
+func (r *Room) SetLocked(locked bool) error {
+	return r.Update(func(state *RoomState) error {
+		state.Locked = locked
+		return nil
+	})
+}
+
+// LockAs locks or unlocks the room on behalf of actorID, who must be the
+// host
+func (r *Room) LockAs(actorID string, locked bool) error {
+	return r.Update(func(state *RoomState) error {
+		if err := state.RequireHost(actorID); err != nil {
+			return err
+		}
+		state.Locked = locked
+		return nil
+	})
 }
 
 func (r *Room) GetPlayers() []Player {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	return r.Snapshot().Players
+}
 
-	playersCopy := make([]Player, len(r.Players))
-	copy(playersCopy, r.Players)
-	return playersCopy
+func (r *Room) GetStatus() Phase {
+	return r.Snapshot().Status
 }
 
-func (r *Room) GetStatus() string {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// SetTotalRounds configures how many rounds the room's match lasts
+func (r *Room) SetTotalRounds(rounds int) error {
+	return r.Update(func(state *RoomState) error {
+		state.TotalRounds = rounds
+		return nil
+	})
+}
 
-	return r.Status
+// SetPlayerCount sets how many players the room is played with, which
+// decides the role table and the join cap
+func (r *Room) SetPlayerCount(count int) error {
+	return r.Update(func(state *RoomState) error {
+		state.PlayerCount = count
+		return nil
+	})
 }
 
-func (r *Room) UpdatePlayersAndStatus(players []Player, status string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// SetScoringPolicy selects the scoring policy the room's rounds are scored with
+func (r *Room) SetScoringPolicy(name string) error {
+	return r.Update(func(state *RoomState) error {
+		state.Scoring = name
+		return nil
+	})
+}
 
-	r.Players = players
-	r.Status = status
+// SetDisconnectPolicy selects what the room does with a round whose player
+// stays disconnected
+func (r *Room) SetDisconnectPolicy(name string) error {
+	return r.Update(func(state *RoomState) error {
+		state.DisconnectPolicy = name
+		return nil
+	})
 }
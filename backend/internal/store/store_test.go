@@ -1,16 +1,19 @@
 package store
 
 import (
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestCreateRoom(t *testing.T) {
 	rm := NewRoomManager()
 
 	roomID := "TEST"
-	room := rm.CreateRoom(roomID)
+	room, _ := rm.CreateRoom(roomID)
 
 	if room == nil {
 		t.Fatal("CreateRoom returned nil")
@@ -20,12 +23,12 @@ func TestCreateRoom(t *testing.T) {
 		t.Errorf("Expected room ID to be %s, got %s", roomID, room.ID)
 	}
 
-	if room.Status != "WAITING" {
-		t.Errorf("Expected room status to be WAITING, got %s", room.Status)
+	if room.GetStatus() != "WAITING" {
+		t.Errorf("Expected room status to be WAITING, got %s", room.GetStatus())
 	}
 
-	if len(room.Players) != 0 {
-		t.Errorf("Expected room to have 0 players, got %d", len(room.Players))
+	if len(room.GetPlayers()) != 0 {
+		t.Errorf("Expected room to have 0 players, got %d", len(room.GetPlayers()))
 	}
 
 	retrievedRoom := rm.GetRoom(roomID)
@@ -46,9 +49,10 @@ func TestCreateRoom(t *testing.T) {
 func TestConcurrentJoins(t *testing.T) {
 	rm := NewRoomManager()
 	roomID := "CONC"
-	room := rm.CreateRoom(roomID)
+	room, _ := rm.CreateRoom(roomID)
 
 	numGoroutines := 10
+	room.SetPlayerCount(numGoroutines)
 
 	var wg sync.WaitGroup
 	wg.Add(numGoroutines)
@@ -60,10 +64,7 @@ func TestConcurrentJoins(t *testing.T) {
 			defer wg.Done()
 
 			player := Player{
-				ID:    fmt.Sprintf("player-%d", playerNum),
-				Name:  fmt.Sprintf("Player %d", playerNum),
-				Role:  "Player",
-				Score: 0,
+				Identity: Identity{ID: fmt.Sprintf("player-%d", playerNum), Name: fmt.Sprintf("Player %d", playerNum)},
 			}
 
 			room.AddPlayer(player)
@@ -105,6 +106,41 @@ func TestConcurrentJoins(t *testing.T) {
 	}
 }
 
+// TestConcurrentJoinsRespectCap races more joins than there are seats into
+// many rooms, and checks no room is ever overfilled
+func TestConcurrentJoinsRespectCap(t *testing.T) {
+	rm := NewRoomManager()
+	const rooms, joins = 100, 16
+
+	var wg sync.WaitGroup
+	var full atomic.Int64
+	for r := range rooms {
+		room, _ := rm.CreateRoom(fmt.Sprintf("CAP%d", r))
+		for i := range joins {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := room.AddPlayer(Player{Identity: Identity{ID: fmt.Sprintf("p%d", i)}})
+				if errors.Is(err, ErrRoomFull) {
+					full.Add(1)
+				} else if err != nil {
+					t.Errorf("AddPlayer failed: %v", err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	for _, room := range rm.Rooms() {
+		if n := len(room.GetPlayers()); n != DefaultPlayerCount {
+			t.Errorf("Room %s: expected %d players, got %d", room.ID, DefaultPlayerCount, n)
+		}
+	}
+	if want := int64(rooms * (joins - DefaultPlayerCount)); full.Load() != want {
+		t.Errorf("Expected %d joins refused with ErrRoomFull, got %d", want, full.Load())
+	}
+}
+
 func TestConcurrentRoomOperations(t *testing.T) {
 	rm := NewRoomManager()
 	numOperations := 20
@@ -118,9 +154,8 @@ func TestConcurrentRoomOperations(t *testing.T) {
 
 			if opNum%2 == 0 {
 				roomID := fmt.Sprintf("ROOM-%d", opNum)
-				room := rm.CreateRoom(roomID)
-				if room == nil {
-					t.Errorf("Failed to create room %s", roomID)
+				if _, err := rm.CreateRoom(roomID); err != nil {
+					t.Errorf("Failed to create room %s: %v", roomID, err)
 				}
 			} else {
 				roomID := fmt.Sprintf("ROOM-%d", opNum-1)
@@ -148,31 +183,571 @@ func TestConcurrentRoomOperations(t *testing.T) {
 
 func TestRoomStatusUpdates(t *testing.T) {
 	rm := NewRoomManager()
-	room := rm.CreateRoom("STATUS-TEST")
+	room, _ := rm.CreateRoom("STATUS-TEST")
 
 	numGoroutines := 5
 	var wg sync.WaitGroup
 	wg.Add(numGoroutines)
 
+	var mu sync.Mutex
+	succeeded := 0
+
 	for i := 0; i < numGoroutines; i++ {
 		go func(routineNum int) {
 			defer wg.Done()
 
-			statuses := []string{"WAITING", "PLAYING", "FINISHED"}
-			for _, status := range statuses {
-				room.UpdateStatus(status)
-				currentStatus := room.GetStatus()
-				if currentStatus != "WAITING" && currentStatus != "PLAYING" && currentStatus != "FINISHED" {
-					t.Errorf("Invalid status: %s", currentStatus)
-				}
+			if err := transition(room, PhaseGuessing); err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
 			}
 		}(i)
 	}
 
 	wg.Wait()
 
-	finalStatus := room.GetStatus()
-	if finalStatus != "WAITING" && finalStatus != "PLAYING" && finalStatus != "FINISHED" {
+	if succeeded != 1 {
+		t.Errorf("Expected exactly 1 goroutine to move the room to GUESSING, got %d", succeeded)
+	}
+
+	if finalStatus := room.GetStatus(); finalStatus != PhaseGuessing {
 		t.Errorf("Final status is invalid: %s", finalStatus)
 	}
 }
+
+func TestCompleteRound(t *testing.T) {
+	rm := NewRoomManager()
+	room, _ := rm.CreateRoom("ROUNDS")
+
+	if total := room.Snapshot().TotalRounds; total != DefaultRounds {
+		t.Errorf("Expected default of %d rounds, got %d", DefaultRounds, total)
+	}
+
+	room.SetTotalRounds(2)
+	room.AddPlayer(Player{Identity: Identity{ID: "p1", Name: "P1"}})
+	transition(room, PhaseGuessing)
+
+	completeRound := func() error {
+		return room.Update(func(state *RoomState) error {
+			return state.CompleteRound()
+		})
+	}
+
+	if err := completeRound(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if played := room.Snapshot().RoundsPlayed; played != 1 {
+		t.Errorf("Expected 1 round played, got %d", played)
+	}
+	if room.GetStatus() != PhaseRoundOver {
+		t.Errorf("Expected status ROUND_OVER, got %s", room.GetStatus())
+	}
+
+	if err := completeRound(); err == nil {
+		t.Error("Expected error completing a round that isn't being played")
+	}
+	if played := room.Snapshot().RoundsPlayed; played != 1 {
+		t.Errorf("Expected failed completion to leave 1 round played, got %d", played)
+	}
+
+	transition(room, PhaseGuessing)
+
+	if err := completeRound(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if played := room.Snapshot().RoundsPlayed; played != 2 {
+		t.Errorf("Expected 2 rounds played, got %d", played)
+	}
+	if room.GetStatus() != PhaseFinished {
+		t.Errorf("Expected status FINISHED, got %s", room.GetStatus())
+	}
+}
+
+func TestUpdateRollsBackOnError(t *testing.T) {
+	rm := NewRoomManager()
+	room, _ := rm.CreateRoom("ROLLBACK")
+	room.AddPlayer(Player{Identity: Identity{ID: "p1", Name: "P1"}})
+
+	before := room.Snapshot()
+
+	err := room.Update(func(state *RoomState) error {
+		state.Players[0].Score = 1000
+		state.Players = append(state.Players, Player{Identity: Identity{ID: "p2"}})
+		state.Status = PhaseGuessing
+		return errors.New("abort")
+	})
+	if err == nil || err.Error() != "abort" {
+		t.Fatalf("Expected the callback's error, got %v", err)
+	}
+
+	after := room.Snapshot()
+	if after.Version != before.Version {
+		t.Errorf("Expected version to stay %d, got %d", before.Version, after.Version)
+	}
+	if len(after.Players) != 1 || after.Players[0].Score != 0 {
+		t.Errorf("Expected players to be untouched, got %+v", after.Players)
+	}
+	if after.Status != PhaseWaiting {
+		t.Errorf("Expected status to stay WAITING, got %s", after.Status)
+	}
+}
+
+func TestUpdateWithoutChange(t *testing.T) {
+	repo := NewMemoryRepository()
+	rm, _ := NewRepositoryStore(repo)
+	room, _ := rm.CreateRoom("NOOP")
+	room.SetLocked(true)
+	room.lastActive = time.Time{}
+
+	if err := room.SetLocked(true); err != nil {
+		t.Fatalf("SetLocked failed: %v", err)
+	}
+	if err := room.Update(func(state *RoomState) error { return nil }); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	if version := room.Snapshot().Version; version != 1 {
+		t.Errorf("Expected version to stay 1, got %d", version)
+	}
+	if stored, _ := repo.LoadRoom("NOOP"); stored.State.Version != 1 {
+		t.Errorf("Expected nothing to be saved, got version %d", stored.State.Version)
+	}
+	if !room.lastActive.IsZero() {
+		t.Error("Expected an update that changed nothing not to count as activity")
+	}
+}
+
+func TestUpdateRejectsIllegalTransition(t *testing.T) {
+	rm := NewRoomManager()
+	room, _ := rm.CreateRoom("DIRECT")
+
+	err := room.Update(func(state *RoomState) error {
+		state.Status = PhaseFinished
+		return nil
+	})
+
+	var transitionErr *TransitionError
+	if !errors.As(err, &transitionErr) {
+		t.Fatalf("Expected *TransitionError, got %v", err)
+	}
+	if room.GetStatus() != PhaseWaiting {
+		t.Errorf("Expected status to stay WAITING, got %s", room.GetStatus())
+	}
+}
+
+func TestSnapshotIsACopy(t *testing.T) {
+	rm := NewRoomManager()
+	room, _ := rm.CreateRoom("COPY")
+	room.AddPlayer(Player{Identity: Identity{ID: "p1", Name: "P1"}})
+
+	snapshot := room.Snapshot()
+	snapshot.Players[0].Score = 999
+
+	if room.GetPlayers()[0].Score != 0 {
+		t.Error("Modifying a snapshot must not change the room")
+	}
+}
+
+// TestConcurrentUpdates checks that no read-modify-write is lost under contention
+func TestConcurrentUpdates(t *testing.T) {
+	rm := NewRoomManager()
+	room, _ := rm.CreateRoom("COUNTER")
+	room.AddPlayer(Player{Identity: Identity{ID: "p1", Name: "P1"}})
+	startVersion := room.Snapshot().Version
+
+	numGoroutines := 50
+	var wg sync.WaitGroup
+	wg.Add(numGoroutines)
+
+	for i := 0; i < numGoroutines; i++ {
+		go func() {
+			defer wg.Done()
+			room.Update(func(state *RoomState) error {
+				state.Players[0].Score += 10
+				return nil
+			})
+		}()
+	}
+
+	wg.Wait()
+
+	final := room.Snapshot()
+	if final.Players[0].Score != numGoroutines*10 {
+		t.Errorf("Expected score %d, got %d", numGoroutines*10, final.Players[0].Score)
+	}
+	if final.Version != startVersion+uint64(numGoroutines) {
+		t.Errorf("Expected version %d, got %d", startVersion+uint64(numGoroutines), final.Version)
+	}
+}
+
+// TestHostAndLock tests host assignment, host transfer and room locking
+func TestHostAndLock(t *testing.T) {
+	rm := NewRoomManager()
+	room, _ := rm.CreateRoom("HOST")
+	room.AddPlayer(Player{Identity: Identity{ID: "p1"}})
+	room.AddPlayer(Player{Identity: Identity{ID: "p2"}})
+
+	if hostID(room) != "p1" {
+		t.Errorf("Expected first player to be host, got %q", hostID(room))
+	}
+
+	// The store decides seat permissions, not the caller
+	room.AddPlayer(Player{Identity: Identity{ID: "sneaky"}, Seat: Seat{Permission: PermissionHost}})
+	for _, p := range room.GetPlayers() {
+		if p.ID == "sneaky" && p.Permission != PermissionPlayer {
+			t.Errorf("Expected caller-supplied permission to be ignored, got %s", p.Permission)
+		}
+	}
+
+	if err := room.TransferHost("p1", "p2"); err != nil {
+		t.Fatalf("TransferHost failed: %v", err)
+	}
+	if hostID(room) != "p2" {
+		t.Errorf("Expected p2 to be host, got %q", hostID(room))
+	}
+	for _, p := range room.GetPlayers() {
+		if p.ID == "p1" && p.Permission != PermissionPlayer {
+			t.Errorf("Expected old host to lose host permission, got %s", p.Permission)
+		}
+	}
+	if err := room.TransferHost("p2", "ghost"); !errors.Is(err, ErrPlayerNotFound) {
+		t.Errorf("Expected ErrPlayerNotFound, got %v", err)
+	}
+
+	// The old host's actions are checked against the current host
+	if err := room.TransferHost("p1", "p1"); !errors.Is(err, ErrNotHost) {
+		t.Errorf("Expected ErrNotHost for transfer by old host, got %v", err)
+	}
+	if _, err := room.KickPlayer("p1", "p2"); !errors.Is(err, ErrNotHost) {
+		t.Errorf("Expected ErrNotHost for kick by old host, got %v", err)
+	}
+	if err := room.LockAs("p1", true); !errors.Is(err, ErrNotHost) {
+		t.Errorf("Expected ErrNotHost for lock by old host, got %v", err)
+	}
+	if _, err := rm.CloseRoom(room.ID, "p1"); !errors.Is(err, ErrNotHost) {
+		t.Errorf("Expected ErrNotHost for close by old host, got %v", err)
+	}
+	if _, err := room.KickPlayer("p2", "p2"); !errors.Is(err, ErrCannotKickSelf) {
+		t.Errorf("Expected ErrCannotKickSelf, got %v", err)
+	}
+	if rm.GetRoom(room.ID) == nil {
+		t.Fatal("Expected room to survive a close by the old host")
+	}
+
+	room.SetLocked(true)
+	if err := room.AddPlayer(Player{Identity: Identity{ID: "p3"}}); !errors.Is(err, ErrRoomLocked) {
+		t.Errorf("Expected ErrRoomLocked, got %v", err)
+	}
+	room.SetLocked(false)
+	if err := room.AddPlayer(Player{Identity: Identity{ID: "p3"}}); err != nil {
+		t.Errorf("Expected join after unlock to succeed, got %v", err)
+	}
+}
+
+// TestRemovePlayer tests unseating players, host hand-over and the
+// mid-match abort policy
+func TestRemovePlayer(t *testing.T) {
+	rm := NewRoomManager()
+	room, _ := rm.CreateRoom("KICK")
+	room.AddPlayer(Player{Identity: Identity{ID: "p1", Name: "Alice"}})
+	room.AddPlayer(Player{Identity: Identity{ID: "p2", Name: "Bob"}})
+	room.AddPlayer(Player{Identity: Identity{ID: "p3", Name: "Carol"}})
+
+	departure, err := room.RemovePlayer("p2")
+	if err != nil {
+		t.Fatalf("RemovePlayer failed: %v", err)
+	}
+	if departure.Player.Name != "Bob" || len(departure.Players) != 2 {
+		t.Errorf("Unexpected departure: %+v", departure)
+	}
+	if departure.RoundAborted || departure.NewHostID != "" {
+		t.Errorf("Expected a plain departure while WAITING, got %+v", departure)
+	}
+	if _, err := room.RemovePlayer("p2"); !errors.Is(err, ErrPlayerNotFound) {
+		t.Errorf("Expected ErrPlayerNotFound, got %v", err)
+	}
+
+	room.AddPlayer(Player{Identity: Identity{ID: "p4"}})
+	room.Update(func(state *RoomState) error {
+		for i := range state.Players {
+			state.Players[i].Role = "Raja"
+			state.Players[i].Score = 100
+		}
+		state.RoundsPlayed = 1
+		return state.Transition(PhaseGuessing)
+	})
+
+	departure, err = room.RemovePlayer("p1")
+	if err != nil {
+		t.Fatalf("RemovePlayer during a round failed: %v", err)
+	}
+	if !departure.RoundAborted {
+		t.Error("Expected the round to be aborted")
+	}
+	if departure.NewHostID != "p3" || hostID(room) != "p3" {
+		t.Errorf("Expected p3 to take over as host, got %q", hostID(room))
+	}
+
+	state := room.Snapshot()
+	if state.Status != PhaseWaiting {
+		t.Errorf("Expected room to go back to WAITING, got %s", state.Status)
+	}
+	if state.RoundsPlayed != 1 {
+		t.Errorf("Expected rounds played to be kept, got %d", state.RoundsPlayed)
+	}
+	for _, p := range state.Players {
+		if p.Role != "" || p.Score != 100 {
+			t.Errorf("Expected cleared role and kept score, got %+v", p)
+		}
+	}
+
+	if deleted, err := rm.DeleteRoom("KICK"); err != nil || deleted != room {
+		t.Error("Expected DeleteRoom to return the room")
+	}
+	if rm.GetRoom("KICK") != nil {
+		t.Error("Expected room to be gone after DeleteRoom")
+	}
+}
+
+// TestSetReady tests toggling ready state in the lobby only
+func TestSetReady(t *testing.T) {
+	rm := NewRoomManager()
+	room, _ := rm.CreateRoom("READY")
+	room.AddPlayer(Player{Identity: Identity{ID: "p1"}})
+
+	if err := room.SetReady("p1", true); err != nil {
+		t.Fatalf("SetReady failed: %v", err)
+	}
+	if !room.GetPlayers()[0].Ready {
+		t.Error("Expected p1 to be ready")
+	}
+	if err := room.SetReady("ghost", true); !errors.Is(err, ErrPlayerNotFound) {
+		t.Errorf("Expected ErrPlayerNotFound, got %v", err)
+	}
+
+	transition(room, PhaseGuessing)
+	if err := room.SetReady("p1", false); !errors.Is(err, ErrPhaseConflict) {
+		t.Errorf("Expected phase conflict after start, got %v", err)
+	}
+}
+
+func TestSetPresence(t *testing.T) {
+	rm := NewRoomManager()
+	room, _ := rm.CreateRoom("PRESENCE")
+	room.AddPlayer(Player{Identity: Identity{ID: "p1"}})
+
+	if presence := room.GetPlayers()[0].Presence; presence.Status != PresenceDisconnected || !presence.LastSeen.IsZero() {
+		t.Errorf("Expected a new player to be disconnected and never seen, got %+v", presence)
+	}
+
+	// Presence is tracked in every phase
+	transition(room, PhaseGuessing)
+	seen := time.Now()
+	if err := room.SetPresence("p1", PresenceAway, seen); err != nil {
+		t.Fatalf("SetPresence failed: %v", err)
+	}
+	if presence := room.GetPlayers()[0].Presence; presence.Status != PresenceAway || !presence.LastSeen.Equal(seen) {
+		t.Errorf("Expected p1 to be away as of %v, got %+v", seen, presence)
+	}
+
+	if err := room.SetPresence("ghost", PresenceOnline, seen); !errors.Is(err, ErrPlayerNotFound) {
+		t.Errorf("Expected ErrPlayerNotFound, got %v", err)
+	}
+}
+
+func TestRoomExpiry(t *testing.T) {
+	rm := NewRoomManager()
+	room, _ := rm.CreateRoom("EXPIRE")
+	policy := ExpiryPolicy{IdleTTL: time.Hour, FinishedTTL: 10 * time.Minute}
+
+	at, reason, ok := room.Expiry(policy)
+	if !ok || reason != ExpiryIdle {
+		t.Fatalf("Expected a new room to expire when idle, got %v %q %v", at, reason, ok)
+	}
+	if _, _, ok := room.Expiry(ExpiryPolicy{}); ok {
+		t.Error("Expected a zero policy to never expire the room")
+	}
+
+	if expired, _, _ := rm.ExpireRoom(room.ID, policy, at.Add(-time.Second)); expired != nil {
+		t.Error("Expected the room to be kept until it expires")
+	}
+
+	// Activity pushes the idle expiry back
+	room.Touch(at)
+	if expired, _, _ := rm.ExpireRoom(room.ID, policy, at); expired != nil {
+		t.Error("Expected a touched room to be kept")
+	}
+
+	// A finished room expires after FinishedTTL even though it was just
+	// active
+	transition(room, PhaseGuessing)
+	transition(room, PhaseFinished)
+	finishedAt, reason, _ := room.Expiry(policy)
+	if reason != ExpiryFinished || finishedAt.After(time.Now().Add(policy.FinishedTTL)) {
+		t.Fatalf("Expected the room to expire %v after finishing, got %v %q", policy.FinishedTTL, finishedAt, reason)
+	}
+
+	expired, reason, err := rm.ExpireRoom(room.ID, policy, finishedAt)
+	if err != nil || expired != room || reason != ExpiryFinished {
+		t.Fatalf("Expected the finished room to be expired, got %v %q", expired, reason)
+	}
+	if rm.GetRoom(room.ID) != nil {
+		t.Error("Expected the expired room to be deleted")
+	}
+	if expired, _, _ := rm.ExpireRoom(room.ID, policy, finishedAt); expired != nil {
+		t.Error("Expected a deleted room not to expire twice")
+	}
+}
+
+func TestRoomManagerShards(t *testing.T) {
+	for _, shards := range []int{1, 8} {
+		t.Run(fmt.Sprintf("%d shards", shards), func(t *testing.T) {
+			rm := NewRoomManagerWithShards(shards)
+			for i := range 100 {
+				if _, err := rm.CreateRoom(fmt.Sprintf("R%03d", i)); err != nil {
+					t.Fatalf("CreateRoom failed: %v", err)
+				}
+			}
+			if _, err := rm.CreateRoom("R000"); !errors.Is(err, ErrRoomExists) {
+				t.Errorf("Expected ErrRoomExists, got %v", err)
+			}
+			if rm.Count() != 100 {
+				t.Errorf("Expected 100 rooms, got %d", rm.Count())
+			}
+
+			// Range visits every room once, and fn may delete rooms as it goes
+			seen := make(map[string]int)
+			rm.Range(func(room *Room) bool {
+				seen[room.ID]++
+				if room.ID >= "R050" {
+					rm.DeleteRoom(room.ID)
+				}
+				return true
+			})
+			for id, visits := range seen {
+				if visits != 1 {
+					t.Errorf("Expected %s to be visited once, got %d", id, visits)
+				}
+			}
+			if len(seen) != 100 {
+				t.Errorf("Expected 100 rooms visited, got %d", len(seen))
+			}
+			if rm.Count() != 50 || len(rm.Rooms()) != 50 {
+				t.Errorf("Expected 50 rooms left, got %d counted and %d listed", rm.Count(), len(rm.Rooms()))
+			}
+
+			visited := 0
+			rm.Range(func(*Room) bool {
+				visited++
+				return visited < 10
+			})
+			if visited != 10 {
+				t.Errorf("Expected Range to stop after 10 rooms, got %d", visited)
+			}
+		})
+	}
+}
+
+// lockedRooms is the baseline the sharded RoomManager is benchmarked
+// against: every room in one map behind one lock, with no repository
+type lockedRooms struct {
+	rooms map[string]*Room
+	mu    sync.RWMutex
+}
+
+func (l *lockedRooms) GetRoom(id string) *Room {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.rooms[id]
+}
+
+func (l *lockedRooms) CreateRoom(id string) (*Room, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rooms[id] != nil {
+		return nil, ErrRoomExists
+	}
+	room := &Room{
+		ID: id,
+		state: RoomState{
+			Players:     make([]Player, 0),
+			Status:      PhaseWaiting,
+			TotalRounds: DefaultRounds,
+			PlayerCount: DefaultPlayerCount,
+		},
+		events:     NewEventLog(DefaultEventBufferSize),
+		lastActive: time.Now(),
+	}
+	l.rooms[id] = room
+	return room, nil
+}
+
+func (l *lockedRooms) DeleteRoom(id string) (*Room, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	room := l.rooms[id]
+	delete(l.rooms, id)
+	return room, nil
+}
+
+// benchRooms is what the benchmark does to a room table
+type benchRooms interface {
+	GetRoom(id string) *Room
+	CreateRoom(id string) (*Room, error)
+	DeleteRoom(id string) (*Room, error)
+}
+
+// benchmarkRooms runs op on many goroutines against a table holding rooms
+// rooms, whose IDs op is given
+func benchmarkRooms(b *testing.B, table benchRooms, rooms int, op func(table benchRooms, ids []string, i int)) {
+	ids := make([]string, rooms)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("R%d", i)
+		table.CreateRoom(ids[i])
+	}
+
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			op(table, ids, int(next.Add(1)))
+		}
+	})
+}
+
+// BenchmarkRoomManager compares one map behind a single lock with the
+// default shards under parallel load: polling rooms, and polling while rooms
+// come and go. Run it with -cpu to see how each scales.
+func BenchmarkRoomManager(b *testing.B) {
+	const rooms = 10000
+
+	tables := []struct {
+		name string
+		open func() benchRooms
+	}{
+		{"single-lock", func() benchRooms { return &lockedRooms{rooms: make(map[string]*Room)} }},
+		{"sharded", func() benchRooms { return NewRoomManager() }},
+	}
+	for _, table := range tables {
+		b.Run(table.name+"/get", func(b *testing.B) {
+			benchmarkRooms(b, table.open(), rooms, func(table benchRooms, ids []string, i int) {
+				table.GetRoom(ids[i%rooms])
+			})
+		})
+
+		b.Run(table.name+"/mixed", func(b *testing.B) {
+			benchmarkRooms(b, table.open(), rooms, func(table benchRooms, ids []string, i int) {
+				// One in ten operations replaces a room; the rest poll one
+				if id := ids[i%rooms]; i%10 == 0 {
+					table.DeleteRoom(id)
+					table.CreateRoom(id)
+				} else {
+					table.GetRoom(id)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package storetest
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+)
+
+// RunRoomStore runs the conformance suite for store.RoomStore
+// implementations against the stores open returns, which start out empty
+func RunRoomStore(t *testing.T, open func(t *testing.T) store.RoomStore) {
+	t.Run("CreateAndGet", func(t *testing.T) { testStoreCreateAndGet(t, open(t)) })
+	t.Run("Update", func(t *testing.T) { testStoreUpdate(t, open(t)) })
+	t.Run("DeleteAndClose", func(t *testing.T) { testStoreDeleteAndClose(t, open(t)) })
+	t.Run("Expire", func(t *testing.T) { testStoreExpire(t, open(t)) })
+	t.Run("PhaseChanges", func(t *testing.T) { testStorePhaseChanges(t, open(t)) })
+	t.Run("ConcurrentUpdates", func(t *testing.T) { testStoreConcurrentUpdates(t, open(t)) })
+}
+
+func host(id string) store.Player {
+	return store.Player{Identity: store.Identity{ID: id, Name: "Player " + id}}
+}
+
+func testStoreCreateAndGet(t *testing.T, rooms store.RoomStore) {
+	room, err := rooms.CreateRoomWith("ROOM", func(state *store.RoomState) error {
+		state.TotalRounds = 3
+		return state.AddPlayer(host("p1"))
+	})
+	if err != nil {
+		t.Fatalf("CreateRoomWith failed: %v", err)
+	}
+	if rooms.GetRoom("ROOM") != room || rooms.Count() != 1 {
+		t.Fatalf("Expected the room to be found, got %d rooms", rooms.Count())
+	}
+	if state := room.Snapshot(); state.TotalRounds != 3 || state.HostID() != "p1" || state.Status != store.PhaseWaiting {
+		t.Errorf("Expected the room to be created set up, got %+v", state)
+	}
+
+	if _, err := rooms.CreateRoom("ROOM"); !errors.Is(err, store.ErrRoomExists) {
+		t.Errorf("Expected ErrRoomExists, got %v", err)
+	}
+	if _, err := rooms.CreateRoomWith("HALF", func(state *store.RoomState) error {
+		return store.ErrRoomFull
+	}); !errors.Is(err, store.ErrRoomFull) {
+		t.Errorf("Expected setup's error, got %v", err)
+	}
+	if rooms.GetRoom("HALF") != nil || rooms.GetRoom("MISSING") != nil || rooms.Count() != 1 {
+		t.Errorf("Expected only ROOM to exist, got %d rooms", rooms.Count())
+	}
+
+	rooms.CreateRoom("OTHER")
+	seen := make(map[string]bool)
+	rooms.Range(func(room *store.Room) bool {
+		seen[room.ID] = true
+		return true
+	})
+	if len(seen) != 2 || !seen["ROOM"] || !seen["OTHER"] {
+		t.Errorf("Expected Range to visit both rooms, got %v", seen)
+	}
+}
+
+func testStoreUpdate(t *testing.T, rooms store.RoomStore) {
+	room, _ := rooms.CreateRoom("ROOM")
+	if err := room.AddPlayer(host("p1")); err != nil {
+		t.Fatalf("AddPlayer failed: %v", err)
+	}
+	if err := room.SetLocked(true); err != nil {
+		t.Fatalf("SetLocked failed: %v", err)
+	}
+
+	failed := errors.New("refused")
+	if err := room.Update(func(state *store.RoomState) error {
+		state.Locked = false
+		return failed
+	}); !errors.Is(err, failed) {
+		t.Errorf("Expected fn's error, got %v", err)
+	}
+
+	state := rooms.GetRoom("ROOM").Snapshot()
+	if !state.Locked || state.Version != 2 || len(state.Players) != 1 {
+		t.Errorf("Expected two committed changes and none refused, got %+v", state)
+	}
+	if err := room.AddPlayer(host("p2")); !errors.Is(err, store.ErrRoomLocked) {
+		t.Errorf("Expected the locked room to refuse players, got %v", err)
+	}
+}
+
+func testStoreDeleteAndClose(t *testing.T, rooms store.RoomStore) {
+	room, _ := rooms.CreateRoom("ROOM")
+	room.AddPlayer(host("p1"))
+	room.AddPlayer(host("p2"))
+
+	if _, err := rooms.CloseRoom("ROOM", "p2"); !errors.Is(err, store.ErrNotHost) {
+		t.Errorf("Expected only the host to close the room, got %v", err)
+	}
+	if closed, err := rooms.CloseRoom("ROOM", "p1"); err != nil || closed != room {
+		t.Fatalf("Expected the host to close the room, got %v (%v)", closed, err)
+	}
+	if closed, err := rooms.CloseRoom("ROOM", "p1"); err != nil || closed != nil {
+		t.Errorf("Expected nothing to close the second time, got %v (%v)", closed, err)
+	}
+	if rooms.GetRoom("ROOM") != nil || rooms.Count() != 0 {
+		t.Error("Expected the closed room to be gone")
+	}
+
+	rooms.CreateRoom("GONE")
+	if deleted, err := rooms.DeleteRoom("GONE"); err != nil || deleted == nil {
+		t.Errorf("DeleteRoom failed: %v", err)
+	}
+	if deleted, err := rooms.DeleteRoom("GONE"); err != nil || deleted != nil {
+		t.Errorf("Expected nothing to delete the second time, got %v (%v)", deleted, err)
+	}
+	if _, err := rooms.CreateRoom("GONE"); err != nil {
+		t.Errorf("Expected a deleted room's ID to be free again, got %v", err)
+	}
+}
+
+func testStoreExpire(t *testing.T, rooms store.RoomStore) {
+	rooms.CreateRoom("ROOM")
+	policy := store.ExpiryPolicy{IdleTTL: time.Hour}
+
+	if expired, _, err := rooms.ExpireRoom("ROOM", policy, time.Now()); err != nil || expired != nil {
+		t.Errorf("Expected an active room to be kept, got %v (%v)", expired, err)
+	}
+	expired, reason, err := rooms.ExpireRoom("ROOM", policy, time.Now().Add(2*time.Hour))
+	if err != nil || expired == nil || reason != store.ExpiryIdle {
+		t.Fatalf("Expected the idle room to expire, got %v %q (%v)", expired, reason, err)
+	}
+	if rooms.GetRoom("ROOM") != nil {
+		t.Error("Expected the expired room to be gone")
+	}
+}
+
+func testStorePhaseChanges(t *testing.T, rooms store.RoomStore) {
+	var mu sync.Mutex
+	var changes []string
+	rooms.OnPhaseChange(func(roomID string, from store.Phase, to store.Phase) {
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, roomID+":"+string(from)+">"+string(to))
+	})
+
+	room, _ := rooms.CreateRoom("ROOM")
+	room.SetLocked(true)
+	if err := room.Update(func(state *store.RoomState) error {
+		return state.Transition(store.PhaseGuessing)
+	}); err != nil {
+		t.Fatalf("Transition failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(changes) != 1 || changes[0] != "ROOM:WAITING>GUESSING" {
+		t.Errorf("Expected one phase change, got %v", changes)
+	}
+}
+
+func testStoreConcurrentUpdates(t *testing.T, rooms store.RoomStore) {
+	room, _ := rooms.CreateRoom("ROOM")
+	room.AddPlayer(host("p1"))
+
+	const writers, changes = 4, 10
+	var wg sync.WaitGroup
+	for range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range changes {
+				if err := room.Update(func(state *store.RoomState) error {
+					state.Players[0].Score++
+					return nil
+				}); err != nil {
+					t.Errorf("Update failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if state := room.Snapshot(); state.Players[0].Score != writers*changes || state.Version != 1+writers*changes {
+		t.Errorf("Expected every change to be kept, got score %d at version %d", state.Players[0].Score, state.Version)
+	}
+}
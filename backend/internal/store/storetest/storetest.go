@@ -0,0 +1,360 @@
+// Package storetest is a conformance suite for store.Repository and
+// store.RoomStore implementations. Each backend runs it from its own tests.
+package storetest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
+)
+
+// Backend opens the Repository under test
+type Backend struct {
+	// Open opens a repository that keeps its rooms in dir, which starts
+	// out empty. Backends that don't use disk can ignore dir.
+	Open func(t *testing.T, dir string) store.Repository
+	// Durable backends keep their rooms across a Close and a fresh Open of
+	// the same dir
+	Durable bool
+}
+
+// Run runs the conformance suite against a backend
+func Run(t *testing.T, backend Backend) {
+	open := func(t *testing.T) store.Repository {
+		repo := backend.Open(t, t.TempDir())
+		t.Cleanup(func() { repo.Close() })
+		return repo
+	}
+
+	t.Run("CreateAndLoad", func(t *testing.T) { testCreateAndLoad(t, open(t)) })
+	t.Run("CreateTakenID", func(t *testing.T) { testCreateTakenID(t, open(t)) })
+	t.Run("MissingRoom", func(t *testing.T) { testMissingRoom(t, open(t)) })
+	t.Run("SaveVersions", func(t *testing.T) { testSaveVersions(t, open(t)) })
+	t.Run("SaveReplacesPlayers", func(t *testing.T) { testSaveReplacesPlayers(t, open(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, open(t)) })
+	t.Run("ReserveEvents", func(t *testing.T) { testReserveEvents(t, open(t)) })
+	t.Run("ConcurrentSaves", func(t *testing.T) { testConcurrentSaves(t, open(t)) })
+	t.Run("Durability", func(t *testing.T) {
+		if !backend.Durable {
+			t.Skip("backend does not keep rooms across restarts")
+		}
+		testDurability(t, backend)
+	})
+	t.Run("RoomStore", func(t *testing.T) {
+		RunRoomStore(t, func(t *testing.T) store.RoomStore {
+			rooms, err := store.NewRepositoryStore(open(t))
+			if err != nil {
+				t.Fatalf("NewRepositoryStore failed: %v", err)
+			}
+			return rooms
+		})
+	})
+}
+
+// midRound is a room partway through a match: scores built up, roles dealt
+// and the Mantri yet to guess
+func midRound() store.RoomState {
+	seen := time.Date(2025, 12, 11, 21, 2, 28, 123456789, time.Local)
+	return store.RoomState{
+		Players: []store.Player{
+			{Identity: store.Identity{ID: "p1", Name: "Asha"}, Seat: store.Seat{Permission: store.PermissionHost, Score: 1800, Ready: true}, Role: "Raja", Presence: store.Presence{Status: store.PresenceOnline, LastSeen: seen}},
+			{Identity: store.Identity{ID: "p2", Name: "Bilal"}, Seat: store.Seat{Permission: store.PermissionPlayer, Score: 800}, Role: "Mantri", Presence: store.Presence{Status: store.PresenceReconnecting, LastSeen: seen.Add(-time.Second)}},
+			{Identity: store.Identity{ID: "p3", Name: "Chen"}, Seat: store.Seat{Permission: store.PermissionPlayer}, Role: "Chor", Presence: store.Presence{Status: store.PresenceAway, LastSeen: seen}},
+			{Identity: store.Identity{ID: "p4", Name: "Dara"}, Seat: store.Seat{Permission: store.PermissionPlayer, Score: 500}, Role: "Sipahi", Presence: store.Presence{Status: store.PresenceDisconnected}},
+		},
+		Status:           store.PhaseGuessing,
+		TotalRounds:      3,
+		RoundsPlayed:     1,
+		Scoring:          "classic",
+		PlayerCount:      4,
+		Locked:           true,
+		Version:          12,
+		DisconnectPolicy: "forfeit",
+	}
+}
+
+// encode renders a state for comparison. Times are compared as instants,
+// since backends needn't keep their location or monotonic reading.
+func encode(t *testing.T, state store.RoomState) string {
+	t.Helper()
+
+	players := make([]store.Player, len(state.Players))
+	for i, p := range state.Players {
+		if !p.Presence.LastSeen.IsZero() {
+			p.Presence.LastSeen = p.Presence.LastSeen.UTC()
+		}
+		players[i] = p
+	}
+	state.Players = players
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatalf("Failed to encode state: %v", err)
+	}
+	return string(data)
+}
+
+func expectState(t *testing.T, repo store.Repository, id string, want store.RoomState) {
+	t.Helper()
+
+	room, err := repo.LoadRoom(id)
+	if err != nil {
+		t.Fatalf("LoadRoom(%s) failed: %v", id, err)
+	}
+	if room.ID != id {
+		t.Errorf("Expected room %s, got %s", id, room.ID)
+	}
+	if got, want := encode(t, room.State), encode(t, want); got != want {
+		t.Errorf("Room %s differs\nwant %s\ngot  %s", id, want, got)
+	}
+}
+
+func testCreateAndLoad(t *testing.T, repo store.Repository) {
+	state := midRound()
+	if err := repo.CreateRoom("GAME", state); err != nil {
+		t.Fatalf("CreateRoom failed: %v", err)
+	}
+	empty := store.RoomState{Players: []store.Player{}, Status: store.PhaseWaiting, TotalRounds: 1, PlayerCount: 4}
+	if err := repo.CreateRoom("ABCD", empty); err != nil {
+		t.Fatalf("CreateRoom failed: %v", err)
+	}
+
+	expectState(t, repo, "GAME", state)
+	expectState(t, repo, "ABCD", empty)
+
+	rooms, err := repo.LoadRooms()
+	if err != nil {
+		t.Fatalf("LoadRooms failed: %v", err)
+	}
+	if len(rooms) != 2 || rooms[0].ID != "ABCD" || rooms[1].ID != "GAME" {
+		t.Fatalf("Expected rooms ABCD and GAME in order, got %+v", rooms)
+	}
+	if got, want := encode(t, rooms[1].State), encode(t, state); got != want {
+		t.Errorf("LoadRooms differs from what was stored\nwant %s\ngot  %s", want, got)
+	}
+
+	// Changing what was stored, or what was loaded, doesn't reach the
+	// repository
+	state.Players[0].Score = -1
+	rooms[1].State.Players[1].Score = -1
+	expectState(t, repo, "GAME", midRound())
+}
+
+func testCreateTakenID(t *testing.T, repo store.Repository) {
+	first := midRound()
+	if err := repo.CreateRoom("GAME", first); err != nil {
+		t.Fatalf("CreateRoom failed: %v", err)
+	}
+
+	second := store.RoomState{Players: []store.Player{}, Status: store.PhaseWaiting}
+	if err := repo.CreateRoom("GAME", second); !errors.Is(err, store.ErrRoomExists) {
+		t.Errorf("Expected ErrRoomExists, got %v", err)
+	}
+	expectState(t, repo, "GAME", first)
+}
+
+func testMissingRoom(t *testing.T, repo store.Repository) {
+	if _, err := repo.LoadRoom("NONE"); !errors.Is(err, store.ErrRoomNotFound) {
+		t.Errorf("LoadRoom: expected ErrRoomNotFound, got %v", err)
+	}
+	if err := repo.SaveRoom("NONE", store.RoomState{Version: 1}); !errors.Is(err, store.ErrRoomNotFound) {
+		t.Errorf("SaveRoom: expected ErrRoomNotFound, got %v", err)
+	}
+	if err := repo.DeleteRoom("NONE"); !errors.Is(err, store.ErrRoomNotFound) {
+		t.Errorf("DeleteRoom: expected ErrRoomNotFound, got %v", err)
+	}
+	if err := repo.ReserveEvents("NONE", 1024); err != nil {
+		t.Errorf("ReserveEvents: expected a missing room to be ignored, got %v", err)
+	}
+	if rooms, err := repo.LoadRooms(); err != nil || len(rooms) != 0 {
+		t.Errorf("Expected no rooms, got %v, %v", rooms, err)
+	}
+}
+
+func testSaveVersions(t *testing.T, repo store.Repository) {
+	state := midRound()
+	if err := repo.CreateRoom("GAME", state); err != nil {
+		t.Fatalf("CreateRoom failed: %v", err)
+	}
+
+	next := midRound()
+	next.Version++
+	next.Status = store.PhaseRoundOver
+	next.RoundsPlayed++
+	next.Players[0].Score += 1000
+	if err := repo.SaveRoom("GAME", next); err != nil {
+		t.Fatalf("SaveRoom failed: %v", err)
+	}
+	expectState(t, repo, "GAME", next)
+
+	for _, version := range []uint64{next.Version, next.Version + 2, state.Version} {
+		stale := midRound()
+		stale.Version = version
+		stale.Locked = false
+		if err := repo.SaveRoom("GAME", stale); !errors.Is(err, store.ErrVersionConflict) {
+			t.Errorf("Version %d: expected ErrVersionConflict, got %v", version, err)
+		}
+	}
+	expectState(t, repo, "GAME", next)
+}
+
+func testSaveReplacesPlayers(t *testing.T, repo store.Repository) {
+	state := midRound()
+	if err := repo.CreateRoom("GAME", state); err != nil {
+		t.Fatalf("CreateRoom failed: %v", err)
+	}
+
+	// The host leaves mid-round: the round is abandoned and the next seat
+	// takes over
+	next := state
+	next.Version++
+	next.Status = store.PhaseWaiting
+	next.Players = []store.Player{state.Players[1], state.Players[2], state.Players[3]}
+	next.Players[0].Permission = store.PermissionHost
+	for i := range next.Players {
+		next.Players[i].Role = ""
+	}
+	if err := repo.SaveRoom("GAME", next); err != nil {
+		t.Fatalf("SaveRoom failed: %v", err)
+	}
+	expectState(t, repo, "GAME", next)
+
+	next.Version++
+	next.Players = append(next.Players, store.Player{Identity: store.Identity{ID: "p5", Name: "Eun"}, Seat: store.Seat{Permission: store.PermissionPlayer}})
+	if err := repo.SaveRoom("GAME", next); err != nil {
+		t.Fatalf("SaveRoom failed: %v", err)
+	}
+	expectState(t, repo, "GAME", next)
+}
+
+func testDelete(t *testing.T, repo store.Repository) {
+	for _, id := range []string{"KEEP", "GONE"} {
+		if err := repo.CreateRoom(id, midRound()); err != nil {
+			t.Fatalf("CreateRoom failed: %v", err)
+		}
+	}
+	repo.ReserveEvents("GONE", 2048)
+
+	if err := repo.DeleteRoom("GONE"); err != nil {
+		t.Fatalf("DeleteRoom failed: %v", err)
+	}
+	if _, err := repo.LoadRoom("GONE"); !errors.Is(err, store.ErrRoomNotFound) {
+		t.Errorf("Expected the deleted room to be gone, got %v", err)
+	}
+	rooms, _ := repo.LoadRooms()
+	if len(rooms) != 1 || rooms[0].ID != "KEEP" {
+		t.Errorf("Expected only KEEP to be left, got %+v", rooms)
+	}
+
+	// The ID can be used again, for a room that starts from scratch
+	fresh := store.RoomState{Players: []store.Player{}, Status: store.PhaseWaiting, TotalRounds: 1, PlayerCount: 4}
+	if err := repo.CreateRoom("GONE", fresh); err != nil {
+		t.Fatalf("CreateRoom after delete failed: %v", err)
+	}
+	expectState(t, repo, "GONE", fresh)
+	if room, _ := repo.LoadRoom("GONE"); room.EventsReserved != 0 {
+		t.Errorf("Expected a new room to start with no events reserved, got %d", room.EventsReserved)
+	}
+}
+
+func testReserveEvents(t *testing.T, repo store.Repository) {
+	if err := repo.CreateRoom("GAME", midRound()); err != nil {
+		t.Fatalf("CreateRoom failed: %v", err)
+	}
+
+	for _, step := range []struct {
+		upTo uint64
+		want uint64
+	}{{1024, 1024}, {2048, 2048}, {1024, 2048}} {
+		if err := repo.ReserveEvents("GAME", step.upTo); err != nil {
+			t.Fatalf("ReserveEvents failed: %v", err)
+		}
+		room, err := repo.LoadRoom("GAME")
+		if err != nil {
+			t.Fatalf("LoadRoom failed: %v", err)
+		}
+		if room.EventsReserved != step.want {
+			t.Errorf("After reserving up to %d, expected %d reserved, got %d", step.upTo, step.want, room.EventsReserved)
+		}
+	}
+
+	// Reserving doesn't touch the room's state
+	expectState(t, repo, "GAME", midRound())
+}
+
+func testConcurrentSaves(t *testing.T, repo store.Repository) {
+	state := midRound()
+	if err := repo.CreateRoom("GAME", state); err != nil {
+		t.Fatalf("CreateRoom failed: %v", err)
+	}
+
+	// Every writer saves the next version from the same copy; exactly one
+	// of them may win
+	const writers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			next := midRound()
+			next.Version = state.Version + 1
+			next.Players[0].Name = fmt.Sprintf("Writer %d", i)
+			errs <- repo.SaveRoom("GAME", next)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	saved := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			saved++
+		case !errors.Is(err, store.ErrVersionConflict):
+			t.Errorf("Expected ErrVersionConflict for the losing writers, got %v", err)
+		}
+	}
+	if saved != 1 {
+		t.Errorf("Expected exactly one save to win, got %d", saved)
+	}
+}
+
+func testDurability(t *testing.T, backend Backend) {
+	dir := t.TempDir()
+
+	repo := backend.Open(t, dir)
+	state := midRound()
+	if err := repo.CreateRoom("GAME", state); err != nil {
+		t.Fatalf("CreateRoom failed: %v", err)
+	}
+	state.Version++
+	state.Players[1].Score += 400
+	if err := repo.SaveRoom("GAME", state); err != nil {
+		t.Fatalf("SaveRoom failed: %v", err)
+	}
+	repo.ReserveEvents("GAME", 1024)
+	repo.CreateRoom("GONE", midRound())
+	repo.DeleteRoom("GONE")
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	reopened := backend.Open(t, dir)
+	defer reopened.Close()
+
+	expectState(t, reopened, "GAME", state)
+	rooms, err := reopened.LoadRooms()
+	if err != nil {
+		t.Fatalf("LoadRooms failed: %v", err)
+	}
+	if len(rooms) != 1 || rooms[0].EventsReserved != 1024 {
+		t.Errorf("Expected GAME alone with 1024 events reserved, got %+v", rooms)
+	}
+}
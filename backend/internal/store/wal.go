@@ -0,0 +1,135 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// A write-ahead log segment is a file of framed records. Each frame is the
+// payload's length and CRC-32C, both big-endian uint32s, followed by the
+// JSON-encoded record. Segments are named after the seq of their first
+// record so they sort in log order.
+
+const (
+	segmentSuffix  = ".wal"
+	corruptSuffix  = ".corrupt"
+	frameHeaderLen = 8
+	// maxFrameLen guards against allocating whatever a corrupt length says
+	maxFrameLen = 16 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// journalOp is what a record does to its room
+type journalOp string
+
+const (
+	// opCreate adds a room
+	opCreate journalOp = "create"
+	// opPut replaces the room's whole state
+	opPut journalOp = "put"
+	// opDelete removes the room
+	opDelete journalOp = "delete"
+	// opEvents raises the seq the room's event log has reserved
+	opEvents journalOp = "events"
+)
+
+// journalRecord is one entry in the write-ahead log
+type journalRecord struct {
+	Seq    uint64     `json:"seq"`
+	Op     journalOp  `json:"op"`
+	RoomID string     `json:"roomId"`
+	State  *RoomState `json:"state,omitempty"`
+	Events uint64     `json:"events,omitempty"`
+}
+
+func segmentName(firstSeq uint64) string {
+	return fmt.Sprintf("%020d%s", firstSeq, segmentSuffix)
+}
+
+// listSegments returns the paths of the segments in dir, in log order
+func listSegments(dir string) ([]string, error) {
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// encodeFrame frames an encoded record for appending to a segment
+func encodeFrame(record journalRecord) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > maxFrameLen {
+		return nil, errors.New("journal record is too large")
+	}
+
+	frame := make([]byte, frameHeaderLen+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(payload, crcTable))
+	copy(frame[frameHeaderLen:], payload)
+	return frame, nil
+}
+
+// readSegment decodes a segment's records in order and passes each to
+// apply. It stops at the first frame that is cut short, fails its checksum
+// or doesn't decode, or that apply refuses, and returns the offset where the
+// intact records end along with the segment's size. A crash mid-append
+// leaves exactly such a torn frame at the end of the last segment.
+func readSegment(path string, apply func(record journalRecord) bool) (intact int64, size int64, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for offset := 0; ; {
+		if offset == len(data) {
+			return int64(offset), int64(len(data)), nil
+		}
+		if len(data)-offset < frameHeaderLen {
+			break
+		}
+
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		checksum := binary.BigEndian.Uint32(data[offset+4 : offset+8])
+		end := offset + frameHeaderLen + length
+		if length > maxFrameLen || end > len(data) {
+			break
+		}
+
+		payload := data[offset+frameHeaderLen : end]
+		if crc32.Checksum(payload, crcTable) != checksum {
+			break
+		}
+		var record journalRecord
+		if err := json.Unmarshal(payload, &record); err != nil {
+			break
+		}
+		if !apply(record) {
+			break
+		}
+		offset = end
+		intact = int64(offset)
+	}
+	return intact, int64(len(data)), nil
+}
+
+// syncDir makes a rename or removal in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
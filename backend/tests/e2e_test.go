@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/bit2swaz/codechef-recruit/backend/internal/handlers"
+	"github.com/bit2swaz/codechef-recruit/backend/internal/store"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
@@ -22,6 +23,7 @@ import (
 type Player struct {
 	Name         string
 	ID           string
+	Token        string
 	WSConn       *websocket.Conn
 	Messages     []map[string]interface{}
 	MessageMutex sync.Mutex
@@ -39,7 +41,7 @@ func TestE2EFullGame(t *testing.T) {
 
 	// Step 2: Create room via HTTP
 	t.Log("=== Step 1: Creating room ===")
-	roomID := createRoom(t, baseURL, "Alice")
+	roomID, aliceID, aliceToken := createRoom(t, baseURL, "Alice")
 	t.Logf("✓ Room created: %s", roomID)
 
 	// Step 3: Create 4 players
@@ -50,26 +52,24 @@ func TestE2EFullGame(t *testing.T) {
 		{Name: "Diana", ID: ""},
 	}
 
-	// Step 4: Join all players via HTTP first to get player IDs
+	// Step 4: Join all players via HTTP first to get player IDs and tokens
 	t.Log("=== Step 2: Players joining room ===")
 
-	// Alice is already in the room (creator), get her ID
-	roomDetails := getRoomDetails(t, baseURL, roomID)
-	if len(roomDetails.Players) != 1 {
-		t.Fatalf("Expected 1 player after room creation, got %d", len(roomDetails.Players))
-	}
-	players[0].ID = roomDetails.Players[0].ID
+	// Alice is already in the room (creator)
+	players[0].ID = aliceID
+	players[0].Token = aliceToken
 	t.Logf("✓ Alice (creator) ID: %s", players[0].ID)
 
 	// Join remaining 3 players
 	for i := 1; i < 4; i++ {
-		playerID := joinRoom(t, baseURL, roomID, players[i].Name)
+		playerID, token := joinRoom(t, baseURL, roomID, players[i].Name)
 		players[i].ID = playerID
+		players[i].Token = token
 		t.Logf("✓ %s joined with ID: %s", players[i].Name, playerID)
 	}
 
 	// Verify all 4 players are in the room
-	roomDetails = getRoomDetails(t, baseURL, roomID)
+	roomDetails := getRoomDetails(t, baseURL, roomID)
 	if len(roomDetails.Players) != 4 {
 		t.Fatalf("Expected 4 players in room, got %d", len(roomDetails.Players))
 	}
@@ -85,9 +85,9 @@ func TestE2EFullGame(t *testing.T) {
 		go func(p *Player, index int) {
 			defer wg.Done()
 
-			// Connect to WebSocket (URL-encode the player ID)
-			playerIDEncoded := url.QueryEscape(p.ID)
-			wsURLFormatted := fmt.Sprintf("%s/ws/%s?playerId=%s", wsURL, roomID, playerIDEncoded)
+			// Connect to WebSocket (URL-encode the player ID and token)
+			wsURLFormatted := fmt.Sprintf("%s/ws/%s?playerId=%s&token=%s",
+				wsURL, roomID, url.QueryEscape(p.ID), url.QueryEscape(p.Token))
 			dialer := websocket.Dialer{}
 			conn, _, err := dialer.Dial(wsURLFormatted, nil)
 			if err != nil {
@@ -142,7 +142,7 @@ func TestE2EFullGame(t *testing.T) {
 
 	// Step 6: Start the game
 	t.Log("=== Step 4: Starting game ===")
-	startGame(t, baseURL, roomID)
+	startGame(t, baseURL, roomID, players[0].Token)
 	t.Log("✓ Game start request sent")
 
 	// Wait longer for GAME_START and YOUR_ROLE messages to be processed
@@ -202,7 +202,7 @@ func TestE2EFullGame(t *testing.T) {
 	t.Log("=== Step 7: Mantri making guess ===")
 
 	// Mantri guesses the Chor (correct guess)
-	guessResult := submitGuess(t, baseURL, roomID, mantri.ID, chor.ID)
+	guessResult := submitGuess(t, baseURL, roomID, mantri.ID, mantri.Token, chor.ID)
 	t.Logf("✓ Mantri guessed, result: correct=%v", guessResult.Correct)
 
 	// Wait longer for GUESS_RESULT and GAME_END messages
@@ -298,27 +298,45 @@ func TestE2EFullGame(t *testing.T) {
 // Helper functions
 
 func setupTestServer(t *testing.T) *httptest.Server {
-	handlers.InitHub()
+	app := handlers.NewServer(store.NewRoomManager(), handlers.NewHub())
 
 	r := mux.NewRouter()
-	r.HandleFunc("/room/create", handlers.CreateRoom).Methods("POST")
-	r.HandleFunc("/room/join", handlers.JoinRoom).Methods("POST")
-	r.HandleFunc("/room/{roomId}", handlers.GetRoom).Methods("GET")
-	r.HandleFunc("/game/start", handlers.StartGame).Methods("POST")
-	r.HandleFunc("/game/guess", handlers.SubmitGuess).Methods("POST")
-	r.HandleFunc("/ws/{roomId}", handlers.HandleWebSocket).Methods("GET")
+	r.HandleFunc("/room/create", app.CreateRoom).Methods("POST")
+	r.HandleFunc("/room/join", app.JoinRoom).Methods("POST")
+	r.HandleFunc("/room/leave", app.LeaveRoom).Methods("POST")
+	r.HandleFunc("/room/kick", app.KickPlayer).Methods("POST")
+	r.HandleFunc("/room/transfer", app.TransferHost).Methods("POST")
+	r.HandleFunc("/room/lock", app.LockRoom).Methods("POST")
+	r.HandleFunc("/room/close", app.CloseRoom).Methods("POST")
+	r.HandleFunc("/room/{roomId}", app.GetRoom).Methods("GET")
+	r.HandleFunc("/game/start", app.StartGame).Methods("POST")
+	r.HandleFunc("/game/guess", app.SubmitGuess).Methods("POST")
+	r.HandleFunc("/ws/{roomId}", app.HandleWebSocket).Methods("GET")
 
 	return httptest.NewServer(r)
 }
 
-func createRoom(t *testing.T, baseURL, playerName string) string {
-	payload := map[string]string{"playerName": playerName}
+func postJSON(t *testing.T, url string, payload interface{}, token string) *http.Response {
 	jsonData, _ := json.Marshal(payload)
 
-	resp, err := http.Post(baseURL+"/room/create", "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		t.Fatalf("Failed to create room: %v", err)
+		t.Fatalf("Request to %s failed: %v", url, err)
 	}
+	return resp
+}
+
+func createRoom(t *testing.T, baseURL, playerName string) (roomID, playerID, token string) {
+	resp := postJSON(t, baseURL+"/room/create", map[string]string{"playerName": playerName}, "")
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated {
@@ -326,26 +344,22 @@ func createRoom(t *testing.T, baseURL, playerName string) string {
 	}
 
 	var result struct {
-		RoomID string `json:"roomId"`
+		RoomID   string `json:"roomId"`
+		PlayerID string `json:"playerId"`
+		Token    string `json:"token"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		t.Fatalf("Failed to decode response: %v", err)
 	}
 
-	return result.RoomID
+	return result.RoomID, result.PlayerID, result.Token
 }
 
-func joinRoom(t *testing.T, baseURL, roomID, playerName string) string {
-	payload := map[string]string{
+func joinRoom(t *testing.T, baseURL, roomID, playerName string) (playerID, token string) {
+	resp := postJSON(t, baseURL+"/room/join", map[string]string{
 		"roomId":     roomID,
 		"playerName": playerName,
-	}
-	jsonData, _ := json.Marshal(payload)
-
-	resp, err := http.Post(baseURL+"/room/join", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		t.Fatalf("Failed to join room: %v", err)
-	}
+	}, "")
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
@@ -353,19 +367,15 @@ func joinRoom(t *testing.T, baseURL, roomID, playerName string) string {
 		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, string(body))
 	}
 
-	// Get the updated room details to find the new player's ID
-	time.Sleep(100 * time.Millisecond) // Give server time to process
-	roomDetails := getRoomDetails(t, baseURL, roomID)
-
-	// Find the player by name
-	for _, player := range roomDetails.Players {
-		if player.Name == playerName {
-			return player.ID
-		}
+	var result struct {
+		PlayerID string `json:"playerId"`
+		Token    string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
 	}
 
-	t.Fatalf("Could not find player %s in room after joining", playerName)
-	return ""
+	return result.PlayerID, result.Token
 }
 
 type RoomDetails struct {
@@ -397,14 +407,8 @@ func getRoomDetails(t *testing.T, baseURL, roomID string) RoomDetails {
 	return details
 }
 
-func startGame(t *testing.T, baseURL, roomID string) {
-	payload := map[string]string{"roomId": roomID}
-	jsonData, _ := json.Marshal(payload)
-
-	resp, err := http.Post(baseURL+"/game/start", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		t.Fatalf("Failed to start game: %v", err)
-	}
+func startGame(t *testing.T, baseURL, roomID, token string) {
+	resp := postJSON(t, baseURL+"/game/start", map[string]string{"roomId": roomID}, token)
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
@@ -421,18 +425,12 @@ type GuessResult struct {
 	UpdatedScores map[string]int `json:"updatedScores"`
 }
 
-func submitGuess(t *testing.T, baseURL, roomID, mantriID, guessedChorID string) GuessResult {
-	payload := map[string]string{
+func submitGuess(t *testing.T, baseURL, roomID, mantriID, mantriToken, guessedChorID string) GuessResult {
+	resp := postJSON(t, baseURL+"/game/guess", map[string]string{
 		"roomId":              roomID,
 		"mantriPlayerId":      mantriID,
 		"guessedChorPlayerId": guessedChorID,
-	}
-	jsonData, _ := json.Marshal(payload)
-
-	resp, err := http.Post(baseURL+"/game/guess", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		t.Fatalf("Failed to submit guess: %v", err)
-	}
+	}, mantriToken)
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
@@ -0,0 +1,330 @@
+// Code generated by backend/cmd/tsgen. DO NOT EDIT.
+// Regenerate with `go run ./cmd/tsgen` from backend/.
+
+// HTTP API
+
+export interface CreateRoomRequest {
+  playerName: string
+  rounds?: number
+  scoring?: string
+  players?: number
+  onDisconnect?: string
+}
+
+export interface CreateRoomResponse {
+  roomId: string
+  playerId: string
+  token: string
+}
+
+export interface JoinRoomRequest {
+  roomId: string
+  playerName: string
+}
+
+export interface JoinRoomResponse {
+  message: string
+  roomId: string
+  playerId: string
+  token: string
+}
+
+export interface LeaveRoomRequest {
+  roomId: string
+}
+
+export interface RoomDetailsResponse {
+  roomId: string
+  status: string
+  roundsPlayed: number
+  totalRounds: number
+  scoring: string
+  onDisconnect: string
+  maxPlayers: number
+  hostId: string
+  locked: boolean
+  players: PlayerInfoPublic[]
+}
+
+export interface StartGameRequest {
+  roomId: string
+}
+
+export interface SubmitGuessRequest {
+  roomId: string
+  mantriPlayerId: string
+  guessedChorPlayerId: string
+}
+
+export interface GuessResult {
+  Correct: boolean
+  MantriID: string
+  ChorID: string
+  ActualChorID: string
+  Round: number
+  TotalRounds: number
+  MatchOver: boolean
+  RoundScores: Record<string, number>
+  UpdatedScores: Record<string, number>
+}
+
+export interface NextRoundRequest {
+  roomId: string
+}
+
+export interface KickPlayerRequest {
+  roomId: string
+  playerId: string
+}
+
+export interface TransferHostRequest {
+  roomId: string
+  playerId: string
+}
+
+export interface LockRoomRequest {
+  roomId: string
+  locked: boolean | null
+}
+
+export interface CloseRoomRequest {
+  roomId: string
+}
+
+export interface ErrorResponse {
+  error: string
+}
+
+export interface PlayerInfoPublic {
+  id: string
+  name: string
+  permission: string
+  score: number
+  ready: boolean
+  presence: string
+  lastSeen?: number
+}
+
+// WebSocket commands (client to server)
+
+export interface ChatCommand {
+  message: string
+}
+
+export interface GuessCommand {
+  guessedChorPlayerId: string
+}
+
+export interface TargetCommand {
+  playerId: string
+}
+
+export interface PresenceCommand {
+  status: string
+}
+
+export interface ReadyCommand {
+  ready: boolean | null
+}
+
+export type ClientCommand =
+  | { type: "chat"; data: ChatCommand }
+  | { type: "close_room"; data?: Record<string, never> }
+  | { type: "guess"; data: GuessCommand }
+  | { type: "kick_player"; data: TargetCommand }
+  | { type: "leave_room"; data?: Record<string, never> }
+  | { type: "lock_room"; data?: Record<string, never> }
+  | { type: "next_round"; data?: Record<string, never> }
+  | { type: "presence"; data: PresenceCommand }
+  | { type: "ready"; data: ReadyCommand }
+  | { type: "start_game"; data?: Record<string, never> }
+  | { type: "transfer_host"; data: TargetCommand }
+  | { type: "unlock_room"; data?: Record<string, never> }
+
+export type ClientCommandType = ClientCommand["type"]
+
+// WebSocket events (server to client)
+export const PROTOCOL_VERSION = 1
+export const SUBPROTOCOL_V1 = "rmcs.v1"
+
+export interface Envelope<T extends string = string, P = unknown> {
+  v: number
+  id: string
+  type: T
+  seq?: number
+  roomId: string
+  timestamp: number
+  payload: P
+}
+
+export interface ConnectedPayload {
+  message: string
+}
+
+export interface PlayerConnectedPayload {
+  playerId: string
+  playerCount: number
+}
+
+export interface PlayerDisconnectedPayload {
+  playerId: string
+  playerCount: number
+}
+
+export interface ErrorPayload {
+  command: string
+  code: string
+  error: string
+}
+
+export interface PlayerJoinedPayload {
+  name: string
+  playerId: string
+}
+
+export interface PlayerLeftPayload {
+  name: string
+  playerId: string
+  roundAborted: boolean
+  players: PlayerInfoPublic[]
+}
+
+export interface PlayerKickedPayload {
+  name: string
+  playerId: string
+  roundAborted: boolean
+  players: PlayerInfoPublic[]
+}
+
+export interface HostChangedPayload {
+  previousHostId: string
+  hostId: string
+}
+
+export interface RoomLockedPayload {
+  locked: boolean
+}
+
+export interface RoomUnlockedPayload {
+  locked: boolean
+}
+
+export interface RoomClosedPayload {
+  reason: string
+}
+
+export interface RoomExpiringPayload {
+  reason: string
+  expiresAt: number
+}
+
+export interface PlayerReadyPayload {
+  playerId: string
+  ready: boolean
+}
+
+export interface ChatPayload {
+  playerId: string
+  name: string
+  message: string
+}
+
+export interface PhaseChangedPayload {
+  from: string
+  to: string
+}
+
+export interface PresenceChangedPayload {
+  playerId: string
+  presence: string
+  lastSeen: number
+}
+
+export interface DisconnectResolvedPayload {
+  playerId: string
+  policy: string
+  roundAborted: boolean
+}
+
+export interface GameStartPayload {
+  message: string
+}
+
+export interface YourRolePayload {
+  role: string
+  name: string
+}
+
+export interface GuessResultPayload {
+  mantri: string
+  correct: boolean
+  round: number
+  totalRounds: number
+  roundScores: Record<string, number>
+  scores: Record<string, number>
+}
+
+export interface RoundEndPayload {
+  round: number
+  totalRounds: number
+  standings: Standing[]
+}
+
+export interface NextRoundPayload {
+  round: number
+  totalRounds: number
+}
+
+export interface GameEndPayload {
+  message: string
+  scores: Record<string, number>
+  standings: Standing[]
+}
+
+export interface SnapshotPayload {
+  room: RoomDetailsResponse
+  you?: PlayerView
+}
+
+export interface Standing {
+  rank: number
+  playerId: string
+  name: string
+  score: number
+}
+
+export interface PlayerView {
+  id: string
+  name: string
+  permission: string
+  role?: string
+  score: number
+}
+
+export type ServerEvent =
+  | Envelope<"CONNECTED", ConnectedPayload>
+  | Envelope<"PLAYER_CONNECTED", PlayerConnectedPayload>
+  | Envelope<"PLAYER_DISCONNECTED", PlayerDisconnectedPayload>
+  | Envelope<"ERROR", ErrorPayload>
+  | Envelope<"PLAYER_JOINED", PlayerJoinedPayload>
+  | Envelope<"PLAYER_LEFT", PlayerLeftPayload>
+  | Envelope<"PLAYER_KICKED", PlayerKickedPayload>
+  | Envelope<"HOST_CHANGED", HostChangedPayload>
+  | Envelope<"ROOM_LOCKED", RoomLockedPayload>
+  | Envelope<"ROOM_UNLOCKED", RoomUnlockedPayload>
+  | Envelope<"ROOM_CLOSED", RoomClosedPayload>
+  | Envelope<"ROOM_EXPIRING", RoomExpiringPayload>
+  | Envelope<"PLAYER_READY", PlayerReadyPayload>
+  | Envelope<"CHAT", ChatPayload>
+  | Envelope<"PHASE_CHANGED", PhaseChangedPayload>
+  | Envelope<"PRESENCE_CHANGED", PresenceChangedPayload>
+  | Envelope<"DISCONNECT_RESOLVED", DisconnectResolvedPayload>
+  | Envelope<"GAME_START", GameStartPayload>
+  | Envelope<"YOUR_ROLE", YourRolePayload>
+  | Envelope<"GUESS_RESULT", GuessResultPayload>
+  | Envelope<"ROUND_END", RoundEndPayload>
+  | Envelope<"NEXT_ROUND", NextRoundPayload>
+  | Envelope<"GAME_END", GameEndPayload>
+  | Envelope<"SNAPSHOT", SnapshotPayload>
+
+export type ServerEventType = ServerEvent["type"]